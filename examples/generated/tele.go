@@ -0,0 +1,24 @@
+// Package generated shows the output of fluxgen
+// for the same Tele type used in the demo client.
+package generated
+
+//go:generate fluxgen
+
+import (
+	"github.com/A2B-Bikeshare/go-flux/msg"
+)
+
+// Tele is encoded with fluxgen-generated methods
+//
+//flux:gen
+type Tele struct {
+	Name  string
+	Dir   string
+	Val   float64 `flux:"val,float64"`
+	Uid   uint64
+	Chrg  int64
+	Level int8
+	Data  []byte
+	Ext   *msg.PackExt
+	cache string
+}
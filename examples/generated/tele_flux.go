@@ -0,0 +1,173 @@
+// Code generated by fluxgen; DO NOT EDIT.
+
+package generated
+
+import "github.com/A2B-Bikeshare/go-flux/msg"
+
+// TeleSchema is the msg.Schema for Tele
+var TeleSchema = msg.Schema{
+	{Name: "name", T: msg.String},
+	{Name: "dir", T: msg.String},
+	{Name: "val", T: msg.Float},
+	{Name: "uid", T: msg.Uint},
+	{Name: "chrg", T: msg.Int},
+	{Name: "level", T: msg.Int},
+	{Name: "data", T: msg.Bin},
+	{Name: "ext", T: msg.Ext},
+}
+
+// Encode implements msg.Encoder
+func (z *Tele) Encode(w msg.Writer) error {
+	msg.WriteString(w, z.Name)
+	msg.WriteString(w, z.Dir)
+	msg.WriteFloat64(w, z.Val)
+	msg.WriteUint(w, z.Uid)
+	msg.WriteInt(w, z.Chrg)
+	msg.WriteInt(w, int64(z.Level))
+	msg.WriteBin(w, z.Data)
+	if z.Ext == nil {
+		return msg.ErrIncorrectType
+	}
+	msg.WriteExt(w, z.Ext.EType, z.Ext.Data)
+	return nil
+}
+
+// Decode implements msg.Decoder
+func (z *Tele) Decode(r msg.Reader) error {
+	{
+		v, err := msg.ReadString(r)
+		if err != nil {
+			return err
+		}
+		z.Name = v
+	}
+	{
+		v, err := msg.ReadString(r)
+		if err != nil {
+			return err
+		}
+		z.Dir = v
+	}
+	{
+		v, err := msg.ReadFloat(r)
+		if err != nil {
+			return err
+		}
+		z.Val = v
+	}
+	{
+		v, err := msg.ReadUint(r)
+		if err != nil {
+			return err
+		}
+		z.Uid = v
+	}
+	{
+		v, err := msg.ReadInt(r)
+		if err != nil {
+			return err
+		}
+		z.Chrg = v
+	}
+	{
+		v, err := msg.ReadInt(r)
+		if err != nil {
+			return err
+		}
+		if int64(int8(v)) != v {
+			return msg.ErrOverflow
+		}
+		z.Level = int8(v)
+	}
+	{
+		v, err := msg.ReadBin(r, z.Data)
+		if err != nil {
+			return err
+		}
+		z.Data = v
+	}
+	{
+		v, err := msg.ReadExt(r, nil)
+		if err != nil {
+			return err
+		}
+		z.Ext = v
+	}
+	return nil
+}
+
+// DecodeBytes decodes Tele directly from 'p', returning
+// the number of bytes read. String and binary fields
+// point into 'p', so 'p' must not be modified afterwards.
+func (z *Tele) DecodeBytes(p []byte) (int, error) {
+	var nr int
+	{
+		v, n, err := msg.ReadStringZeroCopy(p[nr:])
+		if err != nil {
+			return nr, err
+		}
+		z.Name = v
+		nr += n
+	}
+	{
+		v, n, err := msg.ReadStringZeroCopy(p[nr:])
+		if err != nil {
+			return nr, err
+		}
+		z.Dir = v
+		nr += n
+	}
+	{
+		v, n, err := msg.ReadFloatBytes(p[nr:])
+		if err != nil {
+			return nr, err
+		}
+		z.Val = v
+		nr += n
+	}
+	{
+		v, n, err := msg.ReadUintBytes(p[nr:])
+		if err != nil {
+			return nr, err
+		}
+		z.Uid = v
+		nr += n
+	}
+	{
+		v, n, err := msg.ReadIntBytes(p[nr:])
+		if err != nil {
+			return nr, err
+		}
+		z.Chrg = v
+		nr += n
+	}
+	{
+		v, n, err := msg.ReadIntBytes(p[nr:])
+		if err != nil {
+			return nr, err
+		}
+		if int64(int8(v)) != v {
+			return nr, msg.ErrOverflow
+		}
+		z.Level = int8(v)
+		nr += n
+	}
+	{
+		v, n, err := msg.ReadBinZeroCopy(p[nr:])
+		if err != nil {
+			return nr, err
+		}
+		z.Data = v
+		nr += n
+	}
+	{
+		dat, etype, n, err := msg.ReadExtZeroCopy(p[nr:])
+		if err != nil {
+			return nr, err
+		}
+		v := &msg.PackExt{EType: etype, Data: dat}
+		z.Ext = v
+		nr += n
+	}
+	return nr, nil
+}
@@ -0,0 +1,128 @@
+// Code generated by fluxgen; DO NOT EDIT.
+
+package generated
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/A2B-Bikeshare/go-flux/msg"
+)
+
+func makeTeleFlux() *Tele {
+	return &Tele{
+		Name:  "name",
+		Dir:   "dir",
+		Val:   1.5,
+		Uid:   42,
+		Chrg:  -42,
+		Level: -42,
+		Data:  []byte{1, 2, 3},
+		Ext:   &msg.PackExt{EType: 1, Data: []byte{1, 2, 3, 4}},
+	}
+}
+
+func TestTeleFluxRoundTrip(t *testing.T) {
+	in := makeTeleFlux()
+	buf := bytes.NewBuffer(nil)
+	err := in.Encode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+
+	mbts, err := msg.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mbts, bts) {
+		t.Errorf("Encode wrote %x; msg.Marshal wrote %x", bts, mbts)
+	}
+	err = TeleSchema.Verify(in)
+	if err != nil {
+		t.Error(err)
+	}
+
+	out := new(Tele)
+	err = out.Decode(bytes.NewReader(bts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Decode: expected %#v; got %#v", in, out)
+	}
+
+	out = new(Tele)
+	n, err := out.DecodeBytes(bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(bts) {
+		t.Errorf("DecodeBytes read %d bytes; expected %d", n, len(bts))
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("DecodeBytes: expected %#v; got %#v", in, out)
+	}
+}
+
+func BenchmarkTeleFluxEncode(b *testing.B) {
+	b.ReportAllocs()
+	in := makeTeleFlux()
+	buf := bytes.NewBuffer(nil)
+	in.Encode(buf)
+	b.SetBytes(int64(buf.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		in.Encode(buf)
+	}
+}
+
+func BenchmarkTeleFluxDecode(b *testing.B) {
+	b.ReportAllocs()
+	buf := bytes.NewBuffer(nil)
+	makeTeleFlux().Encode(buf)
+	bts := buf.Bytes()
+	out := new(Tele)
+	b.SetBytes(int64(len(bts)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := out.Decode(bytes.NewReader(bts))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTeleFluxDecodeBytes(b *testing.B) {
+	b.ReportAllocs()
+	buf := bytes.NewBuffer(nil)
+	makeTeleFlux().Encode(buf)
+	bts := buf.Bytes()
+	out := new(Tele)
+	b.SetBytes(int64(len(bts)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := out.DecodeBytes(bts)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTeleFluxSchemaDecodeToSlice(b *testing.B) {
+	b.ReportAllocs()
+	buf := bytes.NewBuffer(nil)
+	makeTeleFlux().Encode(buf)
+	bts := buf.Bytes()
+	out := make([]interface{}, len(TeleSchema))
+	b.SetBytes(int64(len(bts)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := TeleSchema.DecodeToSlice(bytes.NewReader(bts), out)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -10,16 +10,53 @@ import (
 // ElasticsearchDB conforms to the
 // DB interface. It POSTs to http://{Addr}/{Index}/{Dtype}/
 // using the output of Translate() as the message body.
+// If Ref is non-nil, Init replaces Schema with the referenced Schema.
+// If MsgpackMap is true, messages are standard MessagePack maps
+// rather than positional messages (see msg.Schema.FromMsgpackMap).
+// If Fields is non-empty, only the named fields are indexed.
+// If JSON is non-nil, it controls how values are rendered
+// (see msg.Schema.WriteJSONWith).
 type ElasticsearchDB struct {
-	Schema msg.Schema
-	Addr   string
-	Index  string
-	Dtype  string
-	fqaddr string
+	Schema     msg.Schema
+	Ref        *SchemaRef
+	MsgpackMap bool
+	Fields     []string
+	JSON       *msg.JSONOptions
+	Addr       string
+	Index      string
+	Dtype      string
+	fqaddr     string
+	fps        []msg.Fingerprint
+	proj       *msg.Projection
+	plan       *msg.Plan
 }
 
+// Init resolves e.Ref (if it is set), computes the
+// endpoint address, and compiles the Schema, returning
+// an error if it cannot be compiled. It is called by Server.Run().
 func (e *ElasticsearchDB) Init() error {
+	if e.Ref != nil {
+		s, err := e.Ref.Schema()
+		if err != nil {
+			return err
+		}
+		e.Schema = s
+	}
 	e.fqaddr = fmt.Sprintf("%s/%s/%s", e.Addr, e.Index, e.Dtype)
+	e.fps = e.Schema.Fingerprints()
+	e.proj = nil
+	pl, err := e.Schema.Compile()
+	if err != nil {
+		return err
+	}
+	e.plan = pl
+	if len(e.Fields) > 0 {
+		p, err := e.Schema.Project(e.Fields...)
+		if err != nil {
+			return err
+		}
+		e.proj = p
+	}
 	return nil
 }
 
@@ -28,10 +65,39 @@ func (e *ElasticsearchDB) Address() string {
 	return e.fqaddr
 }
 
-// Translate uses e.Schema to write json into 'w'.
+// Translate uses e.Schema to write json into 'w'
+// (including only e.Fields, if it is set).
 // Per the elasticsearch type specification,
-// binary types are encoded to base64-encoded quoted strings.
-func (e *ElasticsearchDB) Translate(p []byte, w msg.Writer) error { return e.Schema.WriteJSON(p, w) }
+// binary types are encoded to base64-encoded quoted strings,
+// times are encoded as RFC3339 strings (which elasticsearch
+// maps onto "date" fields), and nil Optional values are encoded as null.
+// Other renderings can be chosen with e.JSON.
+// Messages with an envelope (see msg.WriteEnvelope) must carry the
+// Fingerprint of a version of e.Schema (see msg.Schema.Fingerprints),
+// or Translate returns a *msg.FingerprintError.
+// Malformed messages are reported with a *msg.TagError or *msg.ShortError.
+func (e *ElasticsearchDB) Translate(p []byte, w msg.Writer) error {
+	p, err := msg.Unwrap(p, e.fps...)
+	if err != nil {
+		return err
+	}
+	if e.MsgpackMap {
+		buf := getBuf()
+		defer putBuf(buf)
+		err = e.Schema.FromMsgpackMap(p, buf)
+		if err != nil {
+			return err
+		}
+		p = buf.Bytes()
+	}
+	if e.proj != nil {
+		return e.proj.WriteJSONWith(p, w, e.JSON)
+	}
+	if e.plan != nil {
+		return e.plan.WriteJSONWith(p, w, e.JSON)
+	}
+	return e.Schema.WriteJSONWith(p, w, e.JSON)
+}
 
 // Req returns the proper POST request to Addr/Index/Dtype
 func (e *ElasticsearchDB) Req(r io.Reader) (*http.Request) {
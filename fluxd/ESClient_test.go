@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"github.com/A2B-Bikeshare/go-flux/msg"
 	"reflect"
 	"testing"
@@ -89,3 +90,114 @@ func TestESTranslate(t *testing.T) {
 		t.Errorf("%t != %t", m["is_true"], testdata[5])
 	}
 }
+
+func TestESTranslateMsgpackMap(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := testdb.Schema.EncodeSlice(testdata, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := bytes.NewBuffer(nil)
+	err = testdb.Translate(buf.Bytes(), expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mbuf := bytes.NewBuffer(nil)
+	err = testdb.Schema.ToMsgpackMap(buf.Bytes(), mbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := testdb
+	db.MsgpackMap = true
+	outbuf := bytes.NewBuffer(nil)
+	err = db.Translate(mbuf.Bytes(), outbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outbuf.String() != expect.String() {
+		t.Errorf("Expected %s; got %s", expect.String(), outbuf.String())
+	}
+}
+
+func TestESTranslateInvalid(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := testdb.Schema.EncodeSlice(testdata, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := buf.Bytes()
+	err = testdb.Translate(p[:len(p)-1], bytes.NewBuffer(nil))
+	serr, ok := err.(*msg.ShortError)
+	if !ok {
+		t.Fatalf("Expected a *msg.ShortError; got %v", err)
+	}
+	if serr.Field != "is_true" || !errors.Is(err, msg.ErrShortBytes) {
+		t.Errorf("Expected an error for \"is_true\"; got %s", serr)
+	}
+}
+
+func TestESTranslateFields(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := testdb.Schema.EncodeSlice(testdata, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := testdb
+	db.Fields = []string{"name", "is_true"}
+	err = db.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbuf := bytes.NewBuffer(nil)
+	err = db.Translate(buf.Bytes(), outbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"name":"bob","is_true":true}`
+	if outbuf.String() != expect {
+		t.Errorf("Expected %s; got %s", expect, outbuf.String())
+	}
+
+	db.Fields = []string{"nope"}
+	if db.Init() == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+
+	db = ElasticsearchDB{Schema: msg.Schema{{Name: "a", T: msg.Array, Elem: msg.Struct}}}
+	if db.Init() == nil {
+		t.Error("Expected an error for a Schema that cannot be compiled")
+	}
+}
+
+func TestESTranslateJSONOptions(t *testing.T) {
+	p, err := testdb.Schema.AppendSlice(nil, testdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := testdb
+	db.JSON = &msg.JSONOptions{Bin: msg.BinHex, FloatFormat: 'e', FloatPrecision: 1}
+	for _, fields := range [][]string{nil, {"data", "weight"}} {
+		db.Fields = fields
+		err = db.Init()
+		if err != nil {
+			t.Fatal(err)
+		}
+		outbuf := bytes.NewBuffer(nil)
+		err = db.Translate(p, outbuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]interface{})
+		err = json.Unmarshal(outbuf.Bytes(), &m)
+		if err != nil {
+			t.Fatalf("%s: %s", outbuf.Bytes(), err)
+		}
+		if m["data"] != "23477f3c" {
+			t.Errorf("Fields %v: expected hex data; got %v", fields, m["data"])
+		}
+		if !bytes.Contains(outbuf.Bytes(), []byte(`"weight":1.5e+02`)) {
+			t.Errorf("Fields %v: expected weight in 'e' format; got %s", fields, outbuf.Bytes())
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package fluxd
 
 import (
+	"bytes"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 var (
@@ -16,19 +18,57 @@ var (
 	econcat  = []byte(",")
 )
 
+// errSeriesName is returned for a Schema
+// that does not start with the series name
+var errSeriesName = errors.New("The first member of an InfluxDB Schema must be a string.")
+
 // InfluxDB implements the BatchBinding interface.
 // It uses the first field in the Schema as the series name.
+// If Ref is non-nil, Init replaces Schema with the referenced Schema.
+// If MsgpackMap is true, messages are standard MessagePack maps
+// rather than positional messages (see msg.Schema.FromMsgpackMap).
 type InfluxDB struct {
-	Schema msg.Schema
-	Addr   string
-	DBname string
-	fqaddr string
+	Schema     msg.Schema
+	Ref        *SchemaRef
+	MsgpackMap bool
+	Addr       string
+	DBname     string
+	fqaddr     string
+	fps        []msg.Fingerprint
+	plan       *msg.Plan
+	inline     bool   // whether or not the Schema has Maps
+	ti         int    // see timeIndex
+	cols       []byte // quoted column names, if there are no Maps
 }
 
 // Init must be called before the call to Server.Run()
 // in order to initialize unexported struct members.
+// It compiles the Schema, and returns an error if it
+// cannot be compiled or does not start with a String.
 func (d *InfluxDB) Init() error {
+	if d.Ref != nil {
+		s, err := d.Ref.Schema()
+		if err != nil {
+			return err
+		}
+		d.Schema = s
+	}
+	if len(d.Schema) == 0 || d.Schema[0].T != msg.String {
+		return errSeriesName
+	}
+	pl, err := d.Schema.Compile()
+	if err != nil {
+		return err
+	}
+	d.plan = pl
 	d.fqaddr = fmt.Sprintf("%s/db/%s/series?u=root&p=root", d.Addr, d.DBname)
+	d.fps = d.Schema.Fingerprints()
+	d.inline = hasMap(d.Schema)
+	d.ti = timeIndex(d.Schema)
+	d.cols = nil
+	if !d.inline {
+		d.cols = columns(d.Schema)
+	}
 	return nil
 }
 
@@ -44,126 +84,293 @@ func (d *InfluxDB) Address() string {
 // be written as "flat" data. The first value in the Schema is assumed to
 // be the series name. (Any other arrangement requires a significantly more
 // complicated implementation.)
+// Optional values that are nil are written as null points.
+// Each key in a msg.Map is written as an extra column named "{name}.{key}".
+// The first msg.Time value is written as InfluxDB's "time" column (in
+// milliseconds since the epoch); any others are written as milliseconds
+// under their own names. Objects that are newer than the message
+// (see msg.Object.Since) are written with their Default values.
+// Messages with an envelope (see msg.WriteEnvelope) must carry the
+// Fingerprint of a version of d.Schema (see msg.Schema.Fingerprints),
+// or Translate returns a *msg.FingerprintError.
+// Malformed messages are reported with a *msg.TagError or *msg.ShortError.
 func (d *InfluxDB) Translate(p []byte, w msg.Writer) error {
 	// require Schema[0] to be a string
 	if d.Schema[0].T != msg.String {
-		return errors.New("The first member of an InfluxDB Schema must be a string.")
+		return errSeriesName
+	}
+	p, err := msg.Unwrap(p, d.fps...)
+	if err != nil {
+		return err
 	}
+	if d.MsgpackMap {
+		buf := getBuf()
+		defer putBuf(buf)
+		err = d.Schema.FromMsgpackMap(p, buf)
+		if err != nil {
+			return err
+		}
+		p = buf.Bytes()
+	}
+	return d.translate(p, w)
+}
 
+// translate implements Translate for
+// an unwrapped, positional message
+func (d *InfluxDB) translate(p []byte, w msg.Writer) error {
 	var stackbuf [64]byte
 	stackbuf[0] = 0x2c //comma
 	empty := stackbuf[1:1]
 	comma := stackbuf[0:1]
 	var n int
-	var nr int
-	var err error
+
+	// Init compiles the Schema and precomputes
+	// everything that doesn't depend on 'p'
+	var it *msg.Iterator
+	inline, ti := d.inline, d.ti
+	if d.plan != nil {
+		it = d.plan.NewIterator(p)
+	} else {
+		it = msg.NewIterator(&d.Schema, p)
+		inline, ti = hasMap(d.Schema), timeIndex(d.Schema)
+	}
+
 	// series name
 	w.WriteString("{\"name\":")
-	var namestr string
-	namestr, n, err = msg.ReadStringZeroCopy(p[nr:])
+	it.Next()
+	namestr, err := it.StringZeroCopy()
 	if err != nil {
 		return err
 	}
-	nr += n
 
-	w.Write(strconv.AppendQuote(empty, namestr))
+	name, err := msg.AppendJSONString(empty, namestr, 0)
+	if err != nil {
+		return err
+	}
+	w.Write(name)
 
 	w.WriteString(",\"columns\":[")
-	//loop and write names
-	for i := 1; i < len(d.Schema); i++ {
-		var prepend []byte
-		if i == 1 {
-			prepend = empty
+
+	// map keys aren't known until the points
+	// are read, so in that case the points go
+	// into a buffer while the columns are written
+	pw := w
+	if inline {
+		buf := getBuf()
+		defer putBuf(buf)
+		pw = buf
+	} else {
+		if d.cols != nil {
+			w.Write(d.cols)
 		} else {
-			prepend = comma
+			w.Write(columns(d.Schema))
 		}
-		w.Write(strconv.AppendQuote(prepend, d.Schema[i].Name))
+		w.WriteString("],\"points\":[[")
 	}
 
 	// loop and write points
-	w.WriteString("],\"points\":[[")
-	for i := 1; i < len(d.Schema); i++ {
+	var ncols int
+	for it.Next() {
+		i, o := it.Index(), it.Object()
 		var prepend []byte
-		if i == 1 {
+		if ncols == 0 {
 			prepend = empty
 		} else {
 			prepend = comma
 		}
-		switch d.Schema[i].T {
-		case msg.String:
-			var s string
-			s, n, err = msg.ReadStringZeroCopy(p[nr:])
-			if err != nil {
-				return err
+		// nil Optional values are written as null
+		if it.IsNil() {
+			if o.T == msg.Map {
+				continue
 			}
-			w.Write(strconv.AppendQuote(prepend, s))
-			nr += n
-			continue
-
-		case msg.Float:
-			var f float64
-			f, n, err = msg.ReadFloatBytes(p[nr:])
-			if err != nil {
-				return err
+			if inline {
+				w.Write(appendColumn(prepend, column(o, i, ti)))
 			}
-			w.Write(strconv.AppendFloat(prepend, f, 'f', -1, 64))
-			nr += n
+			pw.Write(append(prepend, "null"...))
+			ncols++
 			continue
-
-		case msg.Int:
-			var i int64
-			i, n, err = msg.ReadIntBytes(p[nr:])
-			if err != nil {
-				return err
+		}
+		if o.T != msg.Map {
+			if inline {
+				w.Write(appendColumn(prepend, column(o, i, ti)))
 			}
-			w.Write(strconv.AppendInt(prepend, i, 10))
-			nr += n
-			continue
-
-		case msg.Uint:
-			var u uint64
-			u, n, err = msg.ReadUintBytes(p[nr:])
+			_, err = writePoint(it.Raw(), o.T, prepend, pw)
 			if err != nil {
 				return err
 			}
-			w.Write(strconv.AppendUint(prepend, u, 10))
-			nr += n
+			ncols++
 			continue
+		}
 
-		case msg.Bool:
-			var b bool
-			b, n, err = msg.ReadBoolBytes(p[nr:])
+		m := it.Raw()
+		var sz uint32
+		var nr int
+		sz, nr, err = msg.ReadMapHeaderBytes(m)
+		if err != nil {
+			return err
+		}
+		for j := uint32(0); j < sz; j++ {
+			if ncols == 0 {
+				prepend = empty
+			} else {
+				prepend = comma
+			}
+			var key string
+			key, n, err = msg.ReadStringZeroCopy(m[nr:])
 			if err != nil {
 				return err
 			}
-			w.Write(strconv.AppendBool(prepend, b))
 			nr += n
-			continue
-
-		case msg.Bin:
-			var dat []byte
-			dat, n, err = msg.ReadBinZeroCopy(p[nr:])
+			w.Write(appendColumn(prepend, o.Name+"."+key))
+			n, err = writePoint(m[nr:], o.Elem, prepend, pw)
 			if err != nil {
 				return err
 			}
-			if i != 1 {
-				w.Write(comma)
-			}
-			w.WriteByte('"')
-			w.WriteString(base64.StdEncoding.EncodeToString(dat))
-			w.WriteByte('"')
 			nr += n
-			continue
-
-		default:
-			return msg.ErrTypeNotSupported
+			ncols++
 		}
 	}
+	if it.Err() != nil {
+		return it.Err()
+	}
 
+	if inline {
+		w.WriteString("],\"points\":[[")
+		w.Write(pw.(*bytes.Buffer).Bytes())
+	}
 	w.Write([]byte{']', ']', '}'})
 	return nil
 }
 
+// hasMap returns whether or not any
+// object in the Schema is a msg.Map
+func hasMap(s msg.Schema) bool {
+	for _, o := range s {
+		if o.T == msg.Map {
+			return true
+		}
+	}
+	return false
+}
+
+// timeIndex returns the index of the first msg.Time
+// object after the series name, or -1 if there isn't one
+func timeIndex(s msg.Schema) int {
+	for i := 1; i < len(s); i++ {
+		if s[i].T == msg.Time {
+			return i
+		}
+	}
+	return -1
+}
+
+// column returns the column name of 'o' at index
+// 'i', given the index of the "time" column
+func column(o *msg.Object, i int, ti int) string {
+	if i == ti {
+		return "time"
+	}
+	return o.Name
+}
+
+// columns returns the quoted, comma-separated
+// column names of a Schema without Maps
+func columns(s msg.Schema) []byte {
+	var cols []byte
+	ti := timeIndex(s)
+	for i := 1; i < len(s); i++ {
+		if i > 1 {
+			cols = append(cols, ',')
+		}
+		cols = appendColumn(cols, column(&s[i], i, ti))
+	}
+	return cols
+}
+
+// appendColumn appends a column name as a JSON string
+func appendColumn(b []byte, name string) []byte {
+	b, _ = msg.AppendJSONString(b, name, 0)
+	return b
+}
+
+// writePoint writes one value of type 't' from 'p' into 'w',
+// preceded by 'prepend', and returns the number of bytes read
+func writePoint(p []byte, t msg.Type, prepend []byte, w msg.Writer) (n int, err error) {
+	switch t {
+	case msg.String:
+		var s string
+		s, n, err = msg.ReadStringZeroCopy(p)
+		if err != nil {
+			return
+		}
+		var b []byte
+		b, err = msg.AppendJSONString(prepend, s, 0)
+		w.Write(b)
+		return
+
+	case msg.Float:
+		var f float64
+		f, n, err = msg.ReadFloatBytes(p)
+		if err != nil {
+			return
+		}
+		w.Write(strconv.AppendFloat(prepend, f, 'f', -1, 64))
+		return
+
+	case msg.Int:
+		var i int64
+		i, n, err = msg.ReadIntBytes(p)
+		if err != nil {
+			return
+		}
+		w.Write(strconv.AppendInt(prepend, i, 10))
+		return
+
+	case msg.Uint:
+		var u uint64
+		u, n, err = msg.ReadUintBytes(p)
+		if err != nil {
+			return
+		}
+		w.Write(strconv.AppendUint(prepend, u, 10))
+		return
+
+	case msg.Bool:
+		var b bool
+		b, n, err = msg.ReadBoolBytes(p)
+		if err != nil {
+			return
+		}
+		w.Write(strconv.AppendBool(prepend, b))
+		return
+
+	case msg.Time:
+		var t time.Time
+		t, n, err = msg.ReadTimeBytes(p)
+		if err != nil {
+			return
+		}
+		w.Write(strconv.AppendInt(prepend, t.UnixNano()/int64(time.Millisecond), 10))
+		return
+
+	case msg.Bin:
+		var dat []byte
+		dat, n, err = msg.ReadBinZeroCopy(p)
+		if err != nil {
+			return
+		}
+		w.Write(prepend)
+		w.WriteByte('"')
+		w.WriteString(base64.StdEncoding.EncodeToString(dat))
+		w.WriteByte('"')
+		return
+
+	default:
+		err = msg.ErrTypeNotSupported
+		return
+	}
+}
+
 // Req resturns a POST request to d.Address() with 'r' as the body.
 func (d *InfluxDB) Req(r io.Reader) (req *http.Request) {
 	var err error
@@ -5,8 +5,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"github.com/A2B-Bikeshare/go-flux/msg"
+	"github.com/A2B-Bikeshare/go-flux/msg/registry"
 	"reflect"
 	"testing"
+	"time"
 )
 
 // Each entry should be json.Marshal-able to this struct type
@@ -133,3 +135,254 @@ func TestBatchInfluxTranslate(t *testing.T) {
 		validate(d, t)
 	}
 }
+
+func TestInfluxTranslateOptional(t *testing.T) {
+	db := InfluxDB{
+		Schema: msg.Schema{
+			{Name: "name", T: msg.String},
+			{Name: "charge", T: msg.Int, Optional: true},
+			{Name: "id", T: msg.Uint},
+		},
+	}
+	testbuf := bytes.NewBuffer(nil)
+	err := db.Schema.EncodeSlice([]interface{}{"bike", nil, uint64(3)}, testbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbuf := bytes.NewBuffer(nil)
+	err = db.Translate(testbuf.Bytes(), outbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifl := new(Influx)
+	err = json.NewDecoder(outbuf).Decode(ifl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate(ifl, t)
+	if ifl.Points[0][0] != nil {
+		t.Errorf("Expected null charge; got %v", ifl.Points[0][0])
+	}
+	if ifl.Points[0][1].(float64) != 3 {
+		t.Errorf("Expected id 3; got %v", ifl.Points[0][1])
+	}
+}
+
+func TestInfluxTranslateMap(t *testing.T) {
+	db := InfluxDB{
+		Schema: msg.Schema{
+			{Name: "name", T: msg.String},
+			{Name: "id", T: msg.Uint},
+			{Name: "sensors", T: msg.Map, Elem: msg.Float},
+			{Name: "extra", T: msg.Map, Elem: msg.Int, Optional: true},
+			{Name: "charge", T: msg.Int},
+		},
+	}
+	testbuf := bytes.NewBuffer(nil)
+	err := db.Schema.EncodeSlice([]interface{}{
+		"bike",
+		uint64(3),
+		map[string]interface{}{"temp": 20.5, "hum": 0.25},
+		nil,
+		int64(-1),
+	}, testbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbuf := bytes.NewBuffer(nil)
+	err = db.Translate(testbuf.Bytes(), outbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifl := new(Influx)
+	err = json.NewDecoder(outbuf).Decode(ifl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate(ifl, t)
+	cols := []string{"id", "sensors.hum", "sensors.temp", "charge"}
+	if !reflect.DeepEqual(ifl.Columns, cols) {
+		t.Errorf("Expected columns %v; got %v", cols, ifl.Columns)
+	}
+	pts := []interface{}{3.0, 0.25, 20.5, -1.0}
+	if !reflect.DeepEqual(ifl.Points[0], pts) {
+		t.Errorf("Expected points %v; got %v", pts, ifl.Points[0])
+	}
+}
+
+func TestInfluxTranslateEscaping(t *testing.T) {
+	db := InfluxDB{
+		Schema: msg.Schema{
+			{Name: "name", T: msg.String},
+			{Name: "tag\"s", T: msg.Map, Elem: msg.String},
+		},
+	}
+	p, err := db.Schema.AppendSlice(nil, []interface{}{
+		"bike\x00",
+		map[string]interface{}{"\x01": "\U0001F600\xff"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbuf := bytes.NewBuffer(nil)
+	err = db.Translate(p, outbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifl := new(Influx)
+	err = json.Unmarshal(outbuf.Bytes(), ifl)
+	if err != nil {
+		t.Fatalf("%s: %s", outbuf.Bytes(), err)
+	}
+	if ifl.Name != "bike\x00" {
+		t.Errorf("Expected name %q; got %q", "bike\x00", ifl.Name)
+	}
+	if len(ifl.Columns) != 1 || ifl.Columns[0] != "tag\"s.\x01" {
+		t.Errorf("Bad columns: %q", ifl.Columns)
+	}
+	if len(ifl.Points[0]) != 1 || ifl.Points[0][0] != "\U0001F600\ufffd" {
+		t.Errorf("Bad points: %q", ifl.Points[0])
+	}
+}
+
+func TestInfluxTranslateTime(t *testing.T) {
+	db := InfluxDB{
+		Schema: msg.Schema{
+			{Name: "name", T: msg.String},
+			{Name: "stamp", T: msg.Time},
+			{Name: "charge", T: msg.Int},
+			{Name: "last_seen", T: msg.Time},
+		},
+	}
+	testbuf := bytes.NewBuffer(nil)
+	err := db.Schema.EncodeSlice([]interface{}{
+		"bike",
+		time.Unix(1414000000, 250000000),
+		int64(-1),
+		time.Unix(1413000000, 0),
+	}, testbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbuf := bytes.NewBuffer(nil)
+	err = db.Translate(testbuf.Bytes(), outbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifl := new(Influx)
+	err = json.NewDecoder(outbuf).Decode(ifl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate(ifl, t)
+	cols := []string{"time", "charge", "last_seen"}
+	if !reflect.DeepEqual(ifl.Columns, cols) {
+		t.Errorf("Expected columns %v; got %v", cols, ifl.Columns)
+	}
+	pts := []interface{}{1414000000250.0, -1.0, 1413000000000.0}
+	if !reflect.DeepEqual(ifl.Points[0], pts) {
+		t.Errorf("Expected points %v; got %v", pts, ifl.Points[0])
+	}
+}
+
+func TestInfluxTranslateEnvelope(t *testing.T) {
+	db := InfluxDB{
+		Schema: msg.Schema{
+			{Name: "name", T: msg.String},
+			{Name: "id", T: msg.Uint},
+		},
+	}
+	db.Init()
+	testbuf := bytes.NewBuffer(nil)
+	msg.WriteEnvelope(testbuf, db.Schema.Fingerprint())
+	err := db.Schema.EncodeSlice([]interface{}{"bike", uint64(3)}, testbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbuf := bytes.NewBuffer(nil)
+	err = db.Translate(testbuf.Bytes(), outbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifl := new(Influx)
+	err = json.NewDecoder(outbuf).Decode(ifl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate(ifl, t)
+	if ifl.Name != "bike" {
+		t.Errorf("Expected series \"bike\"; got %q", ifl.Name)
+	}
+
+	// a newer version accepts the older message
+	db.Schema = append(db.Schema, msg.Object{Name: "n", T: msg.Int, Since: 1, Default: int64(0)})
+	db.Init()
+	outbuf.Reset()
+	err = db.Translate(testbuf.Bytes(), outbuf)
+	if err != nil {
+		t.Errorf("Translate with a newer Schema: %s", err)
+	}
+
+	db.Schema[1].T = msg.Int
+	db.Init()
+	err = db.Translate(testbuf.Bytes(), outbuf)
+	if _, ok := err.(*msg.FingerprintError); !ok {
+		t.Errorf("Expected *msg.FingerprintError; got %v", err)
+	}
+}
+
+func TestInfluxSchemaRef(t *testing.T) {
+	reg := registry.NewMemory()
+	_, err := reg.Register("test", testInfluxdb.Schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := InfluxDB{Ref: &SchemaRef{Registry: reg, Name: "test", Latest: true}}
+	err = db.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(db.Schema, testInfluxdb.Schema) {
+		t.Errorf("Expected schema %v; got %v", testInfluxdb.Schema, db.Schema)
+	}
+
+	db.Ref.Latest = false
+	db.Ref.Version = 3
+	if db.Init() != registry.ErrNotFound {
+		t.Error("Expected registry.ErrNotFound")
+	}
+}
+
+func TestInfluxInit(t *testing.T) {
+	for _, s := range []msg.Schema{
+		{},
+		{{Name: "id", T: msg.Uint}},
+		{{Name: "name", T: msg.String}, {Name: "a", T: msg.Array, Elem: msg.Struct}},
+	} {
+		db := InfluxDB{Schema: s}
+		if db.Init() == nil {
+			t.Errorf("Expected an error from Init for %v", s)
+		}
+	}
+
+	// compiled and uncompiled translations match
+	p, err := testInfluxdb.Schema.AppendSlice(nil, []interface{}{"bike", int64(-3), uint64(4), 0.5, []byte{1}, true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := testInfluxdb
+	want := bytes.NewBuffer(nil)
+	err = db.Translate(p, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := bytes.NewBuffer(nil)
+	err = db.Translate(p, got)
+	if err != nil || got.String() != want.String() {
+		t.Errorf("Expected %s; got %s: %v", want.Bytes(), got.Bytes(), err)
+	}
+}
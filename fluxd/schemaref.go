@@ -0,0 +1,36 @@
+package fluxd
+
+import (
+	"github.com/A2B-Bikeshare/go-flux/msg"
+	"github.com/A2B-Bikeshare/go-flux/msg/registry"
+)
+
+// SchemaRef refers to a Schema in a registry.Registry. InfluxDB
+// and ElasticsearchDB endpoints with a non-nil Ref use the
+// referenced Schema in place of their Schema field.
+type SchemaRef struct {
+	Registry registry.Registry
+	// Name is the registered name of the Schema
+	Name string
+	// Version is the registered version of the Schema.
+	// If Latest is true, Version is ignored.
+	Version int
+	// Latest selects the latest version of the Schema
+	// at the time that the endpoint is initialized.
+	Latest bool
+}
+
+// Schema looks up the referenced Schema.
+func (r *SchemaRef) Schema() (msg.Schema, error) {
+	var e *registry.Entry
+	var err error
+	if r.Latest {
+		e, err = r.Registry.Latest(r.Name)
+	} else {
+		e, err = r.Registry.Lookup(r.Name, r.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.Schema, nil
+}
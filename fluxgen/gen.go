@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	gotypes "go/types"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	msgPath = "github.com/A2B-Bikeshare/go-flux/msg"
+	marker  = "flux:gen"
+	header  = "// Code generated by fluxgen; DO NOT EDIT.\n\n"
+)
+
+// genStruct is a struct that fluxgen writes methods for
+type genStruct struct {
+	Name   string
+	Fields []genField
+}
+
+// genField is one encoded field of a genStruct
+type genField struct {
+	Name   string // Go field name
+	Key    string // Schema name
+	GoType string // Go type, as written in the generated code
+	T      string // msg.Type name (e.g. "String")
+	Width  int    // 32 or 64 for fixed-width floats; 0 otherwise
+}
+
+// parseFile parses the Go source in 'src' and returns the
+// package name along with the structs that are marked with
+// a "//flux:gen" comment or are named in 'names'.
+func parseFile(fset *token.FileSet, filename string, src interface{}, names map[string]bool) (pkg string, out []genStruct, err error) {
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return "", nil, err
+	}
+	pkg = f.Name.Name
+
+	// import paths of the msg and time packages, by local name
+	imports := make(map[string]string)
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if path != msgPath && path != "time" {
+			continue
+		}
+		if imp.Name != nil {
+			imports[imp.Name.Name] = path
+		} else {
+			imports[filepath.Base(path)] = path
+		}
+	}
+
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				continue
+			}
+			if !names[ts.Name.Name] && !marked(gd.Doc) && !marked(ts.Doc) {
+				continue
+			}
+			gs := genStruct{Name: ts.Name.Name}
+			gs.Fields, err = structFields(st, imports)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s: %s: %s", fset.Position(ts.Pos()), ts.Name.Name, err)
+			}
+			out = append(out, gs)
+		}
+	}
+	return pkg, out, nil
+}
+
+// marked returns whether or not a comment group contains the marker
+func marked(cg *ast.CommentGroup) bool {
+	if cg == nil {
+		return false
+	}
+	for _, c := range cg.List {
+		if strings.TrimSpace(strings.TrimPrefix(c.Text, "//")) == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// structFields follows the rules of msg.Marshal for fields of the
+// builtin types, []byte, msg.PackExt, time.Time and other structs
+// in the package. (Fields of other struct types are checked by
+// checkStructs, once all of the structs have been parsed.)
+func structFields(st *ast.StructType, imports map[string]string) ([]genField, error) {
+	var out []genField
+	for _, fl := range st.Fields.List {
+		if len(fl.Names) == 0 {
+			return nil, errors.New("embedded fields are not supported")
+		}
+		var tag reflect.StructTag
+		if fl.Tag != nil {
+			s, _ := strconv.Unquote(fl.Tag.Value)
+			tag = reflect.StructTag(s)
+		}
+		ftag := tag.Get("flux")
+		if ftag == "-" {
+			continue
+		}
+		opts := strings.Split(ftag, ",")
+
+		gf := genField{}
+		switch t := fl.Type.(type) {
+		case *ast.Ident:
+			gf.GoType = t.Name
+			switch t.Name {
+			case "string":
+				gf.T = "String"
+			case "bool":
+				gf.T = "Bool"
+			case "int", "int8", "int16", "int32", "int64", "rune":
+				gf.T = "Int"
+			case "uint", "uint8", "uint16", "uint32", "uint64", "byte":
+				gf.T = "Uint"
+			case "float32":
+				gf.T = "Float"
+				gf.Width = 32
+			case "float64":
+				gf.T = "Float"
+			default:
+				// see checkStructs
+				if gotypes.Universe.Lookup(t.Name) == nil {
+					gf.T = "Struct"
+				}
+			}
+		case *ast.ArrayType:
+			if id, ok := t.Elt.(*ast.Ident); ok && t.Len == nil && (id.Name == "byte" || id.Name == "uint8") {
+				gf.GoType = "[]byte"
+				gf.T = "Bin"
+			}
+		case *ast.SelectorExpr:
+			switch {
+			case isSelector(t, imports, msgPath, "PackExt"):
+				gf.GoType = "msg.PackExt"
+				gf.T = "Ext"
+			case isSelector(t, imports, "time", "Time"):
+				gf.GoType = "time.Time"
+				gf.T = "Time"
+			}
+		case *ast.StarExpr:
+			if sel, ok := t.X.(*ast.SelectorExpr); ok && isSelector(sel, imports, msgPath, "PackExt") {
+				gf.GoType = "*msg.PackExt"
+				gf.T = "Ext"
+			}
+		}
+
+		for _, opt := range opts[1:] {
+			if gf.T != "Float" {
+				return nil, fmt.Errorf("option %q only applies to floats", opt)
+			}
+			switch opt {
+			case "float32":
+				gf.Width = 32
+			case "float64":
+				gf.Width = 64
+			default:
+				return nil, fmt.Errorf("unknown option %q", opt)
+			}
+		}
+
+		for _, name := range fl.Names {
+			if !name.IsExported() {
+				continue
+			}
+			if gf.T == "" {
+				return nil, fmt.Errorf("field %s: type not supported", name.Name)
+			}
+			f := gf
+			f.Name = name.Name
+			f.Key = opts[0]
+			if f.Key == "" {
+				f.Key = strings.ToLower(name.Name)
+			}
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// isSelector returns whether or not 'sel' is path.name
+func isSelector(sel *ast.SelectorExpr, imports map[string]string, path string, name string) bool {
+	id, ok := sel.X.(*ast.Ident)
+	return ok && imports[id.Name] == path && sel.Sel.Name == name
+}
+
+// checkStructs returns an error unless every Struct
+// field refers to one of the structs in 'ss', which
+// are the ones that have Encode and Decode methods
+func checkStructs(ss []genStruct) error {
+	gen := make(map[string]bool)
+	for _, s := range ss {
+		gen[s.Name] = true
+	}
+	for _, s := range ss {
+		for _, f := range s.Fields {
+			if f.T == "Struct" && !gen[f.GoType] {
+				return fmt.Errorf("fluxgen: %s: field %s: type %s is not a generated struct (mark it with //%s)", s.Name, f.Name, f.GoType, marker)
+			}
+		}
+	}
+	return nil
+}
+
+// hasTime returns whether or not any of 'ss' has a Time field
+func hasTime(ss []genStruct) bool {
+	for _, s := range ss {
+		for _, f := range s.Fields {
+			if f.T == "Time" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// generate writes the Schema variable, Encode, Decode, and DecodeBytes for each struct
+func generate(w io.Writer, pkg string, ss []genStruct) error {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(header)
+	fmt.Fprintf(buf, "package %s\n\nimport \"%s\"\n", pkg, msgPath)
+	for _, s := range ss {
+		genSchema(buf, s)
+		genEncode(buf, s)
+		genDecode(buf, s)
+		genDecodeBytes(buf, s)
+	}
+	return writeFormatted(w, buf.Bytes())
+}
+
+// generateTests writes round-trip tests and benchmarks for each struct
+func generateTests(w io.Writer, pkg string, ss []genStruct) error {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(header)
+	imports := "\"bytes\"\n\"reflect\"\n\"testing\"\n"
+	if hasTime(ss) {
+		imports += "\"time\"\n"
+	}
+	fmt.Fprintf(buf, "package %s\n\nimport (\n%s\n\"%s\"\n)\n", pkg, imports, msgPath)
+	for _, s := range ss {
+		genTests(buf, s)
+	}
+	return writeFormatted(w, buf.Bytes())
+}
+
+func writeFormatted(w io.Writer, src []byte) error {
+	out, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("fluxgen: generated bad code: %s", err)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func genSchema(w io.Writer, s genStruct) {
+	fmt.Fprintf(w, "\n// %sSchema is the msg.Schema for %s\n", s.Name, s.Name)
+	fmt.Fprintf(w, "var %sSchema = msg.Schema{\n", s.Name)
+	for _, f := range s.Fields {
+		if f.T == "Struct" {
+			fmt.Fprintf(w, "{Name: %q, T: msg.Struct, Schema: &%sSchema},\n", f.Key, f.GoType)
+			continue
+		}
+		fmt.Fprintf(w, "{Name: %q, T: msg.%s},\n", f.Key, f.T)
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+func genEncode(w io.Writer, s genStruct) {
+	fmt.Fprintf(w, "\n// Encode implements msg.Encoder\n")
+	fmt.Fprintf(w, "func (z *%s) Encode(w msg.Writer) error {\n", s.Name)
+	for _, f := range s.Fields {
+		v := "z." + f.Name
+		switch f.T {
+		case "String", "Bool", "Bin", "Time":
+			fmt.Fprintf(w, "msg.Write%s(w, %s)\n", f.T, v)
+		case "Int":
+			fmt.Fprintf(w, "msg.WriteInt(w, %s)\n", convert("int64", f.GoType, v))
+		case "Uint":
+			fmt.Fprintf(w, "msg.WriteUint(w, %s)\n", convert("uint64", f.GoType, v))
+		case "Float":
+			switch f.Width {
+			case 32:
+				fmt.Fprintf(w, "msg.WriteFloat32(w, %s)\n", convert("float32", f.GoType, v))
+			case 64:
+				fmt.Fprintf(w, "msg.WriteFloat64(w, %s)\n", convert("float64", f.GoType, v))
+			default:
+				fmt.Fprintf(w, "msg.WriteFloat(w, %s)\n", v)
+			}
+		case "Ext":
+			if f.GoType[0] == '*' {
+				fmt.Fprintf(w, "if %s == nil {\nreturn msg.ErrIncorrectType\n}\n", v)
+			}
+			fmt.Fprintf(w, "msg.WriteExt(w, %s.EType, %s.Data)\n", v, v)
+		case "Struct":
+			fmt.Fprintf(w, "if err := %s.Encode(w); err != nil {\nreturn err\n}\n", v)
+		}
+	}
+	fmt.Fprintf(w, "return nil\n}\n")
+}
+
+func genDecode(w io.Writer, s genStruct) {
+	fmt.Fprintf(w, "\n// Decode implements msg.Decoder\n")
+	fmt.Fprintf(w, "func (z *%s) Decode(r msg.Reader) error {\n", s.Name)
+	for _, f := range s.Fields {
+		if f.T == "Struct" {
+			fmt.Fprintf(w, "if err := z.%s.Decode(r); err != nil {\nreturn err\n}\n", f.Name)
+			continue
+		}
+		fmt.Fprintf(w, "{\n")
+		switch f.T {
+		case "String", "Bool", "Int", "Uint", "Float", "Time":
+			fmt.Fprintf(w, "v, err := msg.Read%s(r)\n", f.T)
+		case "Bin":
+			fmt.Fprintf(w, "v, err := msg.ReadBin(r, z.%s)\n", f.Name)
+		case "Ext":
+			fmt.Fprintf(w, "v, err := msg.ReadExt(r, nil)\n")
+		}
+		fmt.Fprintf(w, "if err != nil {\nreturn err\n}\n")
+		assign(w, f, "v", "err")
+		fmt.Fprintf(w, "}\n")
+	}
+	fmt.Fprintf(w, "return nil\n}\n")
+}
+
+func genDecodeBytes(w io.Writer, s genStruct) {
+	fmt.Fprintf(w, "\n// DecodeBytes decodes %s directly from 'p', returning\n", s.Name)
+	fmt.Fprintf(w, "// the number of bytes read. String and binary fields\n")
+	fmt.Fprintf(w, "// point into 'p', so 'p' must not be modified afterwards.\n")
+	fmt.Fprintf(w, "func (z *%s) DecodeBytes(p []byte) (int, error) {\n", s.Name)
+	fmt.Fprintf(w, "var nr int\n")
+	for _, f := range s.Fields {
+		fmt.Fprintf(w, "{\n")
+		if f.T == "Struct" {
+			fmt.Fprintf(w, "n, err := z.%s.DecodeBytes(p[nr:])\n", f.Name)
+			fmt.Fprintf(w, "if err != nil {\nreturn nr, err\n}\n")
+			fmt.Fprintf(w, "nr += n\n}\n")
+			continue
+		}
+		switch f.T {
+		case "String":
+			fmt.Fprintf(w, "v, n, err := msg.ReadStringZeroCopy(p[nr:])\n")
+		case "Bin":
+			fmt.Fprintf(w, "v, n, err := msg.ReadBinZeroCopy(p[nr:])\n")
+		case "Ext":
+			fmt.Fprintf(w, "dat, etype, n, err := msg.ReadExtZeroCopy(p[nr:])\n")
+		default:
+			fmt.Fprintf(w, "v, n, err := msg.Read%sBytes(p[nr:])\n", f.T)
+		}
+		fmt.Fprintf(w, "if err != nil {\nreturn nr, err\n}\n")
+		if f.T == "Ext" {
+			fmt.Fprintf(w, "v := &msg.PackExt{EType: etype, Data: dat}\n")
+		}
+		assign(w, f, "v", "nr, err")
+		fmt.Fprintf(w, "nr += n\n}\n")
+	}
+	fmt.Fprintf(w, "return nr, nil\n}\n")
+}
+
+// assign writes z.Field = v, with overflow checks for narrow integers
+func assign(w io.Writer, f genField, v string, ret string) {
+	switch f.T {
+	case "Int", "Uint":
+		wide := "int64"
+		if f.T == "Uint" {
+			wide = "uint64"
+		}
+		if f.GoType != wide {
+			fmt.Fprintf(w, "if %s(%s(%s)) != %s {\nreturn %s\n}\n", wide, f.GoType, v, v, strings.Replace(ret, "err", "msg.ErrOverflow", 1))
+		}
+		fmt.Fprintf(w, "z.%s = %s\n", f.Name, convert(f.GoType, wide, v))
+	case "Float":
+		fmt.Fprintf(w, "z.%s = %s\n", f.Name, convert(f.GoType, "float64", v))
+	case "Ext":
+		if f.GoType[0] == '*' {
+			fmt.Fprintf(w, "z.%s = %s\n", f.Name, v)
+		} else {
+			fmt.Fprintf(w, "z.%s = *%s\n", f.Name, v)
+		}
+	default:
+		fmt.Fprintf(w, "z.%s = %s\n", f.Name, v)
+	}
+}
+
+// convert returns 'v' (of type 'from') converted to type 'to'
+func convert(to string, from string, v string) string {
+	if to == from {
+		return v
+	}
+	return to + "(" + v + ")"
+}
+
+// sample returns a non-zero literal for a field
+func sample(f genField) string {
+	switch f.T {
+	case "String":
+		return strconv.Quote(f.Key)
+	case "Bool":
+		return "true"
+	case "Int":
+		return "-42"
+	case "Uint":
+		return "42"
+	case "Float":
+		return "1.5"
+	case "Bin":
+		return "[]byte{1, 2, 3}"
+	case "Time":
+		return "time.Unix(1414000000, 250000000).UTC()"
+	case "Struct":
+		return "*make" + f.GoType + "Flux()"
+	default:
+		lit := "msg.PackExt{EType: 1, Data: []byte{1, 2, 3, 4}}"
+		if f.GoType[0] == '*' {
+			return "&" + lit
+		}
+		return lit
+	}
+}
+
+func genTests(w io.Writer, s genStruct) {
+	n := s.Name
+	fmt.Fprintf(w, "\nfunc make%sFlux() *%s {\nreturn &%s{\n", n, n, n)
+	for _, f := range s.Fields {
+		fmt.Fprintf(w, "%s: %s,\n", f.Name, sample(f))
+	}
+	fmt.Fprintf(w, "}\n}\n")
+
+	fmt.Fprintf(w, `
+func Test%[1]sFluxRoundTrip(t *testing.T) {
+	in := make%[1]sFlux()
+	buf := bytes.NewBuffer(nil)
+	err := in.Encode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+
+	mbts, err := msg.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mbts, bts) {
+		t.Errorf("Encode wrote %%x; msg.Marshal wrote %%x", bts, mbts)
+	}
+	err = %[1]sSchema.Verify(in)
+	if err != nil {
+		t.Error(err)
+	}
+
+	out := new(%[1]s)
+	err = out.Decode(bytes.NewReader(bts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Decode: expected %%#v; got %%#v", in, out)
+	}
+
+	out = new(%[1]s)
+	n, err := out.DecodeBytes(bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(bts) {
+		t.Errorf("DecodeBytes read %%d bytes; expected %%d", n, len(bts))
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("DecodeBytes: expected %%#v; got %%#v", in, out)
+	}
+}
+
+func Benchmark%[1]sFluxEncode(b *testing.B) {
+	b.ReportAllocs()
+	in := make%[1]sFlux()
+	buf := bytes.NewBuffer(nil)
+	in.Encode(buf)
+	b.SetBytes(int64(buf.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		in.Encode(buf)
+	}
+}
+
+func Benchmark%[1]sFluxDecode(b *testing.B) {
+	b.ReportAllocs()
+	buf := bytes.NewBuffer(nil)
+	make%[1]sFlux().Encode(buf)
+	bts := buf.Bytes()
+	out := new(%[1]s)
+	b.SetBytes(int64(len(bts)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := out.Decode(bytes.NewReader(bts))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark%[1]sFluxDecodeBytes(b *testing.B) {
+	b.ReportAllocs()
+	buf := bytes.NewBuffer(nil)
+	make%[1]sFlux().Encode(buf)
+	bts := buf.Bytes()
+	out := new(%[1]s)
+	b.SetBytes(int64(len(bts)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := out.DecodeBytes(bts)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark%[1]sFluxSchemaDecodeToSlice(b *testing.B) {
+	b.ReportAllocs()
+	buf := bytes.NewBuffer(nil)
+	make%[1]sFlux().Encode(buf)
+	bts := buf.Bytes()
+	out := make([]interface{}, len(%[1]sSchema))
+	b.SetBytes(int64(len(bts)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := %[1]sSchema.DecodeToSlice(bytes.NewReader(bts), out)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`, n)
+}
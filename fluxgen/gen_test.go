@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testsrc = `package tele
+
+import fmsg "github.com/A2B-Bikeshare/go-flux/msg"
+
+//flux:gen
+type Tele struct {
+	Name   string
+	Val    float64 ` + "`flux:\"value,float64\"`" + `
+	Small  int16
+	Ratio  float32
+	Data   []byte
+	Ext    fmsg.PackExt
+	Skip   int ` + "`flux:\"-\"`" + `
+	hidden int
+}
+
+type Ignored struct {
+	Name string
+}
+
+type Named struct {
+	A, B uint8
+}
+`
+
+func TestParseFile(t *testing.T) {
+	fset := token.NewFileSet()
+	pkg, ss, err := parseFile(fset, "tele.go", testsrc, map[string]bool{"Named": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg != "tele" {
+		t.Errorf("Expected package \"tele\"; got %q", pkg)
+	}
+	if len(ss) != 2 {
+		t.Fatalf("Expected 2 structs; got %d", len(ss))
+	}
+
+	expect := []genField{
+		{Name: "Name", Key: "name", GoType: "string", T: "String"},
+		{Name: "Val", Key: "value", GoType: "float64", T: "Float", Width: 64},
+		{Name: "Small", Key: "small", GoType: "int16", T: "Int"},
+		{Name: "Ratio", Key: "ratio", GoType: "float32", T: "Float", Width: 32},
+		{Name: "Data", Key: "data", GoType: "[]byte", T: "Bin"},
+		{Name: "Ext", Key: "ext", GoType: "msg.PackExt", T: "Ext"},
+	}
+	if !reflect.DeepEqual(ss[0].Fields, expect) {
+		t.Errorf("Expected fields %v; got %v", expect, ss[0].Fields)
+	}
+	if ss[1].Name != "Named" || len(ss[1].Fields) != 2 {
+		t.Errorf("Bad struct: %v", ss[1])
+	}
+}
+
+func TestParseFileUnsupported(t *testing.T) {
+	src := "package x\n\n//flux:gen\ntype X struct {\n\tM map[string]int\n}\n"
+	_, _, err := parseFile(token.NewFileSet(), "x.go", src, nil)
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("Expected an unsupported type error; got %v", err)
+	}
+}
+
+const nestedsrc = `package tele
+
+import "time"
+
+//flux:gen
+type Trip struct {
+	Start time.Time
+	From  Loc
+	To    Loc ` + "`flux:\"dest\"`" + `
+}
+
+//flux:gen
+type Loc struct {
+	Lat, Lon float64
+}
+`
+
+func TestNestedStructs(t *testing.T) {
+	_, ss, err := parseFile(token.NewFileSet(), "trip.go", nestedsrc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []genField{
+		{Name: "Start", Key: "start", GoType: "time.Time", T: "Time"},
+		{Name: "From", Key: "from", GoType: "Loc", T: "Struct"},
+		{Name: "To", Key: "dest", GoType: "Loc", T: "Struct"},
+	}
+	if !reflect.DeepEqual(ss[0].Fields, expect) {
+		t.Errorf("Expected fields %v; got %v", expect, ss[0].Fields)
+	}
+	err = checkStructs(ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = generate(buf, "tele", ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"{Name: \"dest\", T: msg.Struct, Schema: &LocSchema},",
+		"msg.WriteTime(w, z.Start)",
+		"if err := z.From.Encode(w); err != nil {",
+		"v, err := msg.ReadTime(r)",
+		"if err := z.To.Decode(r); err != nil {",
+		"n, err := z.To.DecodeBytes(p[nr:])",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Generated code is missing %q", want)
+		}
+	}
+	buf.Reset()
+	err = generateTests(buf, "tele", ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\"time\"") || !strings.Contains(buf.String(), "*makeLocFlux(),") {
+		t.Error("Generated tests do not build a Trip")
+	}
+
+	// Loc must be generated, too
+	if err = checkStructs(ss[:1]); err == nil || !strings.Contains(err.Error(), "not a generated struct") {
+		t.Errorf("Expected an error for an ungenerated struct; got %v", err)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	_, ss, err := parseFile(token.NewFileSet(), "tele.go", testsrc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = generate(buf, "tele", ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"var TeleSchema = msg.Schema{",
+		"{Name: \"value\", T: msg.Float},",
+		"func (z *Tele) Encode(w msg.Writer) error {",
+		"msg.WriteFloat64(w, z.Val)",
+		"msg.WriteInt(w, int64(z.Small))",
+		"func (z *Tele) Decode(r msg.Reader) error {",
+		"if int64(int16(v)) != v {",
+		"func (z *Tele) DecodeBytes(p []byte) (int, error) {",
+		"z.Ext = *v",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Generated code is missing %q", want)
+		}
+	}
+
+	buf.Reset()
+	err = generateTests(buf, "tele", ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "func TestTeleFluxRoundTrip(t *testing.T) {") {
+		t.Error("Generated tests are missing TestTeleFluxRoundTrip")
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Fluxgen generates flux/msg encoding methods for Go structs.
+
+For each struct that is marked with a "//flux:gen" comment (or
+named with the -type flag), fluxgen writes:
+
+  - a package-level msg.Schema variable named {Type}Schema
+  - Encode(w msg.Writer) error
+  - Decode(r msg.Reader) error
+  - DecodeBytes(p []byte) (int, error), which decodes without copying
+
+along with a test file containing round-trip tests and benchmarks
+comparing the generated methods with Schema.DecodeToSlice.
+Fields are encoded exactly as msg.Marshal encodes them, and use the
+same `flux:"..."` tags, but fluxgen only supports fields whose types
+are written as the builtin string, bool, integer and float types,
+[]byte, msg.PackExt, *msg.PackExt, time.Time, or another struct that
+fluxgen is generating methods for (which is encoded as a msg.Struct).
+Named types (e.g. "type Celsius float64") are not supported.
+
+Fluxgen is meant to be used with go generate:
+
+	//go:generate fluxgen
+
+	//flux:gen
+	type Tele struct {
+		Name string
+		Val  float64 `flux:"val,float64"`
+	}
+
+When run by go generate, fluxgen reads $GOFILE and writes
+{file}_flux.go and {file}_flux_test.go. Otherwise, it reads
+the files named on the command line.
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	output string
+	types  string
+	notest bool
+)
+
+func init() {
+	flag.StringVar(&output, "o", "", "output file; defaults to {file}_flux.go")
+	flag.StringVar(&types, "type", "", "comma-separated list of struct names to generate (in addition to marked structs)")
+	flag.BoolVar(&notest, "notest", false, "don't generate tests and benchmarks")
+}
+
+func main() {
+	flag.Parse()
+	files := flag.Args()
+	if len(files) == 0 {
+		if gofile := os.Getenv("GOFILE"); gofile != "" {
+			files = []string{gofile}
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: fluxgen [-o output] [-type T,U] [-notest] file.go ...")
+		os.Exit(2)
+	}
+
+	err := run(files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(files []string) error {
+	names := make(map[string]bool)
+	for _, t := range strings.Split(types, ",") {
+		if t != "" {
+			names[t] = true
+		}
+	}
+
+	fset := token.NewFileSet()
+	var pkg string
+	var structs []genStruct
+	for _, file := range files {
+		p, ss, err := parseFile(fset, file, nil, names)
+		if err != nil {
+			return err
+		}
+		if pkg != "" && p != pkg {
+			return fmt.Errorf("fluxgen: files are in different packages (%s and %s)", pkg, p)
+		}
+		pkg = p
+		structs = append(structs, ss...)
+	}
+	if len(structs) == 0 {
+		return fmt.Errorf("fluxgen: no structs marked with //%s", marker)
+	}
+	err := checkStructs(structs)
+	if err != nil {
+		return err
+	}
+
+	out := output
+	if out == "" {
+		out = strings.TrimSuffix(files[0], ".go") + "_flux.go"
+	}
+	buf := bytes.NewBuffer(nil)
+	err = generate(buf, pkg, structs)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(out, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	if notest {
+		return nil
+	}
+
+	buf.Reset()
+	err = generateTests(buf, pkg, structs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Clean(strings.TrimSuffix(out, ".go")+"_test.go"), buf.Bytes(), 0644)
+}
@@ -49,6 +49,8 @@ type Logger struct {
 	wg    *sync.WaitGroup  // used for waiting for consumer and error goroutines to finish
 	swg   *sync.WaitGroup  // used for waiting on async sends to prevent sends on a closed channel
 	list  chan msg.Encoder // used for messages
+	env   bool             // write an envelope before each message
+	fp    msg.Fingerprint  // fingerprint in the envelope
 }
 
 // NewLogger returns a logger that writes data on the NSQ topic 'Topic.'
@@ -89,9 +91,8 @@ func publoop(l *Logger, dur time.Duration) {
 	var trans *nsq.ProducerTransaction
 	var err error
 	var retries int
-	buf := bytes.NewBuffer(nil)
 	//pre-emptively allocate some space
-	buf.Grow(256)
+	buf := make([]byte, 0, 256)
 
 	for {
 		select {
@@ -105,12 +106,12 @@ func publoop(l *Logger, dur time.Duration) {
 				goto exit
 			}
 			//write message to buffer
-			err = msg.Encode(buf)
+			buf, err = l.encode(buf, msg)
 			if err != nil {
 				log.Printf("flux/log: Message encode error: %s", err.Error())
 			}
 		send:
-			err = l.w.PublishAsync(l.Topic, buf.Bytes(), dones, nil)
+			err = l.w.PublishAsync(l.Topic, buf, dones, nil)
 			if retries > nsqRetries {
 				log.Printf("ERROR: flux/log: Couldn't connect to NSQ after %d retries. Closing publoop.", retries)
 				log.Printf("ERROR: flux/log: Couldn't send message %v", msg)
@@ -141,7 +142,7 @@ func publoop(l *Logger, dur time.Duration) {
 			}
 
 			// always reset buffer on continue
-			buf.Reset()
+			buf = buf[:0]
 		}
 
 	}
@@ -150,6 +151,32 @@ exit:
 	l.wg.Done()
 }
 
+// encode appends 'e' to 'b', preceded
+// by an envelope if one has been set.
+// Encoders that are also msg.Appenders
+// are appended without an intermediate buffer.
+func (l *Logger) encode(b []byte, e msg.Encoder) ([]byte, error) {
+	if l.env {
+		b = msg.AppendEnvelope(b, l.fp)
+	}
+	if a, ok := e.(msg.Appender); ok {
+		return a.Append(b)
+	}
+	buf := bytes.NewBuffer(b)
+	err := e.Encode(buf)
+	return buf.Bytes(), err
+}
+
+// UseEnvelope makes the logger prefix every message with an
+// envelope carrying the Fingerprint of 's' (see msg.WriteEnvelope),
+// so that consumers can verify that they are decoding messages
+// with the right Schema. Loggers that send Entries should use
+// EntrySchema. UseEnvelope must be called before the first message is sent.
+func (l *Logger) UseEnvelope(s *msg.Schema) {
+	l.fp = s.Fingerprint()
+	l.env = true
+}
+
 // add a publisher worker
 func (l *Logger) addworker() {
 	// don't add if done
@@ -5,8 +5,15 @@ import (
 	"time"
 )
 
+// EntrySchema is the msg.Schema of an encoded Entry.
+var EntrySchema = msg.Schema{
+	{Name: "time", T: msg.Uint},
+	{Name: "level", T: msg.Int},
+	{Name: "message", T: msg.String},
+}
+
 // Entry is a simple timestamped leveled message.
-// It satisfies the msg.Encoder and msg.Decoder interfaces.
+// It satisfies the msg.Encoder, msg.Appender, and msg.Decoder interfaces.
 // Entry is an example of a type that can be used
 // with go-flux.
 type Entry struct {
@@ -38,6 +45,13 @@ func (e *Entry) Encode(w msg.Writer) error {
 	return nil
 }
 
+// Append appends an entry with a timestamp of time.Now().Unix()
+func (e *Entry) Append(b []byte) ([]byte, error) {
+	b = msg.AppendUint(b, e.Timestamp())
+	b = msg.AppendInt(b, e.Level)
+	return msg.AppendString(b, e.Message), nil
+}
+
 // Decode reads an Entry from a msg.Reader
 func (e *Entry) Decode(r msg.Reader) error {
 	stamp, err := msg.ReadUint(r)
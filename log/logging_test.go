@@ -0,0 +1,69 @@
+package log
+
+import (
+	"bytes"
+	"github.com/A2B-Bikeshare/go-flux/msg"
+	"testing"
+)
+
+func TestEntrySchema(t *testing.T) {
+	e := &Entry{Level: 2, Message: "hello"}
+	e.Stamp()
+	buf := bytes.NewBuffer(nil)
+	e.Encode(buf)
+
+	m := make(map[string]interface{})
+	err := EntrySchema.DecodeToMap(buf, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["time"] != e.Timestamp() || m["level"] != int64(2) || m["message"] != "hello" {
+		t.Errorf("Bad decoded entry: %v", m)
+	}
+}
+
+func TestEntryAppend(t *testing.T) {
+	e := &Entry{Level: -3, Message: "appended"}
+	e.Stamp()
+	buf := bytes.NewBuffer(nil)
+	e.Encode(buf)
+	b, err := e.Append(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, buf.Bytes()) {
+		t.Errorf("Append and Encode differ: %x != %x", b, buf.Bytes())
+	}
+}
+
+func TestLoggerEnvelope(t *testing.T) {
+	l := new(Logger)
+	e := &Entry{Level: 1, Message: "enveloped"}
+	b, err := l.encode(nil, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.HasEnvelope(b) {
+		t.Error("Logger wrote an envelope before UseEnvelope")
+	}
+
+	l.UseEnvelope(&EntrySchema)
+	b, err = l.encode(b[:0], e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]interface{})
+	err = EntrySchema.DecodeEnvelopeToMap(b, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["message"] != "enveloped" {
+		t.Errorf("Bad decoded entry: %v", m)
+	}
+
+	other := msg.Schema{{Name: "time", T: msg.Uint}}
+	err = other.DecodeEnvelopeToMap(b, m)
+	if _, ok := err.(*msg.FingerprintError); !ok {
+		t.Errorf("Expected *msg.FingerprintError; got %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package msg
+
+import (
+	"math"
+	"time"
+)
+
+// The Append functions append the encoding of a value
+// to a byte slice and return the extended slice. Their
+// output is identical to that of the corresponding Write
+// functions, but they do not go through a Writer.
+
+// AppendMapHeader appends a map header of size 'n' (see WriteMapHeader).
+func AppendMapHeader(b []byte, n uint32) []byte {
+	switch {
+	case n < 16:
+		return append(b, mfixmap|byte(n))
+	case n < 1<<16-1:
+		return append(b, mmap16, byte(n>>8), byte(n))
+	default:
+		return append(b, mmap32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// AppendArrayHeader appends an array header of size 'n' (see WriteArrayHeader).
+func AppendArrayHeader(b []byte, n uint32) []byte {
+	switch {
+	case n < 16:
+		return append(b, mfixarray|byte(n))
+	case n < 1<<16-1:
+		return append(b, marray16, byte(n>>8), byte(n))
+	default:
+		return append(b, marray32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// AppendNil appends a 'nil'.
+func AppendNil(b []byte) []byte { return append(b, mnil) }
+
+// AppendFloat appends a float as a float32 if it is
+// in float32 range, or as a float64 otherwise (see WriteFloat).
+func AppendFloat(b []byte, f float64) []byte {
+	a := math.Abs(f)
+	if a > math.MaxFloat32 || a < math.SmallestNonzeroFloat32 {
+		return AppendFloat64(b, f)
+	}
+	return AppendFloat32(b, float32(f))
+}
+
+// AppendFloat64 appends a float64.
+func AppendFloat64(b []byte, f float64) []byte {
+	return appendUint64(append(b, mfloat64), math.Float64bits(f))
+}
+
+// AppendFloat32 appends a float32.
+func AppendFloat32(b []byte, f float32) []byte {
+	return appendUint32(append(b, mfloat32), math.Float32bits(f))
+}
+
+// AppendBin appends a []byte as MessagePack 'bin'.
+func AppendBin(b []byte, dat []byte) []byte {
+	n := len(dat)
+	switch {
+	case n < 1<<8-1:
+		b = append(b, mbin8, byte(n))
+	case n < 1<<16-1:
+		b = append(b, mbin16, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, mbin32), uint32(n))
+	}
+	return append(b, dat...)
+}
+
+// AppendExt appends an extension of type 'etype'.
+func AppendExt(b []byte, etype int8, dat []byte) []byte {
+	n := len(dat)
+	switch {
+	case n == 1:
+		b = append(b, mfixext1)
+	case n == 2:
+		b = append(b, mfixext2)
+	case n == 4:
+		b = append(b, mfixext4)
+	case n == 8:
+		b = append(b, mfixext8)
+	case n == 16:
+		b = append(b, mfixext16)
+	case n < 1<<8-1:
+		b = append(b, mext8, byte(n))
+	case n < 1<<16-1:
+		b = append(b, mext16, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, mext32), uint32(n))
+	}
+	return append(append(b, byte(etype)), dat...)
+}
+
+// AppendBool appends a bool.
+func AppendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, mtrue)
+	}
+	return append(b, mfalse)
+}
+
+// AppendString appends a string.
+func AppendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, byte(n&0x1f)|mfixstr)
+	case n < 256:
+		b = append(b, mstr8, byte(n))
+	case n < 1<<16-1:
+		b = append(b, mstr16, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, mstr32), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// AppendInt appends an int64 in the smallest possible encoding.
+func AppendInt(b []byte, v int64) []byte {
+	if v > 0 {
+		switch {
+		case v < 128:
+			return append(b, byte(v&0x7f))
+		case v < 256:
+			return append(b, mint8, byte(v))
+		case v < 1<<15-1:
+			return append(b, mint16, byte(v>>8), byte(v))
+		case v < 1<<31-1:
+			return appendUint32(append(b, mint32), uint32(v))
+		default:
+			return appendUint64(append(b, mint64), uint64(v))
+		}
+	}
+	switch {
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= -1<<15:
+		return append(b, mint16, byte(v>>8), byte(v))
+	case v >= -1<<31:
+		return appendUint32(append(b, mint32), uint32(v))
+	default:
+		return appendUint64(append(b, mint64), uint64(v))
+	}
+}
+
+// AppendUint appends a uint64 in the smallest possible encoding.
+func AppendUint(b []byte, v uint64) []byte {
+	switch {
+	case v < 128:
+		return append(b, byte(v&0x7f))
+	case v < 256:
+		return append(b, muint8, byte(v))
+	case v < 1<<16-1:
+		return append(b, muint16, byte(v>>8), byte(v))
+	case v < 1<<32-1:
+		return appendUint32(append(b, muint32), uint32(v))
+	default:
+		return appendUint64(append(b, muint64), v)
+	}
+}
+
+// AppendTime appends a timestamp extension (see WriteTime).
+func AppendTime(b []byte, t time.Time) []byte {
+	secs := t.Unix()
+	nsec := uint32(t.Nanosecond())
+	if uint64(secs)>>34 == 0 {
+		d := uint64(nsec)<<34 | uint64(secs)
+		if d>>32 == 0 {
+			return appendUint32(append(b, mfixext4, mtimeExt), uint32(d))
+		}
+		return appendUint64(append(b, mfixext8, mtimeExt), d)
+	}
+	b = appendUint32(append(b, mext8, 12, mtimeExt), nsec)
+	return appendUint64(b, uint64(secs))
+}
+
+// AppendEnvelope appends an envelope carrying
+// the Fingerprint 'f' (see WriteEnvelope).
+func AppendEnvelope(b []byte, f Fingerprint) []byte {
+	return appendUint64(append(b, mfixext8, menvelopeExt), uint64(f))
+}
+
+// appendUint32 appends 'u' in big-endian order
+func appendUint32(b []byte, u uint32) []byte {
+	return append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+// appendUint64 appends 'u' in big-endian order
+func appendUint64(b []byte, u uint64) []byte {
+	return append(b, byte(u>>56), byte(u>>48), byte(u>>40), byte(u>>32),
+		byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
@@ -0,0 +1,123 @@
+package msg
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendMatchesWrite(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	check := func(name string, b []byte, write func(w Writer)) {
+		buf.Reset()
+		write(buf)
+		if !bytes.Equal(b, buf.Bytes()) {
+			t.Errorf("%s: Append and Write differ:\n%x\n%x", name, b, buf.Bytes())
+		}
+	}
+
+	sizes := []int{0, 1, 15, 16, 31, 32, 127, 128, 254, 255, 256, 1<<16 - 2, 1<<16 - 1, 1 << 16, 70000}
+	for _, n := range sizes {
+		s := strings.Repeat("x", n)
+		dat := []byte(s)
+		check("string", AppendString(nil, s), func(w Writer) { WriteString(w, s) })
+		check("bin", AppendBin(nil, dat), func(w Writer) { WriteBin(w, dat) })
+		check("ext", AppendExt(nil, 5, dat), func(w Writer) { WriteExt(w, 5, dat) })
+		check("array", AppendArrayHeader(nil, uint32(n)), func(w Writer) { WriteArrayHeader(w, uint32(n)) })
+		check("map", AppendMapHeader(nil, uint32(n)), func(w Writer) { WriteMapHeader(w, uint32(n)) })
+	}
+	for _, n := range []int{2, 4, 8, 16} {
+		dat := make([]byte, n)
+		check("fixext", AppendExt(nil, -3, dat), func(w Writer) { WriteExt(w, -3, dat) })
+	}
+
+	ints := []int64{0, 1, 127, 128, 255, 256, 1<<15 - 2, 1<<15 - 1, 1 << 15, 1<<31 - 2, 1<<31 - 1,
+		math.MaxInt64, -1, -32, -33, -1 << 15, -1<<15 - 1, -1 << 31, -1<<31 - 1, math.MinInt64}
+	for _, i := range ints {
+		check("int", AppendInt(nil, i), func(w Writer) { WriteInt(w, i) })
+	}
+	uints := []uint64{0, 127, 128, 255, 256, 1<<16 - 2, 1<<16 - 1, 1<<32 - 2, 1<<32 - 1, math.MaxUint64}
+	for _, u := range uints {
+		check("uint", AppendUint(nil, u), func(w Writer) { WriteUint(w, u) })
+	}
+	floats := []float64{0, 3.5, -1e-50, math.MaxFloat64, math.Inf(1)}
+	for _, f := range floats {
+		check("float", AppendFloat(nil, f), func(w Writer) { WriteFloat(w, f) })
+		check("float64", AppendFloat64(nil, f), func(w Writer) { WriteFloat64(w, f) })
+		check("float32", AppendFloat32(nil, float32(f)), func(w Writer) { WriteFloat32(w, float32(f)) })
+	}
+	times := []time.Time{time.Unix(1414000000, 0), time.Unix(1414000000, 5), time.Unix(1<<35, 5), time.Unix(-1, 0)}
+	for _, tm := range times {
+		check("time", AppendTime(nil, tm), func(w Writer) { WriteTime(w, tm) })
+	}
+	check("true", AppendBool(nil, true), func(w Writer) { WriteBool(w, true) })
+	check("false", AppendBool(nil, false), func(w Writer) { WriteBool(w, false) })
+	check("nil", AppendNil(nil), func(w Writer) { WriteNil(w) })
+	check("envelope", AppendEnvelope(nil, 0xdeadbeef), func(w Writer) { WriteEnvelope(w, 0xdeadbeef) })
+
+	// appending preserves the prefix
+	b := AppendString([]byte{0xc0}, "x")
+	if !bytes.Equal(b, []byte{0xc0, 0xa1, 'x'}) {
+		t.Errorf("Bad appended bytes: %x", b)
+	}
+}
+
+func TestAppendSlice(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := iterSchema.EncodeSlice(iterValues, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := iterSchema.AppendSlice(nil, iterValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, buf.Bytes()) {
+		t.Errorf("AppendSlice and EncodeSlice differ:\n%x\n%x", b, buf.Bytes())
+	}
+
+	bad := make([]interface{}, len(iterValues))
+	copy(bad, iterValues)
+	bad[1] = "not an int"
+	_, err = iterSchema.AppendSlice(nil, bad)
+	if err != ErrIncorrectType {
+		t.Errorf("Expected ErrIncorrectType; got %v", err)
+	}
+
+	// nil extensions
+	es := Schema{{Name: "ext", T: Ext}, {Name: "exts", T: Array, Elem: Ext}}
+	for _, v := range [][]interface{}{
+		{(*PackExt)(nil), []*PackExt{}},
+		{&PackExt{EType: 1}, []*PackExt{{EType: 1}, nil}},
+	} {
+		_, err = es.AppendSlice(nil, v)
+		if err != ErrIncorrectType {
+			t.Errorf("Expected ErrIncorrectType for a nil *PackExt; got %v", err)
+		}
+	}
+}
+
+func BenchmarkAppendSlice(b *testing.B) {
+	b.ReportAllocs()
+	names := []string{"float", "int", "uint", "string", "bin"}
+	values := make([]interface{}, len(names))
+	values[0] = float64(3.589)
+	values[1] = int64(-2000)
+	values[2] = uint64(586)
+	values[3] = "here's a string"
+	values[4] = []byte{3, 4, 5}
+
+	s, err := MakeSchema(names, values)
+	if err != nil {
+		b.Fatal(err)
+	}
+	bts, _ := s.AppendSlice(make([]byte, 0, 40), values)
+	b.SetBytes(int64(len(bts)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bts, _ = s.AppendSlice(bts[:0], values)
+	}
+}
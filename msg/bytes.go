@@ -1,6 +1,6 @@
 package msg
 
-import ()
+import "strconv"
 
 const (
 	mfixint    uint8 = 0x00
@@ -65,7 +65,7 @@ const (
 	Int Type = iota
 	//Uint represents a uint8, uint16, uint32, or uint64 (or a MessagePack positive 'fixint')
 	Uint
-	//Map represents a MessagePack map type
+	//String represents a MessagePack str8, str16, str32, or fixstr
 	String
 	//Bool is a boolean
 	Bool
@@ -75,4 +75,158 @@ const (
 	Ext
 	//Float represents a float32 or float64 MessagePack type
 	Float
+	//Array represents a MessagePack array of values of a single Type (see Object.Elem).
+	//Arrays are decoded into typed slices: []float64, []int64, []uint64, []bool,
+	//[]string, [][]byte, []*PackExt, or []time.Time.
+	Array
+	//Struct represents a nested message described by Object.Schema.
+	//Struct values are encoded inline, without any header.
+	Struct
+	//Map represents a MessagePack map with string keys and values
+	//of a single Type (see Object.Elem). Maps are decoded into a
+	//map[string]interface{}.
+	Map
+	//Time represents a MessagePack timestamp extension (ext type -1)
+	//in its 32-, 64-, or 96-bit form. Times are decoded into a time.Time.
+	Time
 )
+
+var typeNames = [...]string{
+	Int:    "int",
+	Uint:   "uint",
+	String: "string",
+	Bool:   "bool",
+	Bin:    "bin",
+	Ext:    "ext",
+	Float:  "float",
+	Array:  "array",
+	Struct: "struct",
+	Map:    "map",
+	Time:   "time",
+}
+
+// String returns the lower-case name of the Type (e.g. "float").
+func (t Type) String() string {
+	if int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return "Type(" + strconv.Itoa(int(t)) + ")"
+}
+
+// parseType returns the Type named 'name' (see Type.String)
+func parseType(name string) (Type, bool) {
+	for i, n := range typeNames {
+		if n == name {
+			return Type(i), true
+		}
+	}
+	return 0, false
+}
+
+// timeExt is the MessagePack extension type of a timestamp
+// (mtimeExt is the same value as a byte)
+const (
+	timeExt  int8  = -1
+	mtimeExt uint8 = 0xff
+)
+
+// tagNames are the names of the tags from 0xc0 to 0xdf
+var tagNames = [...]string{
+	"nil", "(never used)", "false", "true", "bin8", "bin16", "bin32",
+	"ext8", "ext16", "ext32", "float32", "float64",
+	"uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64",
+	"fixext1", "fixext2", "fixext4", "fixext8", "fixext16",
+	"str8", "str16", "str32", "array16", "array32", "map16", "map32",
+}
+
+// tagName returns the MessagePack name of the tag 'c' (e.g. "fixstr")
+func tagName(c byte) string {
+	switch {
+	case c <= mfixintMAX:
+		return "positive fixint"
+	case c >= mnfixint:
+		return "negative fixint"
+	case c <= mfixmapMAX:
+		return "fixmap"
+	case c <= mfixarrayMAX:
+		return "fixarray"
+	case c <= mfixstrMAX:
+		return "fixstr"
+	default:
+		return tagNames[c-mnil]
+	}
+}
+
+// tagShape describes the encoding that follows the tag 'c'.
+// The size of the value is 'size', or, if 'lenBytes' is non-zero,
+// the big-endian integer in the 'lenBytes' bytes after the tag. Strings,
+// bin, and ext have that many bytes of data (plus 'extra' for the ext type);
+// arrays and maps ('mul' != 0) have 'mul' values per element.
+func tagShape(c byte) (size uint32, lenBytes int, extra uint32, mul uint32, err error) {
+	switch {
+	case c <= mfixintMAX || c >= mnfixint:
+		return
+	case c <= mfixmapMAX:
+		size, mul = uint32(c&0x0f), 2
+		return
+	case c <= mfixarrayMAX:
+		size, mul = uint32(c&0x0f), 1
+		return
+	case c <= mfixstrMAX:
+		size = uint32(c & 0x1f)
+		return
+	}
+	switch c {
+	case mnil, mfalse, mtrue:
+	case mint8, muint8:
+		size = 1
+	case mint16, muint16:
+		size = 2
+	case mint32, muint32, mfloat32:
+		size = 4
+	case mint64, muint64, mfloat64:
+		size = 8
+	case mfixext1:
+		size = 2
+	case mfixext2:
+		size = 3
+	case mfixext4:
+		size = 5
+	case mfixext8:
+		size = 9
+	case mfixext16:
+		size = 17
+	case mbin8, mstr8:
+		lenBytes = 1
+	case mbin16, mstr16:
+		lenBytes = 2
+	case mbin32, mstr32:
+		lenBytes = 4
+	case mext8:
+		lenBytes, extra = 1, 1
+	case mext16:
+		lenBytes, extra = 2, 1
+	case mext32:
+		lenBytes, extra = 4, 1
+	case marray16:
+		lenBytes, mul = 2, 1
+	case marray32:
+		lenBytes, mul = 4, 1
+	case mmap16:
+		lenBytes, mul = 2, 2
+	case mmap32:
+		lenBytes, mul = 4, 2
+	default:
+		err = ErrBadTag
+	}
+	return
+}
+
+// beUint32 reads a big-endian integer of up to four bytes
+func beUint32(p []byte) uint32 {
+	var u uint32
+	for _, c := range p {
+		u = u<<8 | uint32(c)
+	}
+	return u
+}
@@ -0,0 +1,249 @@
+package msg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// tests against a standard MessagePack codec
+
+var std codec.MsgpackHandle
+
+func stdEncode(t *testing.T, v interface{}) []byte {
+	var b []byte
+	err := codec.NewEncoderBytes(&b, &std).Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func stdDecode(t *testing.T, b []byte, v interface{}) {
+	err := codec.NewDecoderBytes(b, &std).Decode(v)
+	if err != nil {
+		t.Fatalf("%x: %s", b, err)
+	}
+}
+
+// stdInt converts an integer decoded by the standard codec
+func stdInt(v interface{}) interface{} {
+	switch v := v.(type) {
+	case uint64:
+		return int64(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = stdInt(v[i])
+		}
+	}
+	return v
+}
+
+func TestMsgpackMapStdInts(t *testing.T) {
+	s := Schema{
+		{Name: "i", T: Int},
+		{Name: "ints", T: Array, Elem: Int},
+		{Name: "m", T: Map, Elem: Int},
+		{Name: "u", T: Uint},
+	}
+	for _, i := range []int64{-128, -33, -32, 0, 127, 128, 255, 256, -1 << 40} {
+		u := uint64(i)
+		if i < 0 {
+			u = uint64(-i)
+		}
+		v := []interface{}{i, []int64{i, -i}, map[string]interface{}{"k": i}, u}
+		p, err := s.AppendSlice(nil, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// ours -> standard
+		buf := bytes.NewBuffer(nil)
+		err = s.ToMsgpackMap(p, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		stdDecode(t, buf.Bytes(), &m)
+		if got := stdInt(m["i"]); got != i {
+			t.Errorf("ToMsgpackMap: %d: decoded %v", i, got)
+		}
+		if got := stdInt(m["ints"]); !reflect.DeepEqual(got, []interface{}{i, -i}) {
+			t.Errorf("ToMsgpackMap: %d: decoded array %v", i, got)
+		}
+		if got := stdInt(m["m"].(map[interface{}]interface{})["k"]); got != i {
+			t.Errorf("ToMsgpackMap: %d: decoded map %v", i, got)
+		}
+
+		// standard -> ours
+		sm := stdEncode(t, map[string]interface{}{
+			"i": i, "ints": []int64{i, -i}, "m": map[string]int64{"k": i}, "u": int64(u),
+		})
+		buf.Reset()
+		err = s.FromMsgpackMap(sm, buf)
+		if err != nil {
+			t.Fatalf("FromMsgpackMap: %d: %x: %s", i, sm, err)
+		}
+		if !bytes.Equal(buf.Bytes(), p) {
+			t.Errorf("FromMsgpackMap: %d: got %x; want %x", i, buf.Bytes(), p)
+		}
+	}
+
+	// "v": -100 in a Uint
+	us := Schema{{Name: "v", T: Uint}}
+	err := us.FromMsgpackMap([]byte{0x81, 0xa1, 'v', 0xd0, 0x9c}, bytes.NewBuffer(nil))
+	if merr, ok := err.(*MapError); !ok || merr.Key != "v" {
+		t.Errorf("Expected a *MapError for a negative Uint; got %v", err)
+	}
+}
+
+// the Go types of decoded values, by Type
+var goTypes = map[Type]reflect.Type{
+	Int:    reflect.TypeOf(int64(0)),
+	Uint:   reflect.TypeOf(uint64(0)),
+	Float:  reflect.TypeOf(float64(0)),
+	Bool:   reflect.TypeOf(false),
+	String: reflect.TypeOf(""),
+	Bin:    reflect.TypeOf([]byte(nil)),
+	Ext:    reflect.TypeOf((*PackExt)(nil)),
+	Time:   reflect.TypeOf(time.Time{}),
+}
+
+// stdValue converts a value decoded by the standard
+// codec into the value that DecodeToSlice decodes
+// (or DecodeToMap, for Structs)
+func stdValue(o Object, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch o.T {
+	case Int:
+		return reflect.ValueOf(v).Convert(goTypes[Int]).Interface()
+	case Uint:
+		return reflect.ValueOf(v).Convert(goTypes[Uint]).Interface()
+	case Float:
+		return reflect.ValueOf(v).Convert(goTypes[Float]).Interface()
+	case String:
+		if b, ok := v.([]byte); ok {
+			return string(b)
+		}
+	case Bin:
+		// RawToString applies to bin, too
+		if s, ok := v.(string); ok {
+			return []byte(s)
+		}
+	case Time:
+		return v.(time.Time).UTC()
+	case Ext:
+		x := v.(codec.RawExt)
+		return &PackExt{EType: int8(x.Tag), Data: x.Data}
+	case Array:
+		a := v.([]interface{})
+		out := reflect.MakeSlice(reflect.SliceOf(goTypes[o.Elem]), len(a), len(a))
+		for i := range a {
+			out.Index(i).Set(reflect.ValueOf(stdValue(Object{T: o.Elem}, a[i])))
+		}
+		return out.Interface()
+	case Map:
+		m := make(map[string]interface{})
+		for k, e := range v.(map[interface{}]interface{}) {
+			m[stdValue(Object{T: String}, k).(string)] = stdValue(Object{T: o.Elem}, e)
+		}
+		return m
+	case Struct:
+		m := make(map[string]interface{})
+		sm := v.(map[interface{}]interface{})
+		for _, so := range *o.Schema {
+			m[so.Name] = stdValue(so, sm[so.Name])
+		}
+		return m
+	}
+	return v
+}
+
+// stdDecodeSlice decodes the message 'p' with the standard codec
+func stdDecodeSlice(d *codec.Decoder, p []byte, s *Schema) ([]interface{}, error) {
+	out := make([]interface{}, len(*s))
+	for i, o := range *s {
+		// Structs have no header unless they are nil
+		if o.T == Struct && !(o.Optional && IsNil(p[d.NumBytesRead():])) {
+			sv, err := stdDecodeSlice(d, p, o.Schema)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = sv
+			continue
+		}
+		var v interface{}
+		err := d.Decode(&v)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = stdValue(o, v)
+	}
+	return out, nil
+}
+
+// TestConformanceStd checks the bytes written by AppendSlice
+// and ToMsgpackMap, and read by FromMsgpackMap, against a
+// standard MessagePack codec
+func TestConformanceStd(t *testing.T) {
+	var h codec.MsgpackHandle
+	h.RawToString = true
+	h.WriteExt = true
+	for i, c := range conformCases() {
+		s := Schema{c.o}
+		name := func(path string) string { return c.o.Name + "/" + c.o.T.String() + "/" + path }
+		p, err := s.AppendSlice(nil, []interface{}{c.v})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 128 to 255 are written as an int8 holding the unsigned
+		// byte, which standard codecs read as negative; ToMsgpackMap
+		// writes them in standard form
+		if iv, ok := c.v.(int64); !ok || iv < 128 || iv > 255 {
+			d := codec.NewDecoderBytes(p, &h)
+			v, err := stdDecodeSlice(d, p, &s)
+			if err != nil || !reflect.DeepEqual(v[0], c.v) {
+				t.Errorf("case %d: %s: %.64x: got %#v: %v", i, name("AppendSlice"), p, v, err)
+				continue
+			}
+			if n := d.NumBytesRead(); n != len(p) {
+				t.Errorf("case %d: %s: read %d of %d bytes", i, name("AppendSlice"), n, len(p))
+			}
+		}
+
+		buf := bytes.NewBuffer(nil)
+		err = s.ToMsgpackMap(p, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		err = codec.NewDecoderBytes(buf.Bytes(), &h).Decode(&m)
+		if err != nil || !reflect.DeepEqual(stdValue(c.o, m[c.o.Name]), toMap(c.o, c.v)) {
+			t.Errorf("case %d: %s: %.64x: got %#v: %v", i, name("ToMsgpackMap"), buf.Bytes(), m, err)
+		}
+
+		// Ext values have no standard Go type
+		if c.o.T == Ext || (c.o.T == Map || c.o.T == Array) && c.o.Elem == Ext {
+			continue
+		}
+		var sm []byte
+		err = codec.NewEncoderBytes(&sm, &h).Encode(map[string]interface{}{c.o.Name: toMap(c.o, c.v)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		err = s.FromMsgpackMap(sm, buf)
+		out := make([]interface{}, 1)
+		if err == nil {
+			err = s.DecodeToSliceZeroCopy(buf.Bytes(), out)
+		}
+		if err != nil || !reflect.DeepEqual(out[0], c.v) {
+			t.Errorf("case %d: %s: %.64x: got %#v: %v", i, name("FromMsgpackMap"), sm, out[0], err)
+		}
+	}
+}
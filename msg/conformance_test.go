@@ -0,0 +1,201 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// conformance cases: every Type, at every size boundary
+type conformCase struct {
+	o Object
+	v interface{}
+}
+
+func conformCases() []conformCase {
+	var cs []conformCase
+	add := func(o Object, vs ...interface{}) {
+		for _, v := range vs {
+			cs = append(cs, conformCase{o, v})
+		}
+	}
+
+	add(Object{Name: "int", T: Int},
+		int64(0), int64(1), int64(127), int64(128), int64(255), int64(256),
+		int64(math.MaxInt16), int64(math.MaxInt16+1), int64(math.MaxInt32), int64(math.MaxInt32+1), int64(math.MaxInt64),
+		int64(-1), int64(-32), int64(-33), int64(math.MinInt8), int64(math.MinInt8-1),
+		int64(math.MinInt16), int64(math.MinInt16-1), int64(math.MinInt32), int64(math.MinInt32-1), int64(math.MinInt64))
+	add(Object{Name: "uint", T: Uint},
+		uint64(0), uint64(127), uint64(128), uint64(255), uint64(256), uint64(math.MaxUint16), uint64(math.MaxUint16+1),
+		uint64(math.MaxUint32), uint64(math.MaxUint32+1), uint64(1<<53+1), uint64(math.MaxUint64))
+	add(Object{Name: "float", T: Float},
+		0.0, 1.5, -2.25, 3.140625, math.MaxFloat32, math.SmallestNonzeroFloat32, math.MaxFloat64, 1e-300, -1e300)
+	add(Object{Name: "bool", T: Bool}, true, false)
+	for _, n := range []int{0, 1, 31, 32, 255, 256, math.MaxUint16, math.MaxUint16 + 1} {
+		add(Object{Name: "string", T: String}, strings.Repeat("s", n))
+	}
+	for _, n := range []int{0, 1, 254, 255, 256, math.MaxUint16, math.MaxUint16 + 1} {
+		add(Object{Name: "bin", T: Bin}, bytes.Repeat([]byte{0xb1}, n))
+	}
+	for _, n := range []int{1, 2, 3, 4, 8, 16, 17, 255, 256, math.MaxUint16 + 1} {
+		add(Object{Name: "ext", T: Ext},
+			&PackExt{EType: 5, Data: bytes.Repeat([]byte{0xe1}, n)},
+			&PackExt{EType: -5, Data: bytes.Repeat([]byte{0xe2}, n)})
+	}
+	add(Object{Name: "time", T: Time},
+		time.Unix(0, 0).UTC(), time.Unix(1414000000, 0).UTC(), time.Unix(1414000000, 999999999).UTC(),
+		time.Unix(1<<34, 1).UTC(), time.Unix(-1, 5).UTC())
+
+	add(Object{Name: "floats", T: Array, Elem: Float}, []float64{}, []float64{1.5, -0.125})
+	add(Object{Name: "ints", T: Array, Elem: Int}, make([]int64, 15), make([]int64, 16), make([]int64, math.MaxUint16+1))
+	add(Object{Name: "uints", T: Array, Elem: Uint}, []uint64{0, math.MaxUint64})
+	add(Object{Name: "bools", T: Array, Elem: Bool}, []bool{true, false})
+	add(Object{Name: "strings", T: Array, Elem: String}, []string{"", "a", strings.Repeat("b", 300)})
+	add(Object{Name: "bins", T: Array, Elem: Bin}, [][]byte{{}, {1, 2}})
+	add(Object{Name: "exts", T: Array, Elem: Ext}, []*PackExt{{EType: 1, Data: []byte{1}}, {EType: 2, Data: []byte("three")}})
+	add(Object{Name: "times", T: Array, Elem: Time}, []time.Time{time.Unix(1, 0).UTC(), time.Unix(-1, 3).UTC()})
+
+	many := make(map[string]interface{})
+	for i := 0; i < 16; i++ {
+		many[strings.Repeat("k", i+1)] = int64(-i)
+	}
+	add(Object{Name: "ints", T: Map, Elem: Int}, map[string]interface{}{}, many)
+	add(Object{Name: "uints", T: Map, Elem: Uint}, map[string]interface{}{"u": uint64(300)})
+	add(Object{Name: "floats", T: Map, Elem: Float}, map[string]interface{}{"f": 0.25})
+	add(Object{Name: "bools", T: Map, Elem: Bool}, map[string]interface{}{"t": true})
+	add(Object{Name: "strings", T: Map, Elem: String}, map[string]interface{}{"s": "str"})
+	add(Object{Name: "bins", T: Map, Elem: Bin}, map[string]interface{}{"b": []byte{1}})
+	add(Object{Name: "exts", T: Map, Elem: Ext}, map[string]interface{}{"e": &PackExt{EType: 3, Data: []byte{1, 2}}})
+	add(Object{Name: "times", T: Map, Elem: Time}, map[string]interface{}{"t": time.Unix(5, 6).UTC()})
+
+	add(Object{Name: "struct", T: Struct, Schema: &Schema{
+		{Name: "a", T: Int},
+		{Name: "b", T: Struct, Schema: &Schema{{Name: "c", T: String}, {Name: "d", T: Time}}},
+	}}, []interface{}{int64(-300), []interface{}{"c", time.Unix(7, 8).UTC()}})
+
+	// nil, for every Type
+	n := len(cs)
+	for i := 0; i < n; i++ {
+		if i == 0 || cs[i].o.T != cs[i-1].o.T {
+			o := cs[i].o
+			o.Optional = true
+			add(o, nil)
+		}
+	}
+	return cs
+}
+
+// toMap converts a value decoded by DecodeToSlice
+// into the value that DecodeToMap decodes
+func toMap(o Object, v interface{}) interface{} {
+	if o.T != Struct || v == nil {
+		return v
+	}
+	a := v.([]interface{})
+	m := make(map[string]interface{}, len(a))
+	for i, so := range *o.Schema {
+		m[so.Name] = toMap(so, a[i])
+	}
+	return m
+}
+
+func TestConformance(t *testing.T) {
+	for i, c := range conformCases() {
+		s := Schema{c.o, {Name: "end", T: String}}
+		v := []interface{}{c.v, "end"}
+		name := func(path string) string { return c.o.Name + "/" + c.o.T.String() + "/" + path }
+
+		p, err := s.AppendSlice(nil, v)
+		if err != nil {
+			t.Errorf("case %d: %s: %s", i, name("AppendSlice"), err)
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		err = s.EncodeSlice(v, buf)
+		if err != nil || !bytes.Equal(buf.Bytes(), p) {
+			t.Errorf("case %d: %s: %v", i, name("EncodeSlice"), err)
+		}
+
+		if err = s.Validate(p); err != nil {
+			t.Errorf("case %d: %s: %s", i, name("Validate"), err)
+		}
+		if n, err := SkipBytes(p); err != nil || n != ValueLen(p) || n >= len(p) {
+			t.Errorf("case %d: %s: %d bytes: %v", i, name("SkipBytes"), n, err)
+		}
+
+		out := make([]interface{}, 2)
+		err = s.DecodeToSlice(bytes.NewReader(p), out)
+		if err != nil || !reflect.DeepEqual(out, v) {
+			t.Errorf("case %d: %s: got %#v: %v", i, name("DecodeToSlice"), out[0], err)
+		}
+
+		out = make([]interface{}, 2)
+		err = s.DecodeToSliceZeroCopy(p, out)
+		if err != nil || !reflect.DeepEqual(out, v) {
+			t.Errorf("case %d: %s: got %#v: %v", i, name("DecodeToSliceZeroCopy"), out[0], err)
+		}
+
+		m := make(map[string]interface{})
+		err = s.DecodeToMap(bytes.NewReader(p), m)
+		if err != nil || !reflect.DeepEqual(m[c.o.Name], toMap(c.o, c.v)) || m["end"] != "end" {
+			t.Errorf("case %d: %s: got %#v: %v", i, name("DecodeToMap"), m[c.o.Name], err)
+		}
+
+		it := NewIterator(&s, p)
+		if !it.Next() || !bytes.Equal(it.Raw(), p[:len(p)-4]) || it.IsNil() != (c.v == nil) {
+			t.Errorf("case %d: %s: %v", i, name("Iterator"), it.Err())
+		}
+
+		buf.Reset()
+		err = s.WriteJSON(p, buf)
+		if err != nil || !json.Valid(buf.Bytes()) {
+			t.Errorf("case %d: %s: %s: %v", i, name("WriteJSON"), buf.Bytes(), err)
+		} else {
+			js := buf.Bytes()
+			buf = bytes.NewBuffer(nil)
+			err = s.ReadJSON(bytes.NewReader(js), buf)
+			if err != nil || !bytes.Equal(buf.Bytes(), p) {
+				t.Errorf("case %d: %s: %s: %v", i, name("WriteJSON/ReadJSON"), js, err)
+			}
+		}
+
+		buf.Reset()
+		err = s.ToMsgpackMap(p, buf)
+		if err != nil {
+			t.Errorf("case %d: %s: %s", i, name("ToMsgpackMap"), err)
+		} else {
+			mp := buf.Bytes()
+			buf = bytes.NewBuffer(nil)
+			err = s.FromMsgpackMap(mp, buf)
+			if err != nil || !bytes.Equal(buf.Bytes(), p) {
+				t.Errorf("case %d: %s: %v", i, name("ToMsgpackMap/FromMsgpackMap"), err)
+			}
+		}
+
+		// as a Default, through Encode/Decode and old messages
+		if c.v == nil {
+			continue
+		}
+		do := c.o
+		do.Since, do.Default = 1, c.v
+		ds := Schema{{Name: "first", T: String}, do}
+		buf.Reset()
+		ds.Encode(buf)
+		var dec Schema
+		err = dec.Decode(buf)
+		if err != nil || !reflect.DeepEqual(dec, ds) {
+			t.Errorf("case %d: %s: %v", i, name("Encode/Decode"), err)
+			continue
+		}
+		old := AppendString(nil, "first")
+		out = make([]interface{}, 2)
+		err = dec.DecodeToSliceZeroCopy(old, out)
+		if err != nil || !reflect.DeepEqual(out[1], c.v) {
+			t.Errorf("case %d: %s: got %#v: %v", i, name("Default"), out[1], err)
+		}
+	}
+}
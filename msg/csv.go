@@ -0,0 +1,397 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// CSVOptions control the CSV methods of Schema.
+// The zero value reads and writes RFC 4180 records.
+type CSVOptions struct {
+	// Comma is the field delimiter (e.g. '\t' for TSV).
+	// It must be an ASCII character other than '"',
+	// '\r' and '\n'. If it is 0, ',' is used.
+	Comma byte
+}
+
+// defaultCSV is used by WriteCSV and ReadCSV
+var defaultCSV CSVOptions
+
+// comma returns the field delimiter, or
+// ErrBadArgs if opt.Comma is not allowed
+func (opt *CSVOptions) comma() (byte, error) {
+	switch c := opt.Comma; {
+	case c == 0:
+		return ',', nil
+	case c == '"' || c == '\r' || c == '\n' || c >= utf8.RuneSelf:
+		return 0, ErrBadArgs
+	default:
+		return c, nil
+	}
+}
+
+// CSVError is returned by ReadCSV when
+// a record does not match the Schema.
+type CSVError struct {
+	// Column is the name of the column,
+	// or "" for errors in the whole record
+	Column string
+	// Reason describes the error
+	Reason string
+}
+
+func (c *CSVError) Error() string {
+	if c.Column == "" {
+		return "msg: CSV: " + c.Reason
+	}
+	return fmt.Sprintf("msg: CSV column %q: %s", c.Column, c.Reason)
+}
+
+// WriteCSVHeader writes the Names of the Objects
+// in the Schema as a CSV record (see WriteCSV).
+func (s *Schema) WriteCSVHeader(w Writer) error {
+	return s.WriteCSVHeaderWith(w, nil)
+}
+
+// WriteCSVHeaderWith is WriteCSVHeader with options.
+// If 'opt' is nil, the default options are used.
+func (s *Schema) WriteCSVHeaderWith(w Writer, opt *CSVOptions) error {
+	if opt == nil {
+		opt = &defaultCSV
+	}
+	comma, err := opt.comma()
+	if err != nil {
+		return err
+	}
+	for i, o := range *s {
+		if i > 0 {
+			w.WriteByte(comma)
+		}
+		writeCSVField(w, []byte(o.Name), comma, true)
+	}
+	_, err = w.WriteString("\r\n")
+	return err
+}
+
+// WriteCSV writes the message 'p' as a CSV record, terminated
+// by CRLF, with one field per Object. Fields that contain the
+// delimiter, a quote, CR or LF are quoted, as are empty strings.
+// Nil Optional values are written as empty unquoted fields. Int,
+// Uint, Float and Bool values are written as numbers and "true"
+// or "false", Bin values as base64, and Time values as RFC3339
+// (with nanoseconds). Ext, Array, Map and Struct values are written
+// as they are by WriteJSON. Objects that are newer than the message
+// (see Object.Since) are written with their Default values.
+func (s *Schema) WriteCSV(p []byte, w Writer) error {
+	return s.WriteCSVWith(p, w, nil)
+}
+
+// WriteCSVWith is WriteCSV with options.
+// If 'opt' is nil, the default options are used.
+func (s *Schema) WriteCSVWith(p []byte, w Writer, opt *CSVOptions) error {
+	if opt == nil {
+		opt = &defaultCSV
+	}
+	comma, err := opt.comma()
+	if err != nil {
+		return err
+	}
+	varray := [64]byte{}
+	var nr, n int
+	for i := range *s {
+		o := &(*s)[i]
+		if i > 0 {
+			w.WriteByte(comma)
+		}
+		var cell []byte
+		var isnil bool
+
+		// older messages end before newer objects
+		if o.Since > 0 && nr >= len(p) {
+			buf := bytes.NewBuffer(nil)
+			do := o.defaultObject()
+			err = encode(o.Default, do, buf)
+			if err != nil {
+				return err
+			}
+			cell, isnil, _, err = appendCSVCell(varray[0:0], buf.Bytes(), &do)
+		} else {
+			cell, isnil, n, err = appendCSVCell(varray[0:0], p[nr:], o)
+			nr += n
+		}
+		if err != nil {
+			return s.explain(p, err)
+		}
+		writeCSVField(w, cell, comma, !isnil)
+	}
+	_, err = w.WriteString("\r\n")
+	return err
+}
+
+// appendCSVCell appends the text of the leading value in 'p'
+// to 'b', and returns the number of bytes read from 'p' and
+// whether or not the value was nil
+func appendCSVCell(b []byte, p []byte, o *Object) (cell []byte, isnil bool, n int, err error) {
+	if o.Optional && IsNil(p) {
+		return b, true, 1, nil
+	}
+	switch o.T {
+	case String:
+		var s string
+		s, n, err = readStringZeroCopy(p)
+		cell = append(b, s...)
+	case Int:
+		var i int64
+		i, n, err = readIntBytes(p)
+		cell = strconv.AppendInt(b, i, 10)
+	case Uint:
+		var u uint64
+		u, n, err = readUintBytes(p)
+		cell = strconv.AppendUint(b, u, 10)
+	case Float:
+		var f float64
+		f, n, err = readFloatBytes(p)
+		cell = strconv.AppendFloat(b, f, 'f', -1, 64)
+	case Bool:
+		var t bool
+		t, n, err = readBoolBytes(p)
+		cell = strconv.AppendBool(b, t)
+	case Bin:
+		var dat []byte
+		dat, n, err = readBinZeroCopy(p)
+		cell = append(b, base64.StdEncoding.EncodeToString(dat)...)
+	case Time:
+		var t time.Time
+		t, n, err = readTimeBytes(p)
+		cell = t.AppendFormat(b, time.RFC3339Nano)
+	default:
+		buf := bytes.NewBuffer(b)
+		n, err = writeJSONValue(p, o, buf, nil, &defaultJSON)
+		cell = buf.Bytes()
+	}
+	return
+}
+
+// writeCSVField writes 'f' as a CSV field, quoting
+// it if necessary, or if it is empty and 'quoteEmpty'
+// is true
+func writeCSVField(w Writer, f []byte, comma byte, quoteEmpty bool) {
+	quote := quoteEmpty && len(f) == 0
+	for _, c := range f {
+		if c == comma || c == '"' || c == '\r' || c == '\n' {
+			quote = true
+			break
+		}
+	}
+	if !quote {
+		w.Write(f)
+		return
+	}
+	w.WriteByte(qte)
+	for {
+		i := bytes.IndexByte(f, '"')
+		if i < 0 {
+			break
+		}
+		w.Write(f[:i+1])
+		w.WriteByte(qte)
+		f = f[i+1:]
+	}
+	w.Write(f)
+	w.WriteByte(qte)
+}
+
+// ReadCSV reads a CSV record from 'r' and writes it to 'w' as a
+// message. It accepts the output of WriteCSV, with records terminated
+// by CRLF or LF. An empty unquoted field is nil if its Object is Optional.
+// If the record has fewer fields than the Schema, the missing fields
+// are written as the Defaults of their Objects (see Object.Since), or
+// as nil if their Objects are Optional. Records that do not match
+// the Schema are returned as a *CSVError, and nothing is written to 'w'.
+// ReadCSV reads no further than the end of the record, so it can be
+// called once per record; it returns io.EOF if 'r' is at EOF.
+func (s *Schema) ReadCSV(r Reader, w Writer) error {
+	return s.ReadCSVWith(r, w, nil)
+}
+
+// ReadCSVWith is ReadCSV with options.
+// If 'opt' is nil, the default options are used.
+func (s *Schema) ReadCSVWith(r Reader, w Writer, opt *CSVOptions) error {
+	if opt == nil {
+		opt = &defaultCSV
+	}
+	comma, err := opt.comma()
+	if err != nil {
+		return err
+	}
+	cells, quoted, err := readCSVRecord(r, comma)
+	if err != nil {
+		return err
+	}
+	if len(cells) > len(*s) {
+		return &CSVError{Reason: fmt.Sprintf("found %d fields; expected %d", len(cells), len(*s))}
+	}
+	buf := bytes.NewBuffer(nil)
+	for i := range *s {
+		o := &(*s)[i]
+		if i >= len(cells) {
+			switch {
+			case o.Default != nil:
+				err = encode(o.Default, *o, buf)
+			case o.Optional:
+				writeNil(buf)
+			default:
+				return &CSVError{Column: o.Name, Reason: "missing"}
+			}
+		} else {
+			err = o.encodeCSV(cells[i], quoted[i], buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// ReadCSVHeader reads a CSV record from 'r' and
+// returns a *CSVError unless it is the record
+// written by WriteCSVHeader.
+func (s *Schema) ReadCSVHeader(r Reader) error {
+	return s.ReadCSVHeaderWith(r, nil)
+}
+
+// ReadCSVHeaderWith is ReadCSVHeader with options.
+// If 'opt' is nil, the default options are used.
+func (s *Schema) ReadCSVHeaderWith(r Reader, opt *CSVOptions) error {
+	if opt == nil {
+		opt = &defaultCSV
+	}
+	comma, err := opt.comma()
+	if err != nil {
+		return err
+	}
+	cells, _, err := readCSVRecord(r, comma)
+	if err != nil {
+		return err
+	}
+	if len(cells) != len(*s) {
+		return &CSVError{Reason: fmt.Sprintf("found %d columns; expected %d", len(cells), len(*s))}
+	}
+	for i, o := range *s {
+		if string(cells[i]) != o.Name {
+			return &CSVError{Column: o.Name, Reason: fmt.Sprintf("found column %q", cells[i])}
+		}
+	}
+	return nil
+}
+
+// encodeCSV encodes the text of a CSV field
+func (o *Object) encodeCSV(cell []byte, quoted bool, w Writer) error {
+	if len(cell) == 0 && !quoted && o.Optional {
+		writeNil(w)
+		return nil
+	}
+	var v interface{}
+	var err error
+	s := string(cell)
+	switch o.T {
+	case String:
+		v = s
+	case Int:
+		v, err = strconv.ParseInt(s, 10, 64)
+	case Uint:
+		v, err = strconv.ParseUint(s, 10, 64)
+	case Float:
+		v, err = strconv.ParseFloat(s, 64)
+	case Bool:
+		v, err = strconv.ParseBool(s)
+	case Bin:
+		v, err = base64.StdEncoding.DecodeString(s)
+	case Time:
+		v, err = time.Parse(time.RFC3339Nano, s)
+	default:
+		err = o.encodeJSON(json.RawMessage(cell), w, o.Name)
+		if jerr, ok := err.(*JSONError); ok {
+			return &CSVError{Column: o.Name, Reason: fmt.Sprintf("JSON key %q: %s", jerr.Key, jerr.Reason)}
+		}
+		return err
+	}
+	if err != nil {
+		return &CSVError{Column: o.Name, Reason: "expected " + o.typeName()}
+	}
+	return encode(v, *o, w)
+}
+
+// readCSVRecord reads the fields of one CSV record,
+// and whether or not each one was quoted. It returns
+// io.EOF if there are no more records.
+func readCSVRecord(r Reader, comma byte) (cells [][]byte, quoted []bool, err error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		var cell []byte
+		q := c == '"'
+		if q {
+			for {
+				c, err = r.ReadByte()
+				if err == io.EOF {
+					return nil, nil, &CSVError{Reason: "unterminated quoted field"}
+				} else if err != nil {
+					return nil, nil, err
+				}
+				if c == '"' {
+					// "" is a quote; anything else ends the field
+					c, err = r.ReadByte()
+					if err != nil || c != '"' {
+						break
+					}
+				}
+				cell = append(cell, c)
+			}
+		} else {
+			for err == nil && c != comma && c != '\r' && c != '\n' {
+				if c == '"' {
+					return nil, nil, &CSVError{Reason: "quote in unquoted field"}
+				}
+				cell = append(cell, c)
+				c, err = r.ReadByte()
+			}
+		}
+		cells = append(cells, cell)
+		quoted = append(quoted, q)
+
+		switch {
+		case err == io.EOF:
+			return cells, quoted, nil
+		case err != nil:
+			return nil, nil, err
+		case c == comma:
+			c, err = r.ReadByte()
+			if err == io.EOF {
+				// the last field is empty
+				return append(cells, nil), append(quoted, false), nil
+			} else if err != nil {
+				return nil, nil, err
+			}
+		case c == '\r':
+			c, err = r.ReadByte()
+			if err == nil && c != '\n' {
+				r.UnreadByte()
+			}
+			return cells, quoted, nil
+		case c == '\n':
+			return cells, quoted, nil
+		default:
+			return nil, nil, &CSVError{Reason: "unexpected character after quoted field"}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package msg
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCSV(t *testing.T) {
+	for i, c := range conformCases() {
+		s := Schema{c.o, {Name: "end", T: String}}
+		p, err := s.AppendSlice(nil, []interface{}{c.v, "end"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opt := range []*CSVOptions{nil, {Comma: '\t'}, {Comma: '"'}} {
+			buf := bytes.NewBuffer(nil)
+			err = s.WriteCSVWith(p, buf, opt)
+			if opt != nil && opt.Comma == '"' {
+				if err != ErrBadArgs {
+					t.Errorf("case %d: expected ErrBadArgs for a quote delimiter; got %v", i, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("case %d: %s/%s: WriteCSV: %s", i, c.o.Name, c.o.T, err)
+				continue
+			}
+			csv := buf.Bytes()
+			buf = bytes.NewBuffer(nil)
+			err = s.ReadCSVWith(bytes.NewReader(csv), buf, opt)
+			if err != nil || !bytes.Equal(buf.Bytes(), p) {
+				t.Errorf("case %d: %s/%s: ReadCSV: %.100q: %v", i, c.o.Name, c.o.T, csv, err)
+			}
+		}
+	}
+
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "note", T: String, Optional: true},
+		{Name: "val", T: Float},
+		{Name: "tags", T: Array, Elem: String},
+		{Name: "new", T: Int, Since: 1, Default: int64(7)},
+	}
+	enc := func(v ...interface{}) []byte {
+		p, err := s.AppendSlice(nil, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	msgs := [][]byte{
+		enc("a, \"b\"", nil, 1.5, []string{"x"}, int64(1)),
+		enc("", "", -0.25, []string{}, int64(-1)),
+		enc("line\r\nbreak", "note", 0.0, []string{"y", "z"}, int64(2)),
+	}
+	// an older message
+	msgs[2] = msgs[2][:len(msgs[2])-1]
+
+	buf := bytes.NewBuffer(nil)
+	s.WriteCSVHeader(buf)
+	for _, p := range msgs {
+		err := s.WriteCSV(p, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	const expect = "name,note,val,tags,new\r\n" +
+		"\"a, \"\"b\"\"\",,1.5,\"[\"\"x\"\"]\",1\r\n" +
+		"\"\",\"\",-0.25,[],-1\r\n" +
+		"\"line\r\nbreak\",note,0,\"[\"\"y\"\",\"\"z\"\"]\",7\r\n"
+	if buf.String() != expect {
+		t.Errorf("Expected\n%q\ngot\n%q", expect, buf.String())
+	}
+
+	// read it all back, one record at a time
+	r := bytes.NewReader(buf.Bytes())
+	err := s.ReadCSVHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs[2] = enc("line\r\nbreak", "note", 0.0, []string{"y", "z"}, int64(7))
+	for i, p := range msgs {
+		out := bytes.NewBuffer(nil)
+		err = s.ReadCSV(r, out)
+		if err != nil || !bytes.Equal(out.Bytes(), p) {
+			t.Errorf("Record %d: got %x; want %x: %v", i, out.Bytes(), p, err)
+		}
+	}
+	if err = s.ReadCSV(r, bytes.NewBuffer(nil)); err != io.EOF {
+		t.Errorf("Expected io.EOF; got %v", err)
+	}
+
+	// LF line endings, and missing newer fields
+	out := bytes.NewBuffer(nil)
+	err = s.ReadCSV(bytes.NewReader([]byte("n,,2,[]\nnext")), out)
+	if err != nil || !bytes.Equal(out.Bytes(), enc("n", nil, 2.0, []string{}, int64(7))) {
+		t.Errorf("Bad record with LF: %x: %v", out.Bytes(), err)
+	}
+
+	bad := []struct {
+		csv    string
+		column string
+	}{
+		{"a,,x,[]\r\n", "val"},
+		{"a,,1,[1]\r\n", "tags"},
+		{"a,,1,[],1,extra\r\n", ""},
+		{"a,,1\r\n", "tags"},
+		{"\"a,,1,[]\r\n", ""},
+		{"a\"b\",,1,[]\r\n", ""},
+		{"\"a\"b,,1,[]\r\n", ""},
+	}
+	for i, tt := range bad {
+		out.Reset()
+		err = s.ReadCSV(bytes.NewReader([]byte(tt.csv)), out)
+		cerr, ok := err.(*CSVError)
+		if !ok || cerr.Column != tt.column {
+			t.Errorf("Bad record %d: expected a *CSVError for column %q; got %v", i, tt.column, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("Bad record %d: wrote %d bytes", i, out.Len())
+		}
+	}
+
+	if err = s.ReadCSVHeader(bytes.NewReader([]byte("name,note,value,tags,new\r\n"))); err == nil {
+		t.Error("Expected an error for the wrong header")
+	}
+}
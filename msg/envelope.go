@@ -0,0 +1,145 @@
+package msg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+const (
+	// menvelopeExt is the MessagePack extension type of an envelope
+	menvelopeExt uint8 = 0x46 //'F'
+	// envelopeLen is the encoded size of an envelope:
+	// fixext8 tag, extension type, and fingerprint
+	envelopeLen = 10
+)
+
+// ErrNoEnvelope is returned by ReadEnvelopeBytes when
+// a message does not start with an envelope.
+var ErrNoEnvelope = errors.New("Message has no envelope.")
+
+// Fingerprint identifies a Schema. Schemas
+// that encode identically have the same Fingerprint.
+type Fingerprint uint64
+
+func (f Fingerprint) String() string { return fmt.Sprintf("%016x", uint64(f)) }
+
+// FingerprintError is returned when a message's envelope
+// does not carry the Fingerprint of the Schema used to decode it.
+type FingerprintError struct {
+	// Expected is the Fingerprint of the Schema
+	Expected Fingerprint
+	// Found is the Fingerprint in the envelope
+	Found Fingerprint
+}
+
+func (f *FingerprintError) Error() string {
+	return fmt.Sprintf("msg: message has Schema fingerprint %s; expected %s", f.Found, f.Expected)
+}
+
+// Fingerprint returns the Fingerprint of the Schema,
+// which is the 64-bit FNV-1a hash of the output of Encode.
+func (s *Schema) Fingerprint() Fingerprint {
+	buf := bytes.NewBuffer(nil)
+	s.Encode(buf)
+	h := fnv.New64a()
+	h.Write(buf.Bytes())
+	return Fingerprint(h.Sum64())
+}
+
+// Fingerprints returns the Fingerprints of each version of
+// the Schema, oldest first: for each version, the Fingerprint
+// of the leading objects whose Since is no greater than it.
+// The last is s.Fingerprint(). If *s is Compatible with an
+// older Schema, the older Schema's Fingerprint is among them.
+func (s *Schema) Fingerprints() []Fingerprint {
+	var fs []Fingerprint
+	var v int
+	for i, o := range *s {
+		if o.Since > v {
+			prefix := (*s)[:i]
+			fs = append(fs, prefix.Fingerprint())
+			v = o.Since
+		}
+	}
+	return append(fs, s.Fingerprint())
+}
+
+// WriteEnvelope writes an envelope carrying the Fingerprint 'f'.
+// An envelope is an optional prefix to a message; it is written
+// as a MessagePack extension of type 0x46 ('F') with eight bytes
+// of data (the big-endian Fingerprint), so it should not be
+// used with Schemas that begin with an Ext object.
+func WriteEnvelope(w Writer, f Fingerprint) {
+	var bs [8]byte
+	bigend.PutUint64(bs[:], uint64(f))
+	w.WriteByte(mfixext8)
+	w.WriteByte(menvelopeExt)
+	w.Write(bs[:])
+}
+
+// HasEnvelope returns whether or not 'p' begins with an envelope.
+func HasEnvelope(p []byte) bool {
+	return len(p) >= envelopeLen && p[0] == mfixext8 && p[1] == menvelopeExt
+}
+
+// ReadEnvelopeBytes reads the Fingerprint in the envelope at the
+// beginning of 'p', along with the number of bytes read, or ErrNoEnvelope.
+func ReadEnvelopeBytes(p []byte) (f Fingerprint, n int, err error) {
+	if !HasEnvelope(p) {
+		err = ErrNoEnvelope
+		return
+	}
+	f = Fingerprint(ruint64(p[2:]))
+	n = envelopeLen
+	return
+}
+
+// Unwrap returns the body of the message 'p'. If 'p' has an
+// envelope, its Fingerprint must be one of 'fs', or Unwrap returns
+// a *FingerprintError (whose Expected is the last of 'fs').
+// If 'p' has no envelope, it is returned unchanged.
+func Unwrap(p []byte, fs ...Fingerprint) ([]byte, error) {
+	found, n, err := ReadEnvelopeBytes(p)
+	if err != nil {
+		return p, nil
+	}
+	for _, f := range fs {
+		if found == f {
+			return p[n:], nil
+		}
+	}
+	ferr := &FingerprintError{Found: found}
+	if len(fs) > 0 {
+		ferr.Expected = fs[len(fs)-1]
+	}
+	return nil, ferr
+}
+
+// Unwrap is shorthand for Unwrap(p, s.Fingerprints()...), so
+// it accepts messages written with any version of *s. Callers
+// that unwrap many messages should compute the Fingerprints once.
+func (s *Schema) Unwrap(p []byte) ([]byte, error) { return Unwrap(p, s.Fingerprints()...) }
+
+// DecodeEnvelope is DecodeToSliceZeroCopy for a message that may
+// have an envelope; it returns a *FingerprintError if the envelope
+// does not match a version of *s (see Fingerprints).
+func (s *Schema) DecodeEnvelope(p []byte, v []interface{}) error {
+	p, err := s.Unwrap(p)
+	if err != nil {
+		return err
+	}
+	return s.DecodeToSliceZeroCopy(p, v)
+}
+
+// DecodeEnvelopeToMap is DecodeToMap for a message that may
+// have an envelope; it returns a *FingerprintError if the envelope
+// does not match a version of *s (see Fingerprints).
+func (s *Schema) DecodeEnvelopeToMap(p []byte, m map[string]interface{}) error {
+	p, err := s.Unwrap(p)
+	if err != nil {
+		return err
+	}
+	return s.DecodeToMap(bytes.NewReader(p), m)
+}
@@ -0,0 +1,113 @@
+package msg
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	a := Schema{{Name: "name", T: String}, {Name: "val", T: Float}}
+	b := Schema{{Name: "name", T: String}, {Name: "val", T: Float}}
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Error("Identical schemas have different fingerprints")
+	}
+	b[1].Optional = true
+	if a.Fingerprint() == b.Fingerprint() {
+		t.Error("Different schemas have the same fingerprint")
+	}
+	b[1].Optional = false
+	b[1].Name = "value"
+	if a.Fingerprint() == b.Fingerprint() {
+		t.Error("Different schemas have the same fingerprint")
+	}
+	if len(a.Fingerprint().String()) != 16 {
+		t.Errorf("Bad fingerprint string %q", a.Fingerprint().String())
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	s := Schema{{Name: "name", T: String}, {Name: "val", T: Float}}
+	values := []interface{}{"bike", 3.5}
+	buf := bytes.NewBuffer(nil)
+	WriteEnvelope(buf, s.Fingerprint())
+	err := s.EncodeSlice(values, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+
+	if !HasEnvelope(bts) {
+		t.Fatal("HasEnvelope should be true")
+	}
+	fp, n, err := ReadEnvelopeBytes(bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp != s.Fingerprint() || n != 10 {
+		t.Errorf("ReadEnvelopeBytes: %s, %d bytes", fp, n)
+	}
+
+	out := make([]interface{}, 2)
+	err = s.DecodeEnvelope(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, values) {
+		t.Errorf("Expected %v; got %v", values, out)
+	}
+
+	// messages without an envelope are decoded as-is
+	out = make([]interface{}, 2)
+	err = s.DecodeEnvelope(bts[n:], out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, values) {
+		t.Errorf("Expected %v; got %v", values, out)
+	}
+	_, _, err = ReadEnvelopeBytes(bts[n:])
+	if err != ErrNoEnvelope {
+		t.Errorf("Expected ErrNoEnvelope; got %v", err)
+	}
+
+	other := Schema{{Name: "name", T: String}, {Name: "val", T: Int}}
+	err = other.DecodeEnvelopeToMap(bts, make(map[string]interface{}))
+	ferr, ok := err.(*FingerprintError)
+	if !ok {
+		t.Fatalf("Expected *FingerprintError; got %v", err)
+	}
+	if ferr.Expected != other.Fingerprint() || ferr.Found != s.Fingerprint() {
+		t.Errorf("Bad error: %s", ferr)
+	}
+}
+
+func TestUnwrapOldVersions(t *testing.T) {
+	v1 := schemaV2[:4]
+	fps := schemaV2.Fingerprints()
+	expect := []Fingerprint{schemaV0.Fingerprint(), v1.Fingerprint(), schemaV2.Fingerprint()}
+	if !reflect.DeepEqual(fps, expect) {
+		t.Errorf("Expected fingerprints %v; got %v", expect, fps)
+	}
+
+	// a v2 consumer accepts v0, v1 and v2 messages
+	for _, old := range []Schema{schemaV0, v1, schemaV2} {
+		p := AppendEnvelope(nil, old.Fingerprint())
+		p = append(p, 0xa1, 'x')
+		body, err := schemaV2.Unwrap(p)
+		if err != nil || len(body) != 2 {
+			t.Errorf("version %d: Unwrap returned %x, %v", old.Version(), body, err)
+		}
+	}
+
+	// but not v2 messages with a different Default
+	changed := append(Schema(nil), schemaV2...)
+	changed[2].Default = int64(-2)
+	if schemaV2.Compatible(&changed) == nil {
+		t.Error("Expected a changed Default to be incompatible")
+	}
+	_, err := schemaV2.Unwrap(AppendEnvelope(nil, changed.Fingerprint()))
+	if ferr, ok := err.(*FingerprintError); !ok || ferr.Expected != schemaV2.Fingerprint() {
+		t.Errorf("Expected a *FingerprintError; got %v", err)
+	}
+}
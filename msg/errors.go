@@ -0,0 +1,153 @@
+package msg
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TagError is returned by the methods of Schema and Plan when
+// a value has the wrong MessagePack tag. It matches ErrBadTag, so
+// errors.Is(err, ErrBadTag) is true for a *TagError. Methods that
+// read a message from a []byte always know its Offset; DecodeToSlice
+// and DecodeToMap, which read from a Reader, only know it if the
+// Reader is a *bytes.Reader, and they do not know the indexes of
+// Array elements, so Field is the name of the whole Array.
+type TagError struct {
+	// Expected is the expected Type of the value
+	Expected Type
+	// Tag is the MessagePack tag that was found
+	Tag byte
+	// Offset is the offset of the value in the
+	// message, or -1 if it is not known
+	Offset int
+	// Field is the name of the value, including the names
+	// of enclosing Structs (e.g. "loc.lat"), or "" if the
+	// value is not in a Schema
+	Field string
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("msg: %sexpected %s; found tag 0x%02x (%s)%s",
+		fieldPrefix(e.Field), e.Expected, e.Tag, tagName(e.Tag), offsetSuffix(e.Offset))
+}
+
+// Is returns whether or not 'target' is ErrBadTag.
+func (e *TagError) Is(target error) bool { return target == ErrBadTag }
+
+// ShortError is returned by the methods of Schema, Plan and
+// Iterator when a message ends in the middle of a value. It
+// matches ErrShortBytes, so errors.Is(err, ErrShortBytes) is
+// true for a *ShortError. DecodeToSlice and DecodeToMap, which
+// read from a Reader, do not know its Offset, and return io.EOF
+// if the message ends between two values of the Schema (since
+// that is also the end of a stream of messages).
+type ShortError struct {
+	// Expected is the expected Type of the value
+	Expected Type
+	// Offset is the offset of the value in the
+	// message, or -1 if it is not known
+	Offset int
+	// Field is the name of the value (see TagError.Field)
+	Field string
+}
+
+func (e *ShortError) Error() string {
+	return fmt.Sprintf("msg: %sexpected %s; found end of message%s",
+		fieldPrefix(e.Field), e.Expected, offsetSuffix(e.Offset))
+}
+
+// Is returns whether or not 'target' is ErrShortBytes.
+func (e *ShortError) Is(target error) bool { return target == ErrShortBytes }
+
+func fieldPrefix(field string) string {
+	if field == "" {
+		return ""
+	}
+	return fmt.Sprintf("%q: ", field)
+}
+
+func offsetSuffix(off int) string {
+	if off < 0 {
+		return ""
+	}
+	return fmt.Sprintf(" at offset %d", off)
+}
+
+// explain converts ErrBadTag or ErrShortBytes from
+// decoding 'p' into a *TagError or *ShortError that
+// describes the first malformed value in 'p'. Other
+// errors are returned as-is.
+func (s *Schema) explain(p []byte, err error) error {
+	if err != ErrBadTag && err != ErrShortBytes {
+		return err
+	}
+	verr, ok := s.Validate(p).(*ValidationError)
+	if !ok {
+		return err
+	}
+	switch verr.Err {
+	case ErrBadTag:
+		return &TagError{Expected: verr.Expected, Tag: verr.Tag, Offset: verr.Offset, Field: verr.Name}
+	case ErrShortBytes:
+		return &ShortError{Expected: verr.Expected, Offset: verr.Offset, Field: verr.Name}
+	default:
+		return err
+	}
+}
+
+// readError converts the tagError or io.ErrUnexpectedEOF from
+// reading a value of Type 't' named 'field' from 'r' into a
+// *TagError or *ShortError, or adds 'field' to the name of
+// a *TagError or *ShortError from a nested value. Other errors
+// are returned as-is. It must be called directly after the
+// failed read, so that the bad tag is the last byte read
+// (see TagError.Offset).
+func readError(r Reader, t Type, field string, err error) error {
+	switch e := err.(type) {
+	case *TagError:
+		e.Field = joinField(field, e.Field)
+		return e
+	case *ShortError:
+		e.Field = joinField(field, e.Field)
+		return e
+	case tagError:
+		te := &TagError{Expected: t, Tag: byte(e), Offset: -1, Field: field}
+		// *bytes.Reader knows where it is
+		if sr, ok := r.(interface {
+			Len() int
+			Size() int64
+		}); ok {
+			te.Offset = int(sr.Size()) - sr.Len() - 1
+		}
+		return te
+	default:
+		if err == io.ErrUnexpectedEOF {
+			return &ShortError{Expected: t, Offset: -1, Field: field}
+		}
+		return err
+	}
+}
+
+// inValue converts io.EOF from reading part
+// of a value (e.g. an Array element) into
+// io.ErrUnexpectedEOF (see readError)
+func inValue(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// joinField joins the name of a value and
+// the name of a value nested in it
+func joinField(outer string, inner string) string {
+	switch {
+	case inner == "":
+		return outer
+	case outer == "" || strings.HasPrefix(inner, "["):
+		return outer + inner
+	default:
+		return outer + "." + inner
+	}
+}
@@ -0,0 +1,126 @@
+package msg
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestDecodeErrors(t *testing.T) {
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "val", T: Float},
+		{Name: "tags", T: Array, Elem: String},
+		{Name: "loc", T: Struct, Schema: &Schema{{Name: "lat", T: Float}}},
+	}
+	p, err := s.AppendSlice(nil, []interface{}{"bike", 3.5, []string{"a", "b"}, []interface{}{1.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(i int, c byte) []byte {
+		q := append([]byte{}, p...)
+		q[i] = c
+		return q
+	}
+	v := make([]interface{}, len(s))
+
+	tests := []struct {
+		msg      []byte
+		field    string
+		rfield   string // readers don't know array indexes
+		offset   int
+		expected Type
+		tag      byte
+	}{
+		{corrupt(5, 0xa1), "val", "val", 5, Float, 0xa1},
+		{corrupt(13, 0x01), "tags[1]", "tags", 13, String, 0x01},
+		{corrupt(15, 0xc3), "loc.lat", "loc.lat", 15, Float, 0xc3},
+	}
+	for i, tt := range tests {
+		decoders := map[string]func([]byte) error{
+			"DecodeToSliceZeroCopy": func(p []byte) error { return s.DecodeToSliceZeroCopy(p, v) },
+			"WriteJSON":             func(p []byte) error { return s.WriteJSON(p, bytes.NewBuffer(nil)) },
+			"ToMsgpackMap":          func(p []byte) error { return s.ToMsgpackMap(p, bytes.NewBuffer(nil)) },
+			"DecodeToSlice":         func(p []byte) error { return s.DecodeToSlice(bytes.NewReader(p), v) },
+			"DecodeToMap": func(p []byte) error {
+				return s.DecodeToMap(bytes.NewReader(p), make(map[string]interface{}))
+			},
+		}
+		for name, decode := range decoders {
+			err := decode(tt.msg)
+			if !errors.Is(err, ErrBadTag) {
+				t.Errorf("Test case %d: %s: expected ErrBadTag; got %v", i, name, err)
+				continue
+			}
+			terr, ok := err.(*TagError)
+			if !ok {
+				t.Errorf("Test case %d: %s: expected *TagError; got %T", i, name, err)
+				continue
+			}
+			field := tt.field
+			if name == "DecodeToSlice" || name == "DecodeToMap" {
+				field = tt.rfield
+			}
+			if terr.Field != field || terr.Offset != tt.offset || terr.Expected != tt.expected || terr.Tag != tt.tag {
+				t.Errorf("Test case %d: %s: got %#v", i, name, terr)
+			}
+		}
+	}
+
+	// truncated messages
+	err = s.DecodeToSliceZeroCopy(p[:7], v)
+	serr, ok := err.(*ShortError)
+	if !ok || !errors.Is(err, ErrShortBytes) {
+		t.Fatalf("Expected *ShortError; got %v", err)
+	}
+	if serr.Field != "val" || serr.Offset != 5 || serr.Expected != Float {
+		t.Errorf("Bad *ShortError: %#v", serr)
+	}
+	it := NewIterator(&s, p[:7])
+	for it.Next() {
+	}
+	if _, ok := it.Err().(*ShortError); !ok {
+		t.Errorf("Expected *ShortError from Iterator; got %v", it.Err())
+	}
+
+	// readers report values cut short, but not
+	// messages that end between values
+	for _, n := range []int{7, 11, 14} {
+		err = s.DecodeToSlice(bytes.NewReader(p[:n]), v)
+		serr, ok := err.(*ShortError)
+		if !ok || serr.Offset != -1 {
+			t.Errorf("DecodeToSlice of %d bytes: expected a *ShortError; got %v", n, err)
+		}
+	}
+	err = s.DecodeToSlice(bytes.NewReader(p[:5]), v)
+	if err != io.EOF {
+		t.Errorf("Expected io.EOF; got %v", err)
+	}
+
+	// validation errors unwrap
+	if err := s.Validate(corrupt(5, 0xa1)); !errors.Is(err, ErrBadTag) {
+		t.Errorf("Expected *ValidationError to match ErrBadTag; got %v", err)
+	}
+
+	// readers without a size report no offset
+	terr, ok := s.DecodeToSlice(bytes.NewBuffer(corrupt(5, 0xa1)), v).(*TagError)
+	if !ok || terr.Offset != -1 || terr.Tag != 0xa1 {
+		t.Errorf("Bad *TagError from a *bytes.Buffer: %#v", terr)
+	}
+	const msg = `msg: "val": expected float; found tag 0xa1 (fixstr)`
+	if terr.Error() != msg {
+		t.Errorf("Expected %q; got %q", msg, terr.Error())
+	}
+
+	// the tag does not depend on unreading it
+	terr, ok = s.DecodeToSlice(noUnread{bytes.NewReader(corrupt(15, 0xc3))}, v).(*TagError)
+	if !ok || terr.Tag != 0xc3 || terr.Field != "loc.lat" {
+		t.Errorf("Bad *TagError from a reader that cannot unread: %#v", terr)
+	}
+}
+
+// noUnread is a Reader that cannot unread
+type noUnread struct{ *bytes.Reader }
+
+func (noUnread) UnreadByte() error { return errors.New("cannot unread") }
@@ -0,0 +1,260 @@
+package msg
+
+import (
+	"bytes"
+	"time"
+)
+
+// Iterator steps through the values of an encoded message
+// one Object at a time, without allocating. Values are read
+// with the accessor that matches the Type of the current Object;
+// like DecodeToSliceZeroCopy, the strings and slices returned
+// point into the message.
+//
+// A typical loop looks like
+//
+//	it := msg.NewIterator(s, p)
+//	for it.Next() {
+//		switch it.Type() {
+//		case msg.Int:
+//			i, err := it.Int()
+//			...
+//		}
+//	}
+//	if it.Err() != nil {
+//		...
+//	}
+//
+// Objects that are newer than the message (see Object.Since)
+// are read as their Default values.
+type Iterator struct {
+	s   *Schema
+	p   []byte
+	nr  int          // bytes read from p
+	i   int          // index of the current object
+	cur []byte       // encoded current value
+	def bytes.Buffer // encoded Default, for old messages
+	err error
+}
+
+// NewIterator returns an Iterator over the message 'p',
+// which must have been encoded with 's'.
+func NewIterator(s *Schema, p []byte) *Iterator {
+	it := new(Iterator)
+	it.Reset(s, p)
+	return it
+}
+
+// Reset resets the Iterator to the start of the
+// message 'p', which must have been encoded with 's'.
+// Iterators can be re-used this way to avoid allocating.
+func (it *Iterator) Reset(s *Schema, p []byte) {
+	it.s = s
+	it.p = p
+	it.nr = 0
+	it.i = -1
+	it.cur = nil
+	it.err = nil
+}
+
+// Next advances to the next Object. It returns false
+// at the end of the Schema, or if the message is malformed,
+// in which case Err returns the error.
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.i >= len(*it.s) {
+		return false
+	}
+	it.i++
+	if it.i == len(*it.s) {
+		it.cur = nil
+		return false
+	}
+	o := &(*it.s)[it.i]
+
+	// older messages end before newer objects
+	if o.Since > 0 && it.nr >= len(it.p) {
+		it.def.Reset()
+		o.encodeDefault(&it.def)
+		it.cur = it.def.Bytes()
+		return true
+	}
+
+	n, err := skipValue(it.p[it.nr:], *o)
+	if err != nil {
+		it.err = it.s.explain(it.p, err)
+		it.cur = nil
+		return false
+	}
+	it.cur = it.p[it.nr : it.nr+n]
+	it.nr += n
+	return true
+}
+
+// Err returns the error that stopped Next, if any.
+func (it *Iterator) Err() error { return it.err }
+
+// Index returns the index of the current Object in the Schema.
+func (it *Iterator) Index() int { return it.i }
+
+// Object returns the current Object.
+func (it *Iterator) Object() *Object { return &(*it.s)[it.i] }
+
+// Name returns the Name of the current Object.
+func (it *Iterator) Name() string { return (*it.s)[it.i].Name }
+
+// Type returns the Type of the current Object.
+func (it *Iterator) Type() Type { return (*it.s)[it.i].T }
+
+// Raw returns the encoded current value.
+func (it *Iterator) Raw() []byte { return it.cur }
+
+// Len returns the number of bytes of the
+// message that have been read so far.
+func (it *Iterator) Len() int { return it.nr }
+
+// IsNil returns whether or not the current value is nil.
+func (it *Iterator) IsNil() bool { return IsNil(it.cur) }
+
+// Int reads the current value as an Int.
+func (it *Iterator) Int() (int64, error) {
+	i, _, err := readIntBytes(it.cur)
+	return i, err
+}
+
+// Uint reads the current value as a Uint.
+func (it *Iterator) Uint() (uint64, error) {
+	u, _, err := readUintBytes(it.cur)
+	return u, err
+}
+
+// Float reads the current value as a Float.
+func (it *Iterator) Float() (float64, error) {
+	f, _, err := readFloatBytes(it.cur)
+	return f, err
+}
+
+// Bool reads the current value as a Bool.
+func (it *Iterator) Bool() (bool, error) {
+	b, _, err := readBoolBytes(it.cur)
+	return b, err
+}
+
+// StringZeroCopy reads the current value as a String
+// (see ReadStringZeroCopy).
+func (it *Iterator) StringZeroCopy() (string, error) {
+	s, _, err := readStringZeroCopy(it.cur)
+	return s, err
+}
+
+// Bytes reads the current value as Bin.
+func (it *Iterator) Bytes() ([]byte, error) {
+	dat, _, err := readBinZeroCopy(it.cur)
+	return dat, err
+}
+
+// Ext reads the current value as an Ext.
+func (it *Iterator) Ext() (etype int8, dat []byte, err error) {
+	dat, etype, _, err = readExtZeroCopy(it.cur)
+	return
+}
+
+// Time reads the current value as a Time.
+func (it *Iterator) Time() (time.Time, error) {
+	t, _, err := readTimeBytes(it.cur)
+	return t, err
+}
+
+// Struct resets 'sub' to iterate over the
+// current value, which must be a Struct.
+func (it *Iterator) Struct(sub *Iterator) {
+	sub.Reset((*it.s)[it.i].sub(), it.cur)
+}
+
+// skipValue returns the length of the
+// leading value of 'o' in 'p', without allocating
+func skipValue(p []byte, o Object) (n int, err error) {
+	if o.Optional && IsNil(p) {
+		return 1, nil
+	}
+	var sz uint32
+	var en int
+	if err = o.checkElem(); err != nil {
+		return
+	}
+	switch o.T {
+	case Array:
+		sz, n, err = readArrayHeaderBytes(p)
+		if err != nil {
+			return
+		}
+		eo := Object{T: o.Elem}
+		for i := uint32(0); i < sz; i++ {
+			en, err = skipValue(p[n:], eo)
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		return
+
+	case Map:
+		sz, n, err = readMapHeaderBytes(p)
+		if err != nil {
+			return
+		}
+		for i := uint32(0); i < sz; i++ {
+			_, en, err = readStringZeroCopy(p[n:])
+			if err != nil {
+				return
+			}
+			n += en
+			en, err = skipScalar(p[n:], o.Elem)
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		return
+
+	case Struct:
+		for _, so := range *o.sub() {
+			if so.Since > 0 && n >= len(p) {
+				return
+			}
+			en, err = skipValue(p[n:], so)
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		return
+
+	default:
+		return skipScalar(p, o.T)
+	}
+}
+
+// skipScalar returns the length of the leading value of type 't'
+func skipScalar(p []byte, t Type) (n int, err error) {
+	switch t {
+	case String:
+		_, n, err = readStringZeroCopy(p)
+	case Int:
+		_, n, err = readIntBytes(p)
+	case Uint:
+		_, n, err = readUintBytes(p)
+	case Float:
+		_, n, err = readFloatBytes(p)
+	case Bool:
+		_, n, err = readBoolBytes(p)
+	case Bin:
+		_, n, err = readBinZeroCopy(p)
+	case Ext:
+		_, _, n, err = readExtZeroCopy(p)
+	case Time:
+		_, n, err = readTimeBytes(p)
+	default:
+		err = ErrTypeNotSupported
+	}
+	return
+}
@@ -0,0 +1,198 @@
+package msg
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var iterSchema = Schema{
+	{Name: "name", T: String},
+	{Name: "int", T: Int},
+	{Name: "uint", T: Uint},
+	{Name: "float", T: Float},
+	{Name: "bool", T: Bool},
+	{Name: "bin", T: Bin},
+	{Name: "ext", T: Ext},
+	{Name: "time", T: Time},
+	{Name: "tags", T: Array, Elem: String},
+	{Name: "labels", T: Map, Elem: Int},
+	{Name: "location", T: Struct, Schema: &Schema{{Name: "lat", T: Float}, {Name: "lon", T: Float}}},
+	{Name: "note", T: String, Optional: true},
+}
+
+var iterValues = []interface{}{
+	"bike", int64(-40), uint64(586), 3.5, true, []byte{1, 2, 3},
+	&PackExt{EType: 4, Data: []byte("ext")}, time.Unix(1414000000, 0).UTC(),
+	[]string{"a", "b"}, map[string]interface{}{"x": int64(1)}, []interface{}{1.5, -2.5}, nil,
+}
+
+func TestIterator(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := iterSchema.EncodeSlice(iterValues, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := buf.Bytes()
+
+	it := NewIterator(&iterSchema, p)
+	for it.Next() {
+		o := iterSchema[it.Index()]
+		if it.Name() != o.Name || it.Type() != o.T {
+			t.Errorf("Expected %s (%s); got %s (%s)", o.Name, o.T, it.Name(), it.Type())
+		}
+		var v interface{}
+		var err error
+		switch it.Type() {
+		case String:
+			if it.IsNil() {
+				continue
+			}
+			v, err = it.StringZeroCopy()
+		case Int:
+			v, err = it.Int()
+		case Uint:
+			v, err = it.Uint()
+		case Float:
+			v, err = it.Float()
+		case Bool:
+			v, err = it.Bool()
+		case Time:
+			v, err = it.Time()
+		case Bin:
+			var dat []byte
+			dat, err = it.Bytes()
+			if !bytes.Equal(dat, []byte{1, 2, 3}) {
+				t.Errorf("Expected bin %v; got %v", iterValues[5], dat)
+			}
+			continue
+		case Ext:
+			etype, dat, err := it.Ext()
+			if err != nil || etype != 4 || string(dat) != "ext" {
+				t.Errorf("Bad ext %d %q: %v", etype, dat, err)
+			}
+			continue
+		case Struct:
+			var sub Iterator
+			it.Struct(&sub)
+			sub.Next()
+			sub.Next()
+			f, err := sub.Float()
+			if err != nil || f != -2.5 || sub.Name() != "lon" {
+				t.Errorf("Bad struct value %s=%f: %v", sub.Name(), f, err)
+			}
+			continue
+		default:
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", it.Name(), err)
+		}
+		if v != iterValues[it.Index()] {
+			t.Errorf("%s: expected %v; got %v", it.Name(), iterValues[it.Index()], v)
+		}
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if it.Index() != len(iterSchema) || it.Len() != len(p) {
+		t.Errorf("Iterator stopped at %d (%d bytes)", it.Index(), it.Len())
+	}
+
+	// truncated messages are an error
+	it.Reset(&iterSchema, p[:len(p)-4])
+	for it.Next() {
+	}
+	if it.Err() == nil {
+		t.Error("Expected an error for a truncated message")
+	}
+
+	// newer objects read as defaults
+	buf.Reset()
+	err = schemaV0.EncodeSlice([]interface{}{"bike", 3.5}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it.Reset(&schemaV2, buf.Bytes())
+	if !it.Next() || !it.Next() {
+		t.Fatal(it.Err())
+	}
+	if !it.Next() {
+		t.Fatal("Expected a default for 'charge'")
+	}
+	if i, err := it.Int(); err != nil || i != -1 {
+		t.Errorf("Expected default -1; got %d (%v)", i, err)
+	}
+}
+
+func TestIteratorAllocs(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := iterSchema.EncodeSlice(iterValues, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := buf.Bytes()
+	it := NewIterator(&iterSchema, p)
+	allocs := testing.AllocsPerRun(100, func() {
+		it.Reset(&iterSchema, p)
+		for it.Next() {
+			switch it.Type() {
+			case String:
+				it.StringZeroCopy()
+			case Float:
+				it.Float()
+			case Bin:
+				it.Bytes()
+			case Ext:
+				it.Ext()
+			case Time:
+				it.Time()
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Expected 0 allocations; got %v", allocs)
+	}
+}
+
+func BenchmarkIterator(b *testing.B) {
+	b.ReportAllocs()
+	names := []string{"float", "int", "uint", "string", "bin"}
+	values := make([]interface{}, len(names))
+	values[0] = float64(3.589)
+	values[1] = int64(-2000)
+	values[2] = uint64(586)
+	values[3] = "here's a string"
+	values[4] = []byte{3, 4, 5, 8}
+
+	s, err := MakeSchema(names, values)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Grow(40)
+	s.EncodeSlice(values, buf)
+	b.SetBytes(int64(len(buf.Bytes())))
+	bts := buf.Bytes()
+	it := NewIterator(s, bts)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		it.Reset(s, bts)
+		for it.Next() {
+			switch it.Type() {
+			case Float:
+				it.Float()
+			case Int:
+				it.Int()
+			case Uint:
+				it.Uint()
+			case String:
+				it.StringZeroCopy()
+			case Bin:
+				it.Bytes()
+			}
+		}
+	}
+}
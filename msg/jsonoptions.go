@@ -0,0 +1,143 @@
+package msg
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"math"
+	"strconv"
+)
+
+// BinFormat is the JSON rendering of Bin values.
+type BinFormat uint8
+
+const (
+	// BinBase64 writes Bin values as
+	// base64 strings (the default).
+	BinBase64 BinFormat = iota
+	// BinHex writes Bin values as
+	// lower-case hexadecimal strings.
+	BinHex
+	// BinString writes Bin values as strings of the bytes
+	// themselves (see JSONOptions.Strings for invalid UTF-8).
+	BinString
+)
+
+// NonFinite is the JSON rendering of NaN and infinite floats.
+type NonFinite uint8
+
+const (
+	// NonFiniteRaw writes NaN, +Inf and -Inf unquoted (the
+	// default). The output is not valid JSON, but some
+	// parsers accept it.
+	NonFiniteRaw NonFinite = iota
+	// NonFiniteNull writes null.
+	NonFiniteNull
+	// NonFiniteString writes "NaN", "+Inf" and "-Inf".
+	NonFiniteString
+)
+
+// maxSafeInt is the largest integer n such that a float64
+// (e.g. a JavaScript number) holds every integer up to n
+// exactly (JavaScript's Number.MAX_SAFE_INTEGER)
+const maxSafeInt = 1<<53 - 1
+
+// JSONOptions control how Schema.WriteJSONWith renders
+// values. The zero value renders values the way
+// Schema.WriteJSON does.
+type JSONOptions struct {
+	// Strings are the flags used to escape
+	// strings and keys (see AppendJSONString).
+	Strings JSONFlags
+
+	// Bin is the rendering of Bin values.
+	Bin BinFormat
+
+	// FloatFormat and FloatPrecision are the 'fmt' and 'prec'
+	// arguments to strconv.AppendFloat (e.g. 'e' and 3 for
+	// "3.589e+00"). If FloatFormat is 0, floats are written
+	// in 'f' format with the fewest digits that represent
+	// them exactly, and FloatPrecision is ignored.
+	FloatFormat    byte
+	FloatPrecision int
+
+	// NonFinite is the rendering of NaN and infinite floats.
+	NonFinite NonFinite
+
+	// BigIntsAsStrings writes Int and Uint values whose magnitude
+	// is 2^53 or greater as strings, since a JavaScript number
+	// that large may not be the integer that was written
+	// (2^53+1 is read as 2^53).
+	BigIntsAsStrings bool
+
+	// Ext renders the Data of Ext values by extension type. Each
+	// function must append exactly one JSON value to 'b'. Ext values
+	// of other types are written as {"extension_type":<int8>,"data":<base64 string>}.
+	Ext map[int8]func(b []byte, data []byte) ([]byte, error)
+}
+
+// defaultJSON is used by WriteJSON
+var defaultJSON JSONOptions
+
+// WriteJSONWith writes the message 'p' as a JSON object
+// like WriteJSON, but renders values according to 'opt'.
+// If 'opt' is nil, WriteJSONWith is the same as WriteJSON.
+func (s *Schema) WriteJSONWith(p []byte, w Writer, opt *JSONOptions) error {
+	if opt == nil {
+		opt = &defaultJSON
+	}
+	varray := [64]byte{}
+	_, err := s.writeJSON(p, w, varray[0:0], opt)
+	return s.explain(p, err)
+}
+
+// appendFloat appends 'f' as a JSON value
+func (opt *JSONOptions) appendFloat(b []byte, f float64) []byte {
+	if opt.NonFinite != NonFiniteRaw && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		if opt.NonFinite == NonFiniteNull {
+			return append(b, null...)
+		}
+		b = append(b, qte)
+		b = strconv.AppendFloat(b, f, 'f', -1, 64)
+		return append(b, qte)
+	}
+	if opt.FloatFormat == 0 {
+		return strconv.AppendFloat(b, f, 'f', -1, 64)
+	}
+	return strconv.AppendFloat(b, f, opt.FloatFormat, opt.FloatPrecision, 64)
+}
+
+// appendInt appends 'i' as a JSON value
+func (opt *JSONOptions) appendInt(b []byte, i int64) []byte {
+	if opt.BigIntsAsStrings && (i > maxSafeInt || i < -maxSafeInt) {
+		b = append(b, qte)
+		b = strconv.AppendInt(b, i, 10)
+		return append(b, qte)
+	}
+	return strconv.AppendInt(b, i, 10)
+}
+
+// appendUint appends 'u' as a JSON value
+func (opt *JSONOptions) appendUint(b []byte, u uint64) []byte {
+	if opt.BigIntsAsStrings && u > maxSafeInt {
+		b = append(b, qte)
+		b = strconv.AppendUint(b, u, 10)
+		return append(b, qte)
+	}
+	return strconv.AppendUint(b, u, 10)
+}
+
+// appendBin appends 'dat' as a JSON value
+func (opt *JSONOptions) appendBin(b []byte, dat []byte) ([]byte, error) {
+	switch opt.Bin {
+	case BinHex:
+		b = append(b, qte)
+		b = append(b, hex.EncodeToString(dat)...)
+		return append(b, qte), nil
+	case BinString:
+		return AppendJSONString(b, string(dat), opt.Strings)
+	default:
+		b = append(b, qte)
+		b = append(b, base64.StdEncoding.EncodeToString(dat)...)
+		return append(b, qte), nil
+	}
+}
@@ -0,0 +1,140 @@
+package msg
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWriteJSONWith(t *testing.T) {
+	// the zero value is WriteJSON
+	for i, c := range conformCases() {
+		s := Schema{c.o, {Name: "end", T: String}}
+		p, err := s.AppendSlice(nil, []interface{}{c.v, "end"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := bytes.NewBuffer(nil)
+		s.WriteJSON(p, want)
+		for _, opt := range []*JSONOptions{nil, {}} {
+			got := bytes.NewBuffer(nil)
+			err = s.WriteJSONWith(p, got, opt)
+			if err != nil || !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Errorf("case %d: %s/%s: got %s; want %s: %v", i, c.o.Name, c.o.T, got.Bytes(), want.Bytes(), err)
+			}
+		}
+	}
+
+	s := Schema{
+		{Name: "bin", T: Bin},
+		{Name: "float", T: Float},
+		{Name: "uint", T: Uint},
+		{Name: "int", T: Int},
+		{Name: "ext", T: Ext},
+		{Name: "<str>", T: String},
+		{Name: "new", T: Float, Since: 1, Default: math.Inf(-1)},
+	}
+	msg := func(f float64) []byte {
+		p, err := s.AppendSlice(nil, []interface{}{
+			[]byte("hi\xff"), f, uint64(1<<53 + 1), int64(-1 << 60),
+			&PackExt{EType: 9, Data: []byte{1, 2}}, "&", math.NaN(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	unix := func(b []byte, data []byte) ([]byte, error) {
+		return strconv.AppendInt(b, int64(data[0])<<8|int64(data[1]), 10), nil
+	}
+	// a message from before "new"
+	old := msg(3.75)
+	old = old[:len(old)-len(AppendFloat(nil, math.NaN()))]
+
+	tests := []struct {
+		opt JSONOptions
+		p   []byte
+		out string
+	}{
+		{
+			JSONOptions{},
+			msg(3.75),
+			`{"bin":"aGn/","float":3.75,"uint":9007199254740993,"int":-1152921504606846976,` +
+				`"ext":{"extension_type":9,"data":"AQI="},"<str>":"&","new":NaN}`,
+		},
+		{
+			JSONOptions{Bin: BinHex, FloatFormat: 'e', FloatPrecision: 2, BigIntsAsStrings: true},
+			msg(3.75),
+			`{"bin":"6869ff","float":3.75e+00,"uint":"9007199254740993","int":"-1152921504606846976",` +
+				`"ext":{"extension_type":9,"data":"AQI="},"<str>":"&","new":NaN}`,
+		},
+		{
+			JSONOptions{Bin: BinString, Strings: JSONEscapeHTML, NonFinite: NonFiniteString, Ext: map[int8]func([]byte, []byte) ([]byte, error){9: unix}},
+			msg(math.Inf(1)),
+			`{"bin":"hi\ufffd","float":"+Inf","uint":9007199254740993,"int":-1152921504606846976,` +
+				`"ext":258,"\u003cstr\u003e":"\u0026","new":"NaN"}`,
+		},
+		{
+			JSONOptions{NonFinite: NonFiniteNull, FloatFormat: 'f', FloatPrecision: 1},
+			old,
+			`{"bin":"aGn/","float":3.8,"uint":9007199254740993,"int":-1152921504606846976,` +
+				`"ext":{"extension_type":9,"data":"AQI="},"<str>":"&","new":null}`,
+		},
+	}
+	for i, tt := range tests {
+		pl, err := s.Compile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, write := range map[string]func([]byte, Writer, *JSONOptions) error{
+			"Schema": s.WriteJSONWith,
+			"Plan":   pl.WriteJSONWith,
+		} {
+			buf := bytes.NewBuffer(nil)
+			err := write(tt.p, buf, &tt.opt)
+			if err != nil || buf.String() != tt.out {
+				t.Errorf("Test case %d: %s:\nexpected %s\ngot      %s: %v", i, name, tt.out, buf.Bytes(), err)
+			}
+		}
+	}
+
+	// big ints start at 2^53
+	big := &JSONOptions{BigIntsAsStrings: true}
+	for _, tt := range []struct {
+		i   int64
+		out string
+	}{
+		{1<<53 - 1, "9007199254740991"},
+		{-(1<<53 - 1), "-9007199254740991"},
+		{1 << 53, `"9007199254740992"`},
+		{-1 << 53, `"-9007199254740992"`},
+	} {
+		if got := string(big.appendInt(nil, tt.i)); got != tt.out {
+			t.Errorf("appendInt(%d): expected %s; got %s", tt.i, tt.out, got)
+		}
+		if tt.i < 0 {
+			continue
+		}
+		if got := string(big.appendUint(nil, uint64(tt.i))); got != tt.out {
+			t.Errorf("appendUint(%d): expected %s; got %s", tt.i, tt.out, got)
+		}
+	}
+
+	// strict UTF-8 is an error
+	buf := bytes.NewBuffer(nil)
+	err := s.WriteJSONWith(msg(1), buf, &JSONOptions{Bin: BinString, Strings: JSONStrictUTF8})
+	if err != ErrInvalidUTF8 {
+		t.Errorf("Expected ErrInvalidUTF8; got %v", err)
+	}
+
+	// times are unaffected
+	ts := Schema{{Name: "t", T: Time}}
+	p, _ := ts.AppendSlice(nil, []interface{}{time.Unix(1, 0).UTC()})
+	buf.Reset()
+	ts.WriteJSONWith(p, buf, &JSONOptions{FloatFormat: 'e'})
+	if buf.String() != `{"t":"1970-01-01T00:00:01Z"}` {
+		t.Errorf("Bad time: %s", buf.Bytes())
+	}
+}
@@ -0,0 +1,91 @@
+package msg
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned by AppendJSONString when
+// JSONStrictUTF8 is set and a string is not valid UTF-8.
+var ErrInvalidUTF8 = errors.New("String is not valid UTF-8.")
+
+// JSONFlags control how AppendJSONString escapes strings.
+type JSONFlags uint8
+
+const (
+	// JSONEscapeHTML escapes '<', '>' and '&' as \u003c,
+	// \u003e and \u0026, so that the output can be
+	// embedded in HTML.
+	JSONEscapeHTML JSONFlags = 1 << iota
+
+	// JSONStrictUTF8 makes invalid UTF-8 an error (ErrInvalidUTF8).
+	// Otherwise, each invalid byte is replaced with U+FFFD.
+	JSONStrictUTF8
+)
+
+const hexDigits = "0123456789abcdef"
+
+// jsonSafe[c] is whether or not the ASCII
+// character 'c' can be written unescaped
+var jsonSafe = func() (t [utf8.RuneSelf]bool) {
+	for c := range t {
+		t[c] = c >= 0x20 && c != '"' && c != '\\'
+	}
+	return
+}()
+
+// AppendJSONString appends 's' to 'b' as a quoted JSON string,
+// escaped per RFC 8259. Control characters are written as \n, \r,
+// \t or \u00XX, and U+2028 and U+2029 are escaped so that the output
+// is also valid JavaScript. Other characters are written as-is.
+// The handling of HTML characters and invalid UTF-8 depends on 'f'.
+func AppendJSONString(b []byte, s string, f JSONFlags) ([]byte, error) {
+	b = append(b, qte)
+	start := 0 // s[start:i] is pending
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if jsonSafe[c] && (f&JSONEscapeHTML == 0 || (c != '<' && c != '>' && c != '&')) {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			if f&JSONStrictUTF8 != 0 {
+				return b, ErrInvalidUTF8
+			}
+			b = append(b, s[start:i]...)
+			b = append(b, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, qte), nil
+}
@@ -0,0 +1,121 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAppendJSONString(t *testing.T) {
+	tests := []struct {
+		in  string
+		f   JSONFlags
+		out string
+	}{
+		{"", 0, `""`},
+		{"plain", 0, `"plain"`},
+		{"quote\" backslash\\", 0, `"quote\" backslash\\"`},
+		{"\n\r\t", 0, `"\n\r\t"`},
+		{"\x00\x01\x1f\x7f", 0, `"\u0000\u0001\u001f` + "\x7f" + `"`},
+		{"\U0001F600 \u00e9", 0, "\"\U0001F600 \u00e9\""},
+		{"\u2028\u2029", 0, `"\u2028\u2029"`},
+		{"<a&b>", 0, `"<a&b>"`},
+		{"<a&b>", JSONEscapeHTML, `"\u003ca\u0026b\u003e"`},
+		{"bad\xffutf8\xc3", 0, `"bad\ufffdutf8\ufffd"`},
+		{"bad\xff", JSONStrictUTF8, ""},
+	}
+	for i, tt := range tests {
+		out, err := AppendJSONString(nil, tt.in, tt.f)
+		if tt.out == "" {
+			if err != ErrInvalidUTF8 {
+				t.Errorf("Test case %d: expected ErrInvalidUTF8; got %v", i, err)
+			}
+			continue
+		}
+		if err != nil || string(out) != tt.out {
+			t.Errorf("Test case %d: expected %s; got %s: %v", i, tt.out, out, err)
+		}
+	}
+}
+
+func TestWriteJSONEscaping(t *testing.T) {
+	s := Schema{
+		{Name: "na\"me\n", T: String},
+		{Name: "tags", T: Map, Elem: String},
+	}
+	v := []interface{}{"\x00\U0001F600\xff", map[string]interface{}{"</script>": "\u2028"}}
+	p, err := s.AppendSlice(nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, pl := range map[string]func([]byte, Writer) error{
+		"Schema": s.WriteJSON,
+		"Plan": func(p []byte, w Writer) error {
+			pl, err := s.Compile()
+			if err != nil {
+				return err
+			}
+			return pl.WriteJSON(p, w)
+		},
+	} {
+		buf := bytes.NewBuffer(nil)
+		err = pl(p, buf)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		var m map[string]interface{}
+		err = json.Unmarshal(buf.Bytes(), &m)
+		if err != nil {
+			t.Fatalf("%s: %s: %s", name, buf.Bytes(), err)
+		}
+		if m["na\"me\n"] != "\x00\U0001F600\ufffd" {
+			t.Errorf("%s: bad string: %q", name, m["na\"me\n"])
+		}
+		if tags, _ := m["tags"].(map[string]interface{}); tags["</script>"] != "\u2028" {
+			t.Errorf("%s: bad map: %v", name, m["tags"])
+		}
+	}
+}
+
+func FuzzAppendJSONString(f *testing.F) {
+	f.Add("")
+	f.Add("a string")
+	f.Add("\"\\/\b\f\n\r\t\x00\x1f\x7f")
+	f.Add("<script>&amp;</script>")
+	f.Add("\u2028\u2029\U0001F600\ufffd")
+	f.Add("\xff\xc3\xed\xa0\x80")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, fl := range []JSONFlags{0, JSONEscapeHTML, JSONStrictUTF8} {
+			out, err := AppendJSONString(nil, s, fl)
+			if fl&JSONStrictUTF8 != 0 && !utf8.ValidString(s) {
+				if err != ErrInvalidUTF8 {
+					t.Fatalf("flags %d: expected ErrInvalidUTF8; got %v", fl, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("flags %d: %s", fl, err)
+			}
+			if !json.Valid(out) {
+				t.Fatalf("flags %d: invalid JSON %q", fl, out)
+			}
+
+			// encoding/json replaces invalid UTF-8 the same way
+			var got, want string
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatalf("flags %d: %q: %s", fl, out, err)
+			}
+			std, _ := json.Marshal(s)
+			json.Unmarshal(std, &want)
+			if got != want {
+				t.Fatalf("flags %d: %q decodes to %q; want %q", fl, out, got, want)
+			}
+			if fl&JSONEscapeHTML != 0 && strings.ContainsAny(string(out), "<>&") {
+				t.Fatalf("flags %d: unescaped HTML in %q", fl, out)
+			}
+		}
+	})
+}
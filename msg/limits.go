@@ -0,0 +1,141 @@
+package msg
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrTooLarge is returned when a value in a message
+// is larger or more deeply nested than the Limits allow.
+var ErrTooLarge = errors.New("Value exceeds decoding limits.")
+
+// Limits bounds the values that are read from messages, so
+// that a malformed or hostile message cannot make a reader
+// allocate arbitrarily large amounts of memory or recurse
+// arbitrarily deeply. The sizes apply to the lengths in
+// length-prefixed encodings (str8/16/32, bin8/16/32, ext8/16/32,
+// array16/32 and map16/32); the fixed-size encodings are
+// always small. A zero field means no limit.
+type Limits struct {
+	MaxString uint32 // bytes in a string
+	MaxBin    uint32 // bytes in a bin
+	MaxExt    uint32 // bytes of data in an extension
+	MaxArray  uint32 // elements in an array, or Objects in an encoded Schema
+	MaxMap    uint32 // key-value pairs in a map
+	MaxDepth  int    // nested arrays and maps, or nested Structs in an encoded Schema
+}
+
+// DefaultLimits are the Limits in effect until SetLimits is called.
+var DefaultLimits = Limits{
+	MaxString: 1 << 24,
+	MaxBin:    1 << 24,
+	MaxExt:    1 << 24,
+	MaxArray:  1 << 20,
+	MaxMap:    1 << 20,
+	MaxDepth:  64,
+}
+
+// limits holds a *Limits, so that SetLimits
+// can race with decoding safely
+var limits atomic.Value
+
+func init() { SetLimits(DefaultLimits) }
+
+// SetLimits sets the Limits honored by the Read functions,
+// Skip and SkipBytes, and the methods of Schema and Iterator.
+// SetLimits may be called concurrently with decoding; values
+// that are being read when it is called may be checked against
+// either the old or the new Limits.
+func SetLimits(l Limits) { limits.Store(&l) }
+
+// CurrentLimits returns the Limits in effect.
+func CurrentLimits() Limits { return *currentLimits() }
+
+// currentLimits returns the Limits in effect
+// without copying them
+func currentLimits() *Limits { return limits.Load().(*Limits) }
+
+// maxPrealloc is the most elements that are allocated
+// before they are read from a Reader, since a header
+// can claim far more elements than follow it
+const maxPrealloc = 1 << 10
+
+// prealloc returns the number of elements to
+// allocate for an array or map of 'n' elements
+func prealloc(n uint32) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return int(n)
+}
+
+// maxPreread is the most bytes that are allocated
+// for a string, bin or ext before they are read from a
+// Reader, for the same reason
+const maxPreread = 1 << 16
+
+// readSized reads 'n' bytes from 'r' into 'p' if it has
+// room for them, or else into a new slice that grows as
+// bytes are read, so that a length that claims far more
+// bytes than follow it costs at most maxPreread up front
+func readSized(r Reader, p []byte, n uint32) ([]byte, error) {
+	if p != nil && cap(p) >= int(n) {
+		p = p[:n]
+		return p, readFull(r, p)
+	}
+	c := int(n)
+	if c > maxPreread {
+		c = maxPreread
+	}
+	p = make([]byte, 0, c)
+	for len(p) < int(n) {
+		if len(p) == cap(p) {
+			// let append pick the next capacity
+			p = append(p, 0)[:len(p)]
+		}
+		end := cap(p)
+		if end > int(n) {
+			end = int(n)
+		}
+		if err := readFull(r, p[len(p):end]); err != nil {
+			return nil, err
+		}
+		p = p[:end]
+	}
+	return p, nil
+}
+
+// tooLarge returns whether or not 'n' exceeds 'max'
+func tooLarge(n uint32, max uint32) bool { return max > 0 && n > max }
+
+// checkSize returns ErrTooLarge if the size 'n' read
+// after the length-prefixed tag 'c' exceeds the Limits
+func checkSize(c byte, n uint32) error {
+	var max uint32
+	l := currentLimits()
+	switch c {
+	case mstr8, mstr16, mstr32:
+		max = l.MaxString
+	case mbin8, mbin16, mbin32:
+		max = l.MaxBin
+	case mext8, mext16, mext32:
+		max = l.MaxExt
+	case marray16, marray32:
+		max = l.MaxArray
+	case mmap16, mmap32:
+		max = l.MaxMap
+	}
+	if tooLarge(n, max) {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// checkDepth returns ErrTooLarge if
+// 'depth' exceeds the Limits
+func checkDepth(depth int) error {
+	if max := currentLimits().MaxDepth; max > 0 && depth > max {
+		return ErrTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package msg
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// fuzzLimits are small enough that the fuzz
+// target can check the memory it allocates
+var fuzzLimits = Limits{
+	MaxString: 1 << 12,
+	MaxBin:    1 << 12,
+	MaxExt:    1 << 12,
+	MaxArray:  1 << 10,
+	MaxMap:    1 << 10,
+	MaxDepth:  16,
+}
+
+func TestLimits(t *testing.T) {
+	defer SetLimits(CurrentLimits())
+	SetLimits(Limits{MaxString: 8, MaxBin: 8, MaxExt: 8, MaxArray: 8, MaxMap: 8, MaxDepth: 2})
+
+	// a header claiming 4GB followed by a single byte
+	huge := func(tag byte) []byte { return []byte{tag, 0xff, 0xff, 0xff, 0xff, 0x01} }
+
+	var err error
+	_, _, err = ReadStringZeroCopy(huge(mstr32))
+	checkTooLarge(t, "ReadStringZeroCopy", err)
+	_, err = ReadString(bytes.NewReader(huge(mstr32)))
+	checkTooLarge(t, "ReadString", err)
+	_, _, err = ReadBinZeroCopy(huge(mbin32))
+	checkTooLarge(t, "ReadBinZeroCopy", err)
+	_, err = ReadBin(bytes.NewReader(huge(mbin32)), nil)
+	checkTooLarge(t, "ReadBin", err)
+	_, _, _, err = ReadExtZeroCopy(huge(mext32))
+	checkTooLarge(t, "ReadExtZeroCopy", err)
+	_, err = ReadExt(bytes.NewReader(huge(mext32)), nil)
+	checkTooLarge(t, "ReadExt", err)
+	_, _, err = ReadArrayHeaderBytes(huge(marray32))
+	checkTooLarge(t, "ReadArrayHeaderBytes", err)
+	_, err = ReadArrayHeader(bytes.NewReader(huge(marray32)))
+	checkTooLarge(t, "ReadArrayHeader", err)
+	_, _, err = ReadMapHeaderBytes(huge(mmap32))
+	checkTooLarge(t, "ReadMapHeaderBytes", err)
+	_, err = ReadMapHeader(bytes.NewReader(huge(mmap32)))
+	checkTooLarge(t, "ReadMapHeader", err)
+	_, err = SkipBytes(huge(mstr32))
+	checkTooLarge(t, "SkipBytes", err)
+	err = Skip(bytes.NewReader(huge(mbin32)))
+	checkTooLarge(t, "Skip", err)
+
+	// values at the limit are fine
+	buf := bytes.NewBuffer(nil)
+	WriteString(buf, "12345678")
+	_, err = ReadString(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Errorf("Unexpected error at the limit: %s", err)
+	}
+
+	// nesting
+	deep := []byte{0x91, 0x91, 0x91, 0x01}
+	_, err = SkipBytes(deep[1:])
+	if err != nil {
+		t.Errorf("Unexpected error at the depth limit: %s", err)
+	}
+	_, err = SkipBytes(deep)
+	checkTooLarge(t, "SkipBytes (nested)", err)
+	err = Skip(bytes.NewReader(deep))
+	checkTooLarge(t, "Skip (nested)", err)
+
+	// schema methods
+	s := Schema{{Name: "tags", T: Array, Elem: String}}
+	v := make([]interface{}, 1)
+	err = s.DecodeToSlice(bytes.NewReader(huge(marray32)), v)
+	checkTooLarge(t, "DecodeToSlice", err)
+	err = s.DecodeToSliceZeroCopy(huge(marray32), v)
+	checkTooLarge(t, "DecodeToSliceZeroCopy", err)
+	err = s.WriteJSON(huge(marray32), bytes.NewBuffer(nil))
+	checkTooLarge(t, "WriteJSON", err)
+	verr, ok := s.Validate(huge(marray32)).(*ValidationError)
+	if !ok || verr.Err != ErrTooLarge {
+		t.Errorf("Validate: expected ErrTooLarge; got %v", verr)
+	}
+
+	// encoded schemas
+	buf.Reset()
+	WriteInt(buf, 1<<20)
+	err = new(Schema).Decode(buf)
+	checkTooLarge(t, "Schema.Decode", err)
+	nested := Schema{{Name: "a", T: Struct, Schema: &Schema{{Name: "b", T: Struct, Schema: &Schema{{Name: "c", T: Struct, Schema: &Schema{}}}}}}}
+	buf.Reset()
+	nested.Encode(buf)
+	err = new(Schema).Decode(buf)
+	checkTooLarge(t, "Schema.Decode (nested)", err)
+}
+
+func TestPreallocation(t *testing.T) {
+	defer SetLimits(CurrentLimits())
+	SetLimits(DefaultLimits)
+
+	// the most elements the Limits allow, with none following
+	objects := AppendInt(nil, int64(DefaultLimits.MaxArray))
+	array := AppendArrayHeader(nil, DefaultLimits.MaxArray)
+	kvs := AppendMapHeader(nil, DefaultLimits.MaxMap)
+	as := Schema{{Name: "a", T: Array, Elem: String}}
+	ms := Schema{{Name: "m", T: Map, Elem: String}}
+	v := make([]interface{}, 1)
+
+	// the longest values the Limits allow, with no data following
+	str := []byte{mstr32, 0x01, 0, 0, 0}
+	bin := []byte{mbin32, 0x01, 0, 0, 0}
+	ext := []byte{mext32, 0x01, 0, 0, 0, 5}
+	ss := Schema{{Name: "s", T: String}}
+	bs := Schema{{Name: "b", T: Bin}}
+
+	for _, c := range []struct {
+		name string
+		read func() error
+	}{
+		{"Schema.Decode", func() error { return new(Schema).Decode(bytes.NewReader(objects)) }},
+		{"DecodeToSlice (array)", func() error { return as.DecodeToSlice(bytes.NewReader(array), v) }},
+		{"DecodeToSliceZeroCopy (array)", func() error { return as.DecodeToSliceZeroCopy(array, v) }},
+		{"DecodeToSlice (map)", func() error { return ms.DecodeToSlice(bytes.NewReader(kvs), v) }},
+		{"DecodeToSliceZeroCopy (map)", func() error { return ms.DecodeToSliceZeroCopy(kvs, v) }},
+		{"ReadString", func() error { _, err := ReadString(bytes.NewReader(str)); return err }},
+		{"ReadBin", func() error { _, err := ReadBin(bytes.NewReader(bin), nil); return err }},
+		{"ReadExt", func() error { _, err := ReadExt(bytes.NewReader(ext), nil); return err }},
+		{"ReadInterface (string)", func() error { _, _, err := ReadInterface(bytes.NewReader(str)); return err }},
+		{"DecodeToSlice (string)", func() error { return ss.DecodeToSlice(bytes.NewReader(str), v) }},
+		{"DecodeToSlice (bin)", func() error { return bs.DecodeToSlice(bytes.NewReader(bin), v) }},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := c.read()
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<18 {
+			t.Errorf("%s: allocated %d bytes for a header", c.name, n)
+		}
+	}
+
+	err := new(Schema).Decode(bytes.NewReader(AppendInt(nil, -1)))
+	if err != ErrBadValue {
+		t.Errorf("Schema.Decode: expected ErrBadValue for a negative length; got %v", err)
+	}
+}
+
+func TestReadSized(t *testing.T) {
+	for _, n := range []int{0, 1, maxPreread - 1, maxPreread, maxPreread + 1, 3*maxPreread + 7} {
+		dat := make([]byte, n)
+		for i := range dat {
+			dat[i] = byte(i)
+		}
+		p := AppendBin(nil, dat)
+		out, err := ReadBin(bytes.NewReader(p), nil)
+		if err != nil || !bytes.Equal(out, dat) {
+			t.Errorf("ReadBin (%d bytes): got %d bytes (%v)", n, len(out), err)
+		}
+		s, err := ReadString(bytes.NewReader(AppendString(nil, string(dat))))
+		if err != nil || s != string(dat) {
+			t.Errorf("ReadString (%d bytes): got %d bytes (%v)", n, len(s), err)
+		}
+		if n == 0 {
+			continue
+		}
+		_, err = ReadBin(bytes.NewReader(p[:len(p)-1]), nil)
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadBin (%d bytes, truncated): expected io.ErrUnexpectedEOF; got %v", n, err)
+		}
+	}
+}
+
+// run with -race
+func TestSetLimitsConcurrently(t *testing.T) {
+	defer SetLimits(CurrentLimits())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetLimits(fuzzLimits)
+		}
+	}()
+	p, _ := iterSchema.AppendSlice(nil, iterValues)
+	for i := 0; i < 100; i++ {
+		readAll(p)
+	}
+	<-done
+}
+
+func checkTooLarge(t *testing.T, name string, err error) {
+	if err != ErrTooLarge {
+		t.Errorf("%s: expected ErrTooLarge; got %v", name, err)
+	}
+}
+
+// readAll runs 'p' through every read path
+func readAll(p []byte) {
+	SkipBytes(p)
+	Skip(bytes.NewReader(p))
+	r := bytes.NewReader(p)
+	for {
+		if _, _, err := ReadInterface(r); err != nil {
+			break
+		}
+	}
+
+	iterSchema.Validate(p)
+	v := make([]interface{}, len(iterSchema))
+	iterSchema.DecodeToSlice(bytes.NewReader(p), v)
+	iterSchema.DecodeToSliceZeroCopy(p, v)
+	iterSchema.DecodeToMap(bytes.NewReader(p), make(map[string]interface{}))
+	buf := bytes.NewBuffer(nil)
+	iterSchema.WriteJSON(p, buf)
+	buf.Reset()
+	iterSchema.ToMsgpackMap(p, buf)
+	buf.Reset()
+	iterSchema.FromMsgpackMap(p, buf)
+	it := NewIterator(&iterSchema, p)
+	for it.Next() {
+	}
+
+	// a schema, followed by a message of that schema
+	var s Schema
+	r = bytes.NewReader(p)
+	if s.Decode(r) == nil {
+		rest := p[len(p)-r.Len():]
+		s.Validate(rest)
+		s.DecodeToSliceZeroCopy(rest, make([]interface{}, len(s)))
+		s.DecodeToMap(bytes.NewReader(rest), make(map[string]interface{}))
+		s.WriteJSON(rest, buf)
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	defer SetLimits(CurrentLimits())
+	SetLimits(fuzzLimits)
+
+	p, _ := iterSchema.AppendSlice(nil, iterValues)
+	f.Add(p)
+	buf := bytes.NewBuffer(nil)
+	iterSchema.ToMsgpackMap(p, buf)
+	f.Add(buf.Bytes())
+	buf = bytes.NewBuffer(nil)
+	iterSchema.Encode(buf)
+	f.Add(append(buf.Bytes(), p...))
+	f.Add([]byte{mstr32, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{marray32, 0xff, 0xff, 0xff, 0xff})
+	f.Add(bytes.Repeat([]byte{0x91}, 1000))
+
+	f.Fuzz(func(t *testing.T, p []byte) {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		readAll(p)
+		runtime.ReadMemStats(&after)
+		// generous, but far below what a bad length could cost
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<23+uint64(len(p))*64 {
+			t.Errorf("Allocated %d bytes for a %d-byte message", n, len(p))
+		}
+	})
+}
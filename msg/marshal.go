@@ -0,0 +1,440 @@
+package msg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotStruct is returned when Marshal, Unmarshal, or StructSchema
+	// are handed something other than a struct (or pointer-to-struct).
+	ErrNotStruct = errors.New("Value is not a struct or pointer to struct.")
+	// ErrOverflow is returned by Unmarshal when a decoded value
+	// does not fit in the width of the destination field.
+	ErrOverflow = errors.New("Value overflows destination field.")
+)
+
+var (
+	packExtType    = reflect.TypeOf(PackExt{})
+	packExtPtrType = reflect.TypeOf(&PackExt{})
+	binType        = reflect.TypeOf([]byte(nil))
+	timeType       = reflect.TypeOf(time.Time{})
+
+	fieldCache = struct {
+		sync.RWMutex
+		m map[reflect.Type][]field
+	}{m: make(map[reflect.Type][]field)}
+)
+
+// field is the reflected representation of
+// one struct field that participates in encoding
+type field struct {
+	name  string  // flux (Schema) name
+	gname string  // Go field name
+	index int     // struct field index
+	t     Type    // msg.Type
+	width int     // 0 for compacted floats; 32 or 64 for fixed-width floats
+	sub   []field // fields of a nested struct
+}
+
+// MismatchError is returned by (*Schema).Verify when
+// a struct field does not line up with its Schema counterpart.
+type MismatchError struct {
+	// Index is the position of the mismatch in the Schema
+	Index int
+	// Field is the name of the Go struct field, or "" if the struct has too few fields
+	Field string
+	// Expected is the Object in the Schema, or the zero Object if the Schema is too short
+	Expected Object
+	// Found is the Object derived from the struct field
+	Found Object
+}
+
+func (m *MismatchError) Error() string {
+	switch {
+	case m.Field == "":
+		return fmt.Sprintf("msg: struct has no field for Schema object %d (%q)", m.Index, m.Expected.Name)
+	case m.Expected.Name == "":
+		return fmt.Sprintf("msg: struct field %s has no Schema object at index %d", m.Field, m.Index)
+	default:
+		return fmt.Sprintf("msg: struct field %s (%q, type %s) does not match Schema object %d (%q, type %s)",
+			m.Field, m.Found.Name, m.Found.T, m.Index, m.Expected.Name, m.Expected.T)
+	}
+}
+
+// Marshal encodes the exported fields of a struct (or pointer-to-struct)
+// in declaration order, producing exactly the same bytes as the equivalent
+// sequence of WriteXxx() calls.
+//
+// Fields map to msg.Types as follows:
+//
+//   - string - msg.String
+//   - bool - msg.Bool
+//   - int, int8, int16, int32, int64 - msg.Int
+//   - uint, uint8, uint16, uint32, uint64 - msg.Uint
+//   - float32, float64 - msg.Float
+//   - []byte - msg.Bin
+//   - msg.PackExt, *msg.PackExt - msg.Ext
+//   - time.Time - msg.Time
+//   - other structs - msg.Struct
+//
+// The field tag `flux:"name"` sets the Schema name of the field (defaults to
+// the lower-cased field name), and `flux:"-"` skips the field. The options
+// ",float32" and ",float64" force a float to be written with WriteFloat32 or WriteFloat64,
+// respectively; otherwise float64 fields use WriteFloat and float32 fields use WriteFloat32.
+func Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := MarshalTo(buf, v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalTo is identical to Marshal, except that it writes to a msg.Writer.
+func MarshalTo(w Writer, v interface{}) error {
+	rv, err := structValue(v)
+	if err != nil {
+		return err
+	}
+	fs, err := structFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		err = marshalField(w, rv.Field(f.index), f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unmarshal decodes 'p' into the struct pointed to by 'v',
+// using the same field rules as Marshal. Strings and
+// binary data are copied out of 'p'. Unmarshal returns
+// ErrOverflow if a value does not fit in a narrower field.
+func Unmarshal(p []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrNotStruct
+	}
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	fs, err := structFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	var nr int
+	var n int
+	for _, f := range fs {
+		n, err = unmarshalField(p[nr:], rv.Field(f.index), f)
+		if err != nil {
+			return err
+		}
+		nr += n
+	}
+	return nil
+}
+
+// StructSchema returns the Schema that describes the encoding
+// Marshal uses for the struct (or pointer-to-struct) 'v'.
+func StructSchema(v interface{}) (*Schema, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := structFields(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	return fieldSchema(fs), nil
+}
+
+func fieldSchema(fs []field) *Schema {
+	o := make([]Object, len(fs))
+	for i, f := range fs {
+		o[i] = Object{Name: f.name, T: f.t}
+		if f.t == Struct {
+			o[i].Schema = fieldSchema(f.sub)
+		}
+	}
+	s := Schema(o)
+	return &s
+}
+
+// Verify checks that the struct (or pointer-to-struct) 'v' encodes
+// with the same names and types as *s. It returns a *MismatchError
+// describing the first field that does not match.
+func (s *Schema) Verify(v interface{}) error {
+	ss, err := StructSchema(v)
+	if err != nil {
+		return err
+	}
+	rv, _ := structValue(v)
+	fs, _ := structFields(rv.Type())
+
+	for i, o := range *ss {
+		if i >= len(*s) {
+			return &MismatchError{Index: i, Field: fs[i].gname, Found: o}
+		}
+		if !reflect.DeepEqual((*s)[i], o) {
+			return &MismatchError{Index: i, Field: fs[i].gname, Expected: (*s)[i], Found: o}
+		}
+	}
+	if len(*s) > len(*ss) {
+		return &MismatchError{Index: len(*ss), Expected: (*s)[len(*ss)]}
+	}
+	return nil
+}
+
+// dereference 'v' down to a struct value
+func structValue(v interface{}) (rv reflect.Value, err error) {
+	rv = reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			err = ErrNotStruct
+			return
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		err = ErrNotStruct
+	}
+	return
+}
+
+// get (possibly cached) fields for a struct type
+func structFields(t reflect.Type) ([]field, error) {
+	fieldCache.RLock()
+	fs, ok := fieldCache.m[t]
+	fieldCache.RUnlock()
+	if ok {
+		return fs, nil
+	}
+
+	fs = make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		// unexported
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("flux")
+		if tag == "-" {
+			continue
+		}
+		f := field{gname: sf.Name, index: i}
+		opts := strings.Split(tag, ",")
+		f.name = opts[0]
+		if f.name == "" {
+			f.name = strings.ToLower(sf.Name)
+		}
+
+		switch sf.Type.Kind() {
+		case reflect.String:
+			f.t = String
+		case reflect.Bool:
+			f.t = Bool
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f.t = Int
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f.t = Uint
+		case reflect.Float32:
+			f.t = Float
+			f.width = 32
+		case reflect.Float64:
+			f.t = Float
+		case reflect.Slice:
+			if sf.Type != binType {
+				return nil, ErrTypeNotSupported
+			}
+			f.t = Bin
+		case reflect.Struct:
+			if sf.Type == packExtType {
+				f.t = Ext
+				break
+			}
+			if sf.Type == timeType {
+				f.t = Time
+				break
+			}
+			sub, err := structFields(sf.Type)
+			if err != nil {
+				return nil, err
+			}
+			f.t = Struct
+			f.sub = sub
+		default:
+			if sf.Type != packExtPtrType {
+				return nil, ErrTypeNotSupported
+			}
+			f.t = Ext
+		}
+
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "float32":
+				f.width = 32
+			case "float64":
+				f.width = 64
+			default:
+				return nil, ErrBadArgs
+			}
+			if f.t != Float {
+				return nil, ErrBadArgs
+			}
+		}
+		fs = append(fs, f)
+	}
+
+	fieldCache.Lock()
+	fieldCache.m[t] = fs
+	fieldCache.Unlock()
+	return fs, nil
+}
+
+func marshalField(w Writer, v reflect.Value, f field) error {
+	switch f.t {
+	case String:
+		writeString(w, v.String())
+	case Bool:
+		writeBool(w, v.Bool())
+	case Int:
+		writeInt(w, v.Int())
+	case Uint:
+		writeUint(w, v.Uint())
+	case Float:
+		switch f.width {
+		case 32:
+			writeFloat32(w, float32(v.Float()))
+		case 64:
+			writeFloat64(w, v.Float())
+		default:
+			writeFloat(w, v.Float())
+		}
+	case Bin:
+		writeBin(w, v.Bytes())
+	case Ext:
+		var ext PackExt
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return ErrIncorrectType
+			}
+			ext = *(v.Interface().(*PackExt))
+		} else {
+			ext = v.Interface().(PackExt)
+		}
+		writeExt(w, ext.EType, ext.Data)
+	case Time:
+		writeTime(w, v.Interface().(time.Time))
+	case Struct:
+		for _, sf := range f.sub {
+			err := marshalField(w, v.Field(sf.index), sf)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrTypeNotSupported
+	}
+	return nil
+}
+
+func unmarshalField(p []byte, v reflect.Value, f field) (n int, err error) {
+	switch f.t {
+	case String:
+		var s string
+		s, n, err = readStringZeroCopy(p)
+		if err != nil {
+			return
+		}
+		v.SetString(string([]byte(s)))
+	case Bool:
+		var b bool
+		b, n, err = readBoolBytes(p)
+		if err != nil {
+			return
+		}
+		v.SetBool(b)
+	case Int:
+		var i int64
+		i, n, err = readIntBytes(p)
+		if err != nil {
+			return
+		}
+		if v.OverflowInt(i) {
+			err = ErrOverflow
+			return
+		}
+		v.SetInt(i)
+	case Uint:
+		var u uint64
+		u, n, err = readUintBytes(p)
+		if err != nil {
+			return
+		}
+		if v.OverflowUint(u) {
+			err = ErrOverflow
+			return
+		}
+		v.SetUint(u)
+	case Float:
+		var fl float64
+		fl, n, err = readFloatBytes(p)
+		if err != nil {
+			return
+		}
+		if v.OverflowFloat(fl) {
+			err = ErrOverflow
+			return
+		}
+		v.SetFloat(fl)
+	case Bin:
+		var dat []byte
+		dat, n, err = readBinZeroCopy(p)
+		if err != nil {
+			return
+		}
+		v.SetBytes(append(v.Bytes()[0:0], dat...))
+	case Ext:
+		var dat []byte
+		var etype int8
+		dat, etype, n, err = readExtZeroCopy(p)
+		if err != nil {
+			return
+		}
+		ext := &PackExt{EType: etype, Data: append([]byte(nil), dat...)}
+		if v.Kind() == reflect.Ptr {
+			v.Set(reflect.ValueOf(ext))
+		} else {
+			v.Set(reflect.ValueOf(*ext))
+		}
+	case Time:
+		var t time.Time
+		t, n, err = readTimeBytes(p)
+		if err != nil {
+			return
+		}
+		v.Set(reflect.ValueOf(t))
+	case Struct:
+		var sn int
+		for _, sf := range f.sub {
+			sn, err = unmarshalField(p[n:], v.Field(sf.index), sf)
+			if err != nil {
+				return
+			}
+			n += sn
+		}
+	default:
+		err = ErrTypeNotSupported
+	}
+	return
+}
@@ -0,0 +1,229 @@
+package msg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type tele struct {
+	Name   string
+	Dir    string  `flux:"direction"`
+	Val    float64 `flux:"val,float64"`
+	Uid    uint64
+	Chrg   int64
+	Small  int8
+	Ratio  float32
+	Data   []byte
+	Ext    *PackExt
+	Ignore string `flux:"-"`
+	hidden int
+}
+
+// hand-written equivalent of Marshal(tele)
+func (t *tele) Encode(w Writer) error {
+	WriteString(w, t.Name)
+	WriteString(w, t.Dir)
+	WriteFloat64(w, t.Val)
+	WriteUint(w, t.Uid)
+	WriteInt(w, t.Chrg)
+	WriteInt(w, int64(t.Small))
+	WriteFloat32(w, t.Ratio)
+	WriteBin(w, t.Data)
+	WriteExt(w, t.Ext.EType, t.Ext.Data)
+	return nil
+}
+
+func testTele() *tele {
+	return &tele{
+		Name:   "ERROR",
+		Dir:    "/bin",
+		Val:    1.388,
+		Uid:    67890,
+		Chrg:   -1,
+		Small:  -100,
+		Ratio:  0.5,
+		Data:   []byte{1, 2, 3},
+		Ext:    &PackExt{EType: 3, Data: []byte{9, 8, 7, 6}},
+		Ignore: "not encoded",
+	}
+}
+
+func TestMarshalMatchesWriters(t *testing.T) {
+	tl := testTele()
+	bts, err := Marshal(tl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	tl.Encode(buf)
+	if !bytes.Equal(bts, buf.Bytes()) {
+		t.Errorf("Marshal wrote %x; hand-written encoding is %x", bts, buf.Bytes())
+	}
+
+	// struct values should work, too
+	bts, err = Marshal(*tl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bts, buf.Bytes()) {
+		t.Errorf("Marshal wrote %x; hand-written encoding is %x", bts, buf.Bytes())
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tl := testTele()
+	bts, err := Marshal(tl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(tele)
+	err = Unmarshal(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Ignore = ""
+	if !reflect.DeepEqual(tl, out) {
+		t.Errorf("Expected %#v; got %#v", tl, out)
+	}
+
+	// results must not alias the input
+	bts[len(bts)-1]++
+	if out.Ext.Data[3] != 6 {
+		t.Error("Unmarshal retained a reference to its input")
+	}
+}
+
+func TestUnmarshalOverflow(t *testing.T) {
+	type small struct{ V int8 }
+	bts, err := Marshal(struct{ V int64 }{V: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Unmarshal(bts, new(small))
+	if err != ErrOverflow {
+		t.Errorf("Expected ErrOverflow; got %v", err)
+	}
+}
+
+func TestStructSchema(t *testing.T) {
+	s, err := StructSchema(testTele())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Schema{
+		{Name: "name", T: String},
+		{Name: "direction", T: String},
+		{Name: "val", T: Float},
+		{Name: "uid", T: Uint},
+		{Name: "chrg", T: Int},
+		{Name: "small", T: Int},
+		{Name: "ratio", T: Float},
+		{Name: "data", T: Bin},
+		{Name: "ext", T: Ext},
+	}
+	if !reflect.DeepEqual(*s, expect) {
+		t.Errorf("Expected %v; got %v", expect, *s)
+	}
+
+	_, err = StructSchema(struct{ M map[string]int }{})
+	if err != ErrTypeNotSupported {
+		t.Errorf("Expected ErrTypeNotSupported; got %v", err)
+	}
+	_, err = StructSchema(3)
+	if err != ErrNotStruct {
+		t.Errorf("Expected ErrNotStruct; got %v", err)
+	}
+}
+
+func TestSchemaVerify(t *testing.T) {
+	type person struct {
+		Name string
+		Age  int64
+	}
+	s := Schema{{Name: "name", T: String}, {Name: "age", T: Int}}
+	if err := s.Verify(person{}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	s[1].T = Uint
+	err := s.Verify(person{})
+	merr, ok := err.(*MismatchError)
+	if !ok {
+		t.Fatalf("Expected *MismatchError; got %v", err)
+	}
+	if merr.Index != 1 || merr.Field != "Age" {
+		t.Errorf("Wrong mismatch: %s", merr)
+	}
+	if !strings.Contains(merr.Error(), "type uint)") {
+		t.Errorf("Expected the type name in %q", merr)
+	}
+
+	s = append(s, Object{Name: "weight", T: Float})
+	s[1].T = Int
+	err = s.Verify(&person{})
+	merr, ok = err.(*MismatchError)
+	if !ok {
+		t.Fatalf("Expected *MismatchError; got %v", err)
+	}
+	if merr.Index != 2 || merr.Field != "" {
+		t.Errorf("Wrong mismatch: %s", merr)
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	b.ReportAllocs()
+	tl := testTele()
+	buf := bytes.NewBuffer(nil)
+	MarshalTo(buf, tl)
+	b.SetBytes(int64(buf.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		MarshalTo(buf, tl)
+	}
+}
+
+func TestMarshalNested(t *testing.T) {
+	type location struct {
+		Lat float64
+		Lon float64
+	}
+	type station struct {
+		Name string
+		Loc  location `flux:"location"`
+		ID   uint16
+	}
+	in := station{Name: "main st", Loc: location{Lat: 42.5, Lon: -83.75}, ID: 4}
+	bts, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(station)
+	err = Unmarshal(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *out != in {
+		t.Errorf("Expected %v; got %v", in, *out)
+	}
+
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "location", T: Struct, Schema: &Schema{{Name: "lat", T: Float}, {Name: "lon", T: Float}}},
+		{Name: "id", T: Uint},
+	}
+	err = s.Verify(in)
+	if err != nil {
+		t.Error(err)
+	}
+	m := make(map[string]interface{})
+	err = s.DecodeToMap(bytes.NewReader(bts), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["location"].(map[string]interface{})["lon"] != float64(-83.75) {
+		t.Errorf("Bad nested map: %v", m)
+	}
+}
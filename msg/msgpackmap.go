@@ -0,0 +1,312 @@
+package msg
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// MapError is returned by FromMsgpackMap when
+// a map does not match the Schema.
+type MapError struct {
+	// Key is the key of the value, including the keys
+	// of any enclosing maps (e.g. "location.lat")
+	Key string
+	// Reason describes the error
+	Reason string
+}
+
+func (m *MapError) Error() string {
+	return fmt.Sprintf("msg: map key %q: %s", m.Key, m.Reason)
+}
+
+// ToMsgpackMap writes the message in 'p' to 'w' as a standard
+// MessagePack map, with each value keyed by the Name of its Object.
+// Values are copied as-is, except that Struct values are written as
+// nested maps, and Int values (including the elements of Arrays and
+// Maps) are re-encoded in standard form, since earlier versions of
+// this package wrote 128 through 255 as int8 (0xd0), which standard
+// MessagePack reads as a negative number. Objects that are newer than
+// the message (see Object.Since) are written with their Default values.
+func (s *Schema) ToMsgpackMap(p []byte, w Writer) error {
+	_, err := s.toMsgpackMap(p, w)
+	return s.explain(p, err)
+}
+
+// toMsgpackMap implements ToMsgpackMap, and
+// also returns the number of bytes read from 'p'.
+func (s *Schema) toMsgpackMap(p []byte, w Writer) (int, error) {
+	var nn int
+	writeMapHeader(w, uint32(len(*s)))
+	for _, o := range *s {
+		writeString(w, o.Name)
+
+		// older messages end before newer objects
+		if o.Since > 0 && nn >= len(p) {
+			buf := bytes.NewBuffer(nil)
+			o.encodeDefault(buf)
+			_, err := toMsgpackValue(buf.Bytes(), o.defaultObject(), w)
+			if err != nil {
+				return nn, err
+			}
+			continue
+		}
+
+		n, err := toMsgpackValue(p[nn:], o, w)
+		if err != nil {
+			return nn, err
+		}
+		nn += n
+	}
+	return nn, nil
+}
+
+// toMsgpackValue writes the leading value in 'p'
+// and returns the number of bytes read
+func toMsgpackValue(p []byte, o Object, w Writer) (int, error) {
+	if o.Optional && IsNil(p) {
+		writeNil(w)
+		return 1, nil
+	}
+	if o.T == Struct {
+		return o.sub().toMsgpackMap(p, w)
+	}
+	return copyValue(p, o, w, true)
+}
+
+// copyValue copies the leading value in 'p' to 'w' and returns
+// the number of bytes read. If 'std' is true, Int values (and the
+// Int elements of Arrays and Maps) are read in this package's form
+// and written in standard MessagePack form; otherwise, Int and Uint
+// values are read in standard form and written in this package's form.
+func copyValue(p []byte, o Object, w Writer, std bool) (int, error) {
+	var b [9]byte
+	switch {
+	case o.T == Int && std:
+		i, n, err := readIntBytes(p)
+		if err != nil {
+			return 0, err
+		}
+		w.Write(appendStdInt(b[:0], i))
+		return n, nil
+	case o.T == Int:
+		i, n, err := readStdInt(p)
+		if err != nil {
+			return 0, err
+		}
+		w.Write(AppendInt(b[:0], i))
+		return n, nil
+	case o.T == Uint && !std:
+		u, n, err := readStdUint(p)
+		if err != nil {
+			return 0, err
+		}
+		w.Write(AppendUint(b[:0], u))
+		return n, nil
+	case (o.T == Array || o.T == Map) && (o.Elem == Int || (o.Elem == Uint && !std)):
+		return copyElems(p, o, w, std)
+	}
+	_, n, err := readValueZeroCopy(p, o)
+	if err != nil {
+		return 0, err
+	}
+	w.Write(p[:n])
+	return n, nil
+}
+
+// copyElems is copyValue for Arrays and Maps
+func copyElems(p []byte, o Object, w Writer, std bool) (int, error) {
+	var sz uint32
+	var n int
+	var err error
+	if o.T == Array {
+		sz, n, err = readArrayHeaderBytes(p)
+	} else {
+		sz, n, err = readMapHeaderBytes(p)
+	}
+	if err != nil {
+		return 0, err
+	}
+	w.Write(p[:n])
+	eo := Object{T: o.Elem}
+	for i := uint32(0); i < sz; i++ {
+		if o.T == Map {
+			_, kn, err := readStringZeroCopy(p[n:])
+			if err != nil {
+				return n, err
+			}
+			w.Write(p[n : n+kn])
+			n += kn
+		}
+		en, err := copyValue(p[n:], eo, w, std)
+		if err != nil {
+			return n, err
+		}
+		n += en
+	}
+	return n, nil
+}
+
+// readStdInt reads an int64 in standard MessagePack form,
+// in which int8 (0xd0) is signed, and unsigned tags may be
+// used for positive values
+func readStdInt(p []byte) (int64, int, error) {
+	if len(p) == 0 {
+		return 0, 0, ErrShortBytes
+	}
+	switch p[0] {
+	case mint8:
+		if len(p) < 2 {
+			return 0, 0, ErrShortBytes
+		}
+		return int64(int8(p[1])), 2, nil
+	case muint8, muint16, muint32, muint64:
+		u, n, err := readUintBytes(p)
+		if err == nil && u > math.MaxInt64 {
+			err = ErrOverflow
+		}
+		return int64(u), n, err
+	default:
+		return readIntBytes(p)
+	}
+}
+
+// readStdUint reads a uint64 in standard MessagePack
+// form, in which signed tags may be used
+func readStdUint(p []byte) (uint64, int, error) {
+	if len(p) == 0 {
+		return 0, 0, ErrShortBytes
+	}
+	switch c := p[0]; {
+	case c == mint8 || c == mint16 || c == mint32 || c == mint64 || c >= mnfixint:
+		i, n, err := readStdInt(p)
+		if err == nil && i < 0 {
+			err = ErrOverflow
+		}
+		return uint64(i), n, err
+	default:
+		return readUintBytes(p)
+	}
+}
+
+// appendStdInt appends an int64 in the smallest
+// standard MessagePack encoding
+func appendStdInt(b []byte, v int64) []byte {
+	switch {
+	case v >= -32 && v <= math.MaxInt8:
+		return append(b, byte(v))
+	case v >= math.MinInt8 && v < 0:
+		return append(b, mint8, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return append(b, mint16, byte(v>>8), byte(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return appendUint32(append(b, mint32), uint32(v))
+	default:
+		return appendUint64(append(b, mint64), uint64(v))
+	}
+}
+
+// FromMsgpackMap writes the standard MessagePack map in 'p' to 'w' as a
+// message, with each value taken from the key that matches the Name of
+// its Object. Keys may be in any order, and Struct values must be nested
+// maps. A missing key is written as the Default of its Object (see
+// Object.Since), or as nil if the Object is Optional. Int and Uint values
+// may use any standard integer encoding that fits (int8 is signed), and are
+// re-encoded in this package's form. Missing, repeated, and unknown keys,
+// and values of the wrong type or out of range, are returned as a *MapError,
+// and nothing is written to 'w'.
+func (s *Schema) FromMsgpackMap(p []byte, w Writer) error {
+	buf := bytes.NewBuffer(nil)
+	_, err := s.fromMsgpackMap(p, buf, "")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// fromMsgpackMap implements FromMsgpackMap, and also
+// returns the number of bytes read from 'p'. 'prefix'
+// is prepended to keys in errors.
+func (s *Schema) fromMsgpackMap(p []byte, w Writer, prefix string) (int, error) {
+	sz, nn, err := readMapHeaderBytes(p)
+	if err != nil {
+		return nn, err
+	}
+
+	// the encoded value for each object, in order
+	vals := make([][]byte, len(*s))
+	for i := uint32(0); i < sz; i++ {
+		key, n, err := readStringZeroCopy(p[nn:])
+		if err != nil {
+			return nn, err
+		}
+		nn += n
+		idx := s.index(key)
+		if idx < 0 {
+			return nn, &MapError{Key: prefix + key, Reason: "not in schema"}
+		}
+		if vals[idx] != nil {
+			return nn, &MapError{Key: prefix + key, Reason: "repeated"}
+		}
+		o := (*s)[idx]
+		if o.T == Struct && !(o.Optional && IsNil(p[nn:])) {
+			sub := bytes.NewBuffer(nil)
+			n, err = o.sub().fromMsgpackMap(p[nn:], sub, prefix+key+".")
+			vals[idx] = append([]byte{}, sub.Bytes()...)
+		} else if o.Optional && IsNil(p[nn:]) {
+			n = 1
+			vals[idx] = p[nn : nn+n]
+		} else {
+			val := bytes.NewBuffer(nil)
+			n, err = copyValue(p[nn:], o, val, false)
+			vals[idx] = val.Bytes()
+		}
+		switch err {
+		case ErrBadTag:
+			return nn, &MapError{Key: prefix + key, Reason: "expected " + o.typeName()}
+		case ErrOverflow:
+			return nn, &MapError{Key: prefix + key, Reason: "out of range for " + o.typeName()}
+		}
+		if err != nil {
+			return nn, err
+		}
+		nn += n
+	}
+
+	for i, o := range *s {
+		if vals[i] != nil {
+			w.Write(vals[i])
+			continue
+		}
+		switch {
+		case o.Default != nil:
+			o.encodeDefault(w)
+		case o.Optional:
+			writeNil(w)
+		default:
+			return nn, &MapError{Key: prefix + o.Name, Reason: "missing"}
+		}
+	}
+	return nn, nil
+}
+
+// index returns the index of the Object
+// named 'name', or -1 if there isn't one
+func (s *Schema) index(name string) int {
+	for i := range *s {
+		if (*s)[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// readValueZeroCopy reads one value of 'o' from 'p'
+func readValueZeroCopy(p []byte, o Object) (v interface{}, n int, err error) {
+	var a [1]interface{}
+	o.Since = 0
+	one := Schema{o}
+	n, err = one.decodeToSliceZeroCopy(p, a[:])
+	return a[0], n, err
+}
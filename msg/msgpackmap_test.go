@@ -0,0 +1,170 @@
+package msg
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMsgpackMap(t *testing.T) {
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "val", T: Float},
+		{Name: "tags", T: Array, Elem: String, Optional: true},
+		{Name: "ext", T: Ext},
+		{Name: "seen", T: Time},
+		{Name: "location", T: Struct, Schema: &Schema{{Name: "lat", T: Float}, {Name: "lon", T: Float}}},
+		{Name: "charge", T: Int, Since: 1, Default: int64(-1)},
+	}
+	in := []interface{}{
+		"bike", 3.5, nil, &PackExt{EType: 4, Data: []byte("ext")},
+		time.Unix(1414000000, 0).UTC(), []interface{}{1.5, -2.5}, int64(8),
+	}
+	buf := bytes.NewBuffer(nil)
+	err := s.EncodeSlice(in, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := buf.Bytes()
+
+	mbuf := bytes.NewBuffer(nil)
+	err = s.ToMsgpackMap(expect, mbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the map is ordinary MessagePack
+	r := bytes.NewReader(mbuf.Bytes())
+	sz, err := ReadMapHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sz != uint32(len(s)) {
+		t.Fatalf("Expected %d keys; got %d", len(s), sz)
+	}
+	m := make(map[string]interface{})
+	for i := uint32(0); i < sz; i++ {
+		k, err := ReadString(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k == "tags" {
+			err = ReadNil(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m[k] = nil
+			continue
+		}
+		if k == "location" {
+			ReadMapHeader(r)
+			ReadString(r)
+			m["location.lat"], _, _ = ReadInterface(r)
+			ReadString(r)
+			m["location.lon"], _, _ = ReadInterface(r)
+			continue
+		}
+		m[k], _, err = ReadInterface(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m["name"] != "bike" || m["val"] != 3.5 || m["tags"] != nil || m["location.lon"] != -2.5 || m["charge"] != int64(8) {
+		t.Errorf("Bad map %v", m)
+	}
+
+	// map -> message is lossless
+	out := bytes.NewBuffer(nil)
+	err = s.FromMsgpackMap(mbuf.Bytes(), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), expect) {
+		t.Errorf("Expected %x; got %x", expect, out.Bytes())
+	}
+
+	// keys may be in any order, and missing
+	// keys use defaults and nil
+	mbuf.Reset()
+	WriteMapHeader(mbuf, 2)
+	WriteString(mbuf, "val")
+	WriteFloat(mbuf, 1.0)
+	WriteString(mbuf, "name")
+	WriteString(mbuf, "bike")
+	sv := Schema{s[0], s[1], s[2], s[6]}
+	out.Reset()
+	err = sv.FromMsgpackMap(mbuf.Bytes(), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := make([]interface{}, 4)
+	err = sv.DecodeToSliceZeroCopy(out.Bytes(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, []interface{}{"bike", 1.0, nil, int64(-1)}) {
+		t.Errorf("Bad values %v", v)
+	}
+
+	// older messages get defaults
+	mbuf.Reset()
+	err = sv.ToMsgpackMap(out.Bytes()[:len(out.Bytes())-1], mbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = out.Bytes()
+	out = bytes.NewBuffer(nil)
+	err = sv.FromMsgpackMap(mbuf.Bytes(), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), expect) {
+		t.Errorf("Expected %x; got %x", expect, out.Bytes())
+	}
+
+	tests := []struct {
+		keys []string
+		key  string
+	}{
+		{[]string{"val"}, "name"},
+		{[]string{"name", "val", "color"}, "color"},
+		{[]string{"name", "name"}, "name"},
+	}
+	for i, tt := range tests {
+		mbuf.Reset()
+		WriteMapHeader(mbuf, uint32(len(tt.keys)))
+		for _, k := range tt.keys {
+			WriteString(mbuf, k)
+			if k == "val" {
+				WriteFloat(mbuf, 1.0)
+			} else {
+				WriteString(mbuf, "x")
+			}
+		}
+		out.Reset()
+		err = sv.FromMsgpackMap(mbuf.Bytes(), out)
+		merr, ok := err.(*MapError)
+		if !ok || merr.Key != tt.key {
+			t.Errorf("Test case %d: expected an error for key %q; got %v", i, tt.key, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("Test case %d: wrote %d bytes", i, out.Len())
+		}
+	}
+
+	// mistyped nested value
+	mbuf.Reset()
+	WriteMapHeader(mbuf, 1)
+	WriteString(mbuf, "location")
+	WriteMapHeader(mbuf, 2)
+	WriteString(mbuf, "lon")
+	WriteFloat(mbuf, 1.0)
+	WriteString(mbuf, "lat")
+	WriteString(mbuf, "north")
+	so := Schema{s[5]}
+	err = so.FromMsgpackMap(mbuf.Bytes(), out)
+	if merr, ok := err.(*MapError); !ok || merr.Key != "location.lat" {
+		t.Errorf("Expected an error for key \"location.lat\"; got %v", err)
+	}
+}
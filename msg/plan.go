@@ -0,0 +1,379 @@
+package msg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Plan is a compiled Schema. Its methods behave like the
+// methods of the same name on the Schema it was compiled from,
+// but the work that depends only on the Schema (quoting JSON keys,
+// choosing a decoder for each Object, and formatting Defaults) is
+// done once, by Compile, rather than once per message. That work
+// dominates WriteJSON (see BenchmarkPlanWriteJSON). Compile also
+// finds runs of fixed-width objects (Int, Uint, Float and Bool
+// objects that are neither Optional nor versioned), which
+// DecodeToSlice slices directly out of a *bytes.Reader or
+// *bytes.Buffer instead of reading them one value at a time (see
+// BenchmarkPlanDecodeToSliceFixed). A Plan is immutable, so it is
+// safe for concurrent use.
+type Plan struct {
+	s  Schema
+	fs []planField
+}
+
+// planField is the compiled form of one Object
+type planField struct {
+	o    *Object    // in Plan.s
+	key  []byte     // "name": (preceded by a comma after the first field)
+	json jsonWriter // writes a value as JSON
+	read reader     // reads a value
+	sub  *Plan      // for Structs
+	def  []byte     // the Default as JSON, for newer objects
+
+	run   int // the number of fields in the run starting here, if it is the first
+	width int // the greatest encoded size of that run
+}
+
+// reader reads a value of Type o.T from 'r'
+type reader func(r Reader, o *Object) (interface{}, error)
+
+// maxRun is the greatest number of fields in a run; the
+// widest fixed-width value is 9 bytes (e.g. an int64)
+const (
+	maxRun      = 8
+	maxRunWidth = maxRun * 9
+)
+
+// Compile returns the Plan for the Schema. The Plan has its own
+// copy of the Schema, including nested Schemas, so later changes
+// to the Schema do not affect it; Default values are not copied,
+// so they must not be modified. Compile returns an
+// error if an Object has a Type that cannot be decoded, or
+// a Default that does not match its Type.
+func (s *Schema) Compile() (*Plan, error) {
+	pl := &Plan{s: make(Schema, len(*s)), fs: make([]planField, len(*s))}
+	copy(pl.s, *s)
+	var err error
+	for i := range pl.s {
+		o := &pl.s[i]
+		f := &pl.fs[i]
+		f.o = o
+		if i > 0 {
+			f.key = append(f.key, comma)
+		}
+		f.key, _ = AppendJSONString(f.key, o.Name, 0)
+		f.key = append(f.key, colon)
+
+		switch o.checkElem() {
+		case nil:
+		case ErrNestedVersion:
+			return nil, fmt.Errorf("msg: object %q: nested object %q has a version", o.Name, nestedVersion(o))
+		default:
+			return nil, fmt.Errorf("msg: object %q: cannot decode %s", o.Name, o.typeName())
+		}
+		switch o.T {
+		case Struct:
+			f.sub, err = o.sub().Compile()
+			if err != nil {
+				return nil, err
+			}
+			o.Schema = &f.sub.s
+		}
+		f.json = jsonWriterFor(o.T)
+		f.read = readerFor(o.T)
+		if f.read == nil && o.T != Struct {
+			return nil, fmt.Errorf("msg: object %q has unknown type %s", o.Name, o.T)
+		}
+
+		if o.Since > 0 {
+			buf := bytes.NewBuffer(nil)
+			err = o.writeJSONDefault(buf, nil, &defaultJSON)
+			if err != nil {
+				return nil, fmt.Errorf("msg: object %q has a bad default: %s", o.Name, err)
+			}
+			f.def = buf.Bytes()
+		}
+	}
+	pl.findRuns()
+	return pl, nil
+}
+
+// findRuns finds the runs of two or more consecutive
+// fixed-width fields (at most maxRun at a time)
+func (pl *Plan) findRuns() {
+	for i := 0; i < len(pl.fs); {
+		var w int
+		j := i
+		for ; j < len(pl.fs) && j-i < maxRun; j++ {
+			f := &pl.fs[j]
+			if f.o.Optional || f.o.Since > 0 {
+				break
+			}
+			fw := fixedWidth(f.o.T)
+			if fw == 0 {
+				break
+			}
+			w += fw
+		}
+		if j-i > 1 {
+			pl.fs[i].run = j - i
+			pl.fs[i].width = w
+		}
+		if j == i {
+			j++
+		}
+		i = j
+	}
+}
+
+// Schema returns a copy of the Schema that
+// the Plan was compiled from.
+func (pl *Plan) Schema() *Schema {
+	s := make(Schema, len(pl.s))
+	copy(s, pl.s)
+	for i := range s {
+		if pl.fs[i].sub != nil {
+			s[i].Schema = pl.fs[i].sub.Schema()
+		}
+	}
+	return &s
+}
+
+// NewIterator returns an Iterator over the message 'p'
+// that uses the Plan's copy of the Schema (see NewIterator).
+func (pl *Plan) NewIterator(p []byte) *Iterator { return NewIterator(&pl.s, p) }
+
+// WriteJSON writes the message 'p' as a JSON object
+// (see Schema.WriteJSON).
+func (pl *Plan) WriteJSON(p []byte, w Writer) error {
+	return pl.WriteJSONWith(p, w, nil)
+}
+
+// WriteJSONWith writes the message 'p' as a JSON object
+// (see Schema.WriteJSONWith).
+func (pl *Plan) WriteJSONWith(p []byte, w Writer, opt *JSONOptions) error {
+	if opt == nil {
+		opt = &defaultJSON
+	}
+	varray := [64]byte{}
+	_, err := pl.writeJSON(p, w, varray[0:0], opt)
+	return pl.s.explain(p, err)
+}
+
+// writeJSON implements WriteJSONWith, and also returns
+// the number of bytes read from 'p'. Keys and Defaults
+// are only precomputed for the default options.
+func (pl *Plan) writeJSON(p []byte, w Writer, empty []byte, opt *JSONOptions) (int, error) {
+	var nr, n int
+	var err error
+	w.WriteByte(lcurly)
+	for i := range pl.fs {
+		f := &pl.fs[i]
+		if opt.Strings == 0 {
+			w.Write(f.key)
+		} else {
+			if i > 0 {
+				w.WriteByte(comma)
+			}
+			key, err := AppendJSONString(empty, f.o.Name, opt.Strings)
+			if err != nil {
+				return nr, err
+			}
+			w.Write(append(key, colon))
+		}
+
+		// older messages end before newer objects
+		if f.def != nil && nr >= len(p) {
+			if opt == &defaultJSON {
+				w.Write(f.def)
+			} else if err = f.o.writeJSONDefault(w, empty, opt); err != nil {
+				return nr, err
+			}
+			continue
+		}
+		if f.o.Optional && IsNil(p[nr:]) {
+			w.Write(null)
+			nr++
+			continue
+		}
+		if f.sub != nil {
+			n, err = f.sub.writeJSON(p[nr:], w, empty, opt)
+		} else {
+			n, err = f.json(p[nr:], f.o, w, empty, opt)
+		}
+		if err != nil {
+			return nr, err
+		}
+		nr += n
+	}
+	return nr, w.WriteByte(rcurly)
+}
+
+// DecodeToSlice reads values from 'r' into 'v'
+// (see Schema.DecodeToSlice).
+func (pl *Plan) DecodeToSlice(r Reader, v []interface{}) error {
+	if len(v) < len(pl.fs) {
+		return ErrShortSlice
+	}
+	var err error
+	for i := 0; i < len(pl.fs); i++ {
+		f := &pl.fs[i]
+		o := f.o
+		if f.run > 0 {
+			if n := pl.decodeRun(r, i, v); n > 0 {
+				i += n - 1
+				continue
+			}
+		}
+
+		// older messages end before newer objects
+		if o.Since > 0 {
+			var eof bool
+			eof, err = atEOF(r)
+			if err != nil {
+				return err
+			}
+			if eof {
+				pl.s.defaultsToSlice(i, v)
+				return nil
+			}
+		}
+		if o.Optional {
+			var isnil bool
+			isnil, err = readIsNil(r)
+			if err != nil {
+				return err
+			}
+			if isnil {
+				v[i] = nil
+				continue
+			}
+		}
+		if f.sub != nil {
+			sv := make([]interface{}, len(f.sub.fs))
+			err = f.sub.DecodeToSlice(r, sv)
+			v[i] = sv
+		} else {
+			v[i], err = f.read(r, o)
+		}
+		if err != nil {
+			return readError(r, o.T, o.Name, err)
+		}
+	}
+	return nil
+}
+
+// decodeRun decodes the run of fixed-width fields that starts at
+// pl.fs[i] directly from the unread bytes of 'r', and returns the
+// number of fields decoded, or 0 if 'r' cannot expose its bytes or
+// the run is malformed (in which case the caller reads the fields
+// one at a time, and reports the error)
+func (pl *Plan) decodeRun(r Reader, i int, v []interface{}) int {
+	var scratch [maxRunWidth]byte
+	f := &pl.fs[i]
+	win := window(r, scratch[:f.width])
+	if win == nil {
+		return 0
+	}
+	var nr int
+	for j := i; j < i+f.run; j++ {
+		val, n, err := readFixed(win[nr:], pl.fs[j].o.T)
+		if err != nil {
+			return 0
+		}
+		v[j] = val
+		nr += n
+	}
+	advance(r, nr)
+	return f.run
+}
+
+// window returns up to len(scratch) unread bytes of 'r' without
+// consuming them (copying them into 'scratch' if necessary),
+// or nil if 'r' is not a *bytes.Reader or *bytes.Buffer
+func window(r Reader, scratch []byte) []byte {
+	switch r := r.(type) {
+	case *bytes.Reader:
+		n, _ := r.ReadAt(scratch, r.Size()-int64(r.Len()))
+		return scratch[:n]
+	case *bytes.Buffer:
+		p := r.Bytes()
+		if len(p) > len(scratch) {
+			p = p[:len(scratch)]
+		}
+		return p
+	default:
+		return nil
+	}
+}
+
+// advance consumes 'n' bytes of a window of 'r'
+func advance(r Reader, n int) {
+	switch r := r.(type) {
+	case *bytes.Reader:
+		r.Seek(int64(n), io.SeekCurrent)
+	case *bytes.Buffer:
+		r.Next(n)
+	}
+}
+
+// fixedWidth returns the greatest encoded size of
+// values of Type 't', or 0 if they are not fixed-width
+func fixedWidth(t Type) int {
+	switch t {
+	case Int, Uint, Float:
+		return 9
+	case Bool:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// readFixed reads a fixed-width value of Type 't' from 'p',
+// and returns the number of bytes read. (It is not a function
+// pointer, so that 'p' does not escape.)
+func readFixed(p []byte, t Type) (v interface{}, n int, err error) {
+	switch t {
+	case Int:
+		v, n, err = readIntBytes(p)
+	case Uint:
+		v, n, err = readUintBytes(p)
+	case Float:
+		v, n, err = readFloatBytes(p)
+	case Bool:
+		v, n, err = readBoolBytes(p)
+	default:
+		err = ErrTypeNotSupported
+	}
+	return
+}
+
+// readerFor returns the reader for values of Type 't',
+// or nil if there isn't one
+func readerFor(t Type) reader {
+	switch t {
+	case String:
+		return func(r Reader, o *Object) (interface{}, error) { return readString(r) }
+	case Int:
+		return func(r Reader, o *Object) (interface{}, error) { return readInt(r) }
+	case Uint:
+		return func(r Reader, o *Object) (interface{}, error) { return readUint(r) }
+	case Float:
+		return func(r Reader, o *Object) (interface{}, error) { return readFloat(r) }
+	case Bool:
+		return func(r Reader, o *Object) (interface{}, error) { return readBool(r) }
+	case Time:
+		return func(r Reader, o *Object) (interface{}, error) { return readTime(r) }
+	case Bin, Ext:
+		return func(r Reader, o *Object) (interface{}, error) { return readScalar(r, o.T) }
+	case Array:
+		return func(r Reader, o *Object) (interface{}, error) { return readArray(r, o.Elem) }
+	case Map:
+		return func(r Reader, o *Object) (interface{}, error) { return readMap(r, o.Elem) }
+	default:
+		return nil
+	}
+}
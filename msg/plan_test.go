@@ -0,0 +1,277 @@
+package msg
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	for i, c := range conformCases() {
+		s := Schema{c.o, {Name: "end", T: String}}
+		v := []interface{}{c.v, "end"}
+		pl, err := s.Compile()
+		if err != nil {
+			t.Errorf("case %d: %s/%s: Compile: %s", i, c.o.Name, c.o.T, err)
+			continue
+		}
+		p, err := s.AppendSlice(nil, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, got := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+		s.WriteJSON(p, want)
+		err = pl.WriteJSON(p, got)
+		if err != nil || !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("case %d: %s/%s: WriteJSON: got %s; want %s: %v", i, c.o.Name, c.o.T, got.Bytes(), want.Bytes(), err)
+		}
+
+		out := make([]interface{}, 2)
+		err = pl.DecodeToSlice(bytes.NewReader(p), out)
+		if err != nil || !reflect.DeepEqual(out, v) {
+			t.Errorf("case %d: %s/%s: DecodeToSlice: got %#v: %v", i, c.o.Name, c.o.T, out[0], err)
+		}
+	}
+
+	// later changes to the schema don't matter
+	s := Schema{{Name: "a", T: Int}}
+	pl, err := s.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s[0].Name = "b"
+	if name := (*pl.Schema())[0].Name; name != "a" {
+		t.Errorf("Expected the plan's object to be named \"a\"; got %q", name)
+	}
+	// ... including changes to nested Schemas, or to the Plan's copy
+	sub := Schema{{Name: "a", T: Int}}
+	s = Schema{{Name: "s", T: Struct, Schema: &sub}}
+	pl, err = s.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub[0].Name = "b"
+	(*(*pl.Schema())[0].Schema)[0].Name = "c"
+	if name := (*(*pl.Schema())[0].Schema)[0].Name; name != "a" {
+		t.Errorf("Expected the plan's nested object to be named \"a\"; got %q", name)
+	}
+	p, _ := s.AppendSlice(nil, []interface{}{[]interface{}{int64(1)}})
+	it := pl.NewIterator(p)
+	var sit Iterator
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	it.Struct(&sit)
+	if !sit.Next() || sit.Name() != "a" {
+		t.Errorf("Expected the plan's iterator to see \"a\"; got %q", sit.Name())
+	}
+
+	// defaults for old messages
+	s = Schema{
+		{Name: "first", T: String},
+		{Name: "second", T: Float, Since: 1, Default: 1.5},
+		{Name: "third", T: String, Since: 1, Optional: true},
+	}
+	pl, err = s.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := AppendString(nil, "first")
+	buf := bytes.NewBuffer(nil)
+	err = pl.WriteJSON(old, buf)
+	const js = `{"first":"first","second":1.5,"third":null}`
+	if err != nil || buf.String() != js {
+		t.Errorf("Expected %s; got %s: %v", js, buf.Bytes(), err)
+	}
+	out := make([]interface{}, 3)
+	err = pl.DecodeToSlice(bytes.NewReader(old), out)
+	if err != nil || !reflect.DeepEqual(out, []interface{}{"first", 1.5, nil}) {
+		t.Errorf("Bad defaults: %#v: %v", out, err)
+	}
+
+	// errors
+	bad := []Schema{
+		{{Name: "nested", T: Array, Elem: Array}},
+		{{Name: "structs", T: Map, Elem: Struct}},
+		{{Name: "unknown", T: Type(200)}},
+		{{Name: "s", T: Struct, Schema: &Schema{{Name: "unknown", T: Type(200)}}}},
+		{{Name: "default", T: Int, Since: 1, Default: "not an int"}},
+	}
+	for i, s := range bad {
+		if _, err := s.Compile(); err == nil {
+			t.Errorf("Bad schema %d: expected an error from Compile", i)
+		}
+	}
+
+	// decode errors have context
+	s = Schema{{Name: "name", T: String}, {Name: "loc", T: Struct, Schema: &Schema{{Name: "lat", T: Float}}}}
+	pl, err = s.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ = s.AppendSlice(nil, []interface{}{"bike", []interface{}{1.0}})
+	p[5] = 0xc3
+	if terr, ok := pl.WriteJSON(p, bytes.NewBuffer(nil)).(*TagError); !ok || terr.Field != "loc.lat" || terr.Offset != 5 {
+		t.Errorf("WriteJSON: bad *TagError: %#v", terr)
+	}
+	if terr, ok := pl.DecodeToSlice(bytes.NewReader(p), out).(*TagError); !ok || terr.Field != "loc.lat" || terr.Offset != 5 {
+		t.Errorf("DecodeToSlice: bad *TagError: %#v", terr)
+	}
+}
+
+func TestPlanFixedRuns(t *testing.T) {
+	// a run of 8, a run of 2, and a lone Int
+	s := Schema{
+		{Name: "i0", T: Int}, {Name: "i1", T: Int}, {Name: "i2", T: Int}, {Name: "i3", T: Int},
+		{Name: "u0", T: Uint}, {Name: "u1", T: Uint}, {Name: "f0", T: Float}, {Name: "f1", T: Float},
+		{Name: "b0", T: Bool}, {Name: "f2", T: Float},
+		{Name: "str", T: String},
+		{Name: "i4", T: Int},
+	}
+	pl, err := s.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.fs[0].run != 8 || pl.fs[8].run != 2 || pl.fs[11].run != 0 {
+		t.Errorf("Bad runs: %d, %d, %d", pl.fs[0].run, pl.fs[8].run, pl.fs[11].run)
+	}
+	v := []interface{}{
+		int64(-1), int64(200), int64(-1 << 20), int64(1 << 40),
+		uint64(255), uint64(1 << 63), 1.5, 3.75,
+		true, -0.25,
+		"str", int64(7),
+	}
+	p, err := s.AppendSlice(nil, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two messages in one stream
+	two := append(append([]byte{}, p...), p...)
+	readers := map[string]func([]byte) Reader{
+		"*bytes.Reader": func(p []byte) Reader { return bytes.NewReader(p) },
+		"*bytes.Buffer": func(p []byte) Reader { return bytes.NewBuffer(p) },
+	}
+	for name, newReader := range readers {
+		r := newReader(two)
+		for i := 0; i < 2; i++ {
+			out := make([]interface{}, len(s))
+			err = pl.DecodeToSlice(r, out)
+			if err != nil || !reflect.DeepEqual(out, v) {
+				t.Errorf("%s: message %d: got %v: %v", name, i, out, err)
+			}
+		}
+
+		// errors match Schema.DecodeToSlice
+		for i := 0; i < len(p); i++ {
+			for _, q := range [][]byte{p[:i], append(append([]byte{}, p[:i]...), append([]byte{0xc1}, p[i+1:]...)...)} {
+				want := make([]interface{}, len(s))
+				got := make([]interface{}, len(s))
+				werr := s.DecodeToSlice(newReader(q), want)
+				gerr := pl.DecodeToSlice(newReader(q), got)
+				if !reflect.DeepEqual(gerr, werr) {
+					t.Errorf("%s: %x: got %v; want %v", name, q, gerr, werr)
+				}
+			}
+		}
+	}
+}
+
+func benchSchema(b *testing.B) (Schema, []interface{}) {
+	names := []string{"float", "int", "uint", "string"}
+	values := []interface{}{float64(3.589), int64(-2000), uint64(586), "here's a string"}
+	s, err := MakeSchema(names, values)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return *s, values
+}
+
+// compare to BenchmarkReadFluxWriteJSON
+func BenchmarkPlanWriteJSON(b *testing.B) {
+	s, values := benchSchema(b)
+	pl, err := s.Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	bts, _ := s.AppendSlice(nil, values)
+	b.SetBytes(int64(len(bts)))
+	outbuf := bytes.NewBuffer(nil)
+	outbuf.Grow(256)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err = pl.WriteJSON(bts, outbuf)
+		if err != nil {
+			b.Fatal(err)
+		}
+		outbuf.Reset()
+	}
+}
+
+// compare to BenchmarkSchemaDecodeToSlice
+func BenchmarkPlanDecodeToSlice(b *testing.B) {
+	s, values := benchSchema(b)
+	s = append(s, Object{Name: "bin", T: Bin})
+	values = append(values, []byte{3, 4, 5, 8})
+	pl, err := s.Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	bts, _ := s.AppendSlice(nil, values)
+	b.SetBytes(int64(len(bts)))
+	m := make([]interface{}, len(s))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = pl.DecodeToSlice(bytes.NewReader(bts), m)
+	}
+}
+
+func benchFixed(b *testing.B) (Schema, []byte) {
+	s := Schema{
+		{Name: "lat", T: Float}, {Name: "lon", T: Float}, {Name: "alt", T: Float},
+		{Name: "id", T: Uint}, {Name: "seq", T: Int}, {Name: "ok", T: Bool},
+		{Name: "name", T: String},
+	}
+	bts, err := s.AppendSlice(nil, []interface{}{37.7749, -122.4194, 16.5, uint64(5860), int64(-2000), true, "bike"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s, bts
+}
+
+// compare to BenchmarkSchemaDecodeToSliceFixed
+func BenchmarkPlanDecodeToSliceFixed(b *testing.B) {
+	s, bts := benchFixed(b)
+	pl, err := s.Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(bts)))
+	m := make([]interface{}, len(s))
+	r := bytes.NewReader(bts)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(bts)
+		_ = pl.DecodeToSlice(r, m)
+	}
+}
+
+func BenchmarkSchemaDecodeToSliceFixed(b *testing.B) {
+	s, bts := benchFixed(b)
+	b.SetBytes(int64(len(bts)))
+	m := make([]interface{}, len(s))
+	r := bytes.NewReader(bts)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(bts)
+		_ = s.DecodeToSlice(r, m)
+	}
+}
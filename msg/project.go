@@ -0,0 +1,131 @@
+package msg
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Projection reads a subset of the Objects in the messages
+// of a Schema, skipping the rest without decoding them.
+// It is safe for concurrent use. (See Schema.Project.)
+type Projection struct {
+	s   *Schema
+	pos []int  // index in sub of each object in *s, or -1
+	sub Schema // projected objects, in the order they were named
+}
+
+// Project returns a Projection of the Objects named 'names'.
+// The Objects in the Projection's Schema are in the same order
+// as 'names', with no Since or Default, since Encode writes all
+// of them. It is an error to name an Object that is not in the
+// Schema, or to name an Object more than once.
+func (s *Schema) Project(names ...string) (*Projection, error) {
+	p := &Projection{
+		s:   s,
+		pos: make([]int, len(*s)),
+		sub: make(Schema, len(names)),
+	}
+	for i := range p.pos {
+		p.pos[i] = -1
+	}
+	for j, name := range names {
+		i := s.index(name)
+		if i < 0 {
+			return nil, fmt.Errorf("msg: no object named %q", name)
+		}
+		if p.pos[i] >= 0 {
+			return nil, fmt.Errorf("msg: object %q is projected twice", name)
+		}
+		p.pos[i] = j
+		p.sub[j] = (*s)[i]
+		p.sub[j].Since, p.sub[j].Default = 0, nil
+	}
+	return p, nil
+}
+
+// Schema returns the Schema of the messages written by
+// Encode. It should not be modified.
+func (p *Projection) Schema() *Schema { return &p.sub }
+
+// Encode writes the projected values in the message 'b' to 'w'
+// as a message of p.Schema(). Objects that are newer than the
+// message (see Object.Since) are written with their Default values.
+func (p *Projection) Encode(b []byte, w Writer) error {
+	var stack [8][]byte
+	var raw [][]byte
+	if len(p.sub) <= len(stack) {
+		raw = stack[:len(p.sub)]
+	} else {
+		raw = make([][]byte, len(p.sub))
+	}
+
+	var nr, found int
+	for i, o := range *p.s {
+		if found == len(p.sub) {
+			break
+		}
+		j := p.pos[i]
+
+		// older messages end before newer objects
+		if o.Since > 0 && nr >= len(b) {
+			if j >= 0 {
+				buf := bytes.NewBuffer(nil)
+				o.encodeDefault(buf)
+				raw[j] = buf.Bytes()
+				found++
+			}
+			continue
+		}
+
+		n, err := skipValue(b[nr:], o)
+		if err != nil {
+			return p.s.explain(b, err)
+		}
+		if j >= 0 {
+			raw[j] = b[nr : nr+n]
+			found++
+		}
+		nr += n
+	}
+
+	for _, r := range raw {
+		w.Write(r)
+	}
+	return nil
+}
+
+// DecodeToMap decodes the projected values in the message
+// 'b' into 'm' (see Schema.DecodeToMap).
+func (p *Projection) DecodeToMap(b []byte, m map[string]interface{}) error {
+	bp := newBytes()
+	defer putBytes(bp)
+	buf := bytes.NewBuffer(*bp)
+	err := p.Encode(b, buf)
+	// all of the buffer, which DecodeToMap reads past
+	*bp = buf.Bytes()
+	if err != nil {
+		return err
+	}
+	return p.sub.DecodeToMap(buf, m)
+}
+
+// WriteJSON writes the projected values in the message
+// 'b' as a JSON object (see Schema.WriteJSON).
+func (p *Projection) WriteJSON(b []byte, w Writer) error {
+	return p.WriteJSONWith(b, w, nil)
+}
+
+// WriteJSONWith writes the projected values in the message
+// 'b' as a JSON object (see Schema.WriteJSONWith).
+func (p *Projection) WriteJSONWith(b []byte, w Writer, opt *JSONOptions) error {
+	bp := newBytes()
+	defer putBytes(bp)
+	buf := bytes.NewBuffer(*bp)
+	err := p.Encode(b, buf)
+	bts := buf.Bytes()
+	*bp = bts
+	if err != nil {
+		return err
+	}
+	return p.sub.WriteJSONWith(bts, w, opt)
+}
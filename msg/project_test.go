@@ -0,0 +1,114 @@
+package msg
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestProject(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := iterSchema.EncodeSlice(iterValues, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := buf.Bytes()
+
+	pr, err := iterSchema.Project("float", "name", "location")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Schema{iterSchema[3], iterSchema[0], iterSchema[10]}
+	if !reflect.DeepEqual(*pr.Schema(), expect) {
+		t.Errorf("Expected schema %v; got %v", expect, *pr.Schema())
+	}
+
+	// re-encoding
+	out := bytes.NewBuffer(nil)
+	err = pr.Encode(p, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := make([]interface{}, 3)
+	err = pr.Schema().DecodeToSliceZeroCopy(out.Bytes(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, []interface{}{3.5, "bike", []interface{}{1.5, -2.5}}) {
+		t.Errorf("Bad values %v", v)
+	}
+
+	m := make(map[string]interface{})
+	err = pr.DecodeToMap(p, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 3 || m["name"] != "bike" || m["float"] != 3.5 {
+		t.Errorf("Bad map %v", m)
+	}
+
+	jbuf := bytes.NewBuffer(nil)
+	err = pr.WriteJSON(p, jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jexpect := `{"float":3.5,"name":"bike","location":{"lat":1.5,"lon":-2.5}}`
+	if jbuf.String() != jexpect {
+		t.Errorf("Expected %s; got %s", jexpect, jbuf.String())
+	}
+
+	// defaults for older messages
+	buf.Reset()
+	schemaV0.EncodeSlice([]interface{}{"bike", 3.5}, buf)
+	pr, err = schemaV2.Project("charge", "val")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jbuf.Reset()
+	err = pr.WriteJSON(buf.Bytes(), jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jbuf.String() != `{"charge":-1,"val":3.5}` {
+		t.Errorf("Got %s", jbuf.String())
+	}
+	// every projected object is written, so none is versioned
+	for _, o := range *pr.Schema() {
+		if o.Since != 0 || o.Default != nil {
+			t.Errorf("Projected object %q has Since %d and Default %v", o.Name, o.Since, o.Default)
+		}
+	}
+
+	// errors
+	if _, err = schemaV2.Project("name", "nope"); err == nil {
+		t.Error("Expected an error for an unknown name")
+	}
+	if _, err = schemaV2.Project("name", "name"); err == nil {
+		t.Error("Expected an error for a repeated name")
+	}
+	if err = pr.Encode(buf.Bytes()[:3], out); !errors.Is(err, ErrShortBytes) {
+		t.Errorf("Expected ErrShortBytes; got %v", err)
+	}
+}
+
+func BenchmarkProjectWriteJSON(b *testing.B) {
+	b.ReportAllocs()
+	buf := bytes.NewBuffer(nil)
+	err := iterSchema.EncodeSlice(iterValues, buf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	p := buf.Bytes()
+	pr, err := iterSchema.Project("name", "float", "bool")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(p)))
+	out := bytes.NewBuffer(nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		pr.WriteJSON(p, out)
+	}
+}
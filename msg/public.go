@@ -33,14 +33,16 @@
 // avoid runtime type reflection.
 package msg
 
+import "time"
+
 // PackExt represents a MessagePack extension, and has msg.Type = msg.Ext.
 // A messagepack extension is simply a tuple of an 8-bit type identifier with arbitary binary data.
 type PackExt struct {
 	// Type is an 8-bit signed integer. The MessagePack standard dictates that 0 through 127
 	// are permitted, while negative values are reserved for future use.
-	EType int8
+	EType int8 `json:"extension_type"`
 	// Data is the data stored in the extension.
-	Data []byte
+	Data []byte `json:"data"`
 }
 
 /* Write takes an object and writes it to a Writer
@@ -54,6 +56,7 @@ Supported type-Type tuples are:
  - string - msg.String
  - []byte - msg.Bin
  - *msg.PackExt - msg.Ext (must be non-nil, otherwise panic)
+ - time.Time - msg.Time
 
 Each type will be compacted on writing if it
 does not require all of its bits to represent itself.
@@ -111,6 +114,13 @@ func WriteInterface(w Writer, v interface{}, t Type) error {
 		}
 		writeExt(w, ext.EType, ext.Data)
 		return nil
+	case Time:
+		tm, ok := v.(time.Time)
+		if !ok {
+			return ErrIncorrectType
+		}
+		writeTime(w, tm)
+		return nil
 	default:
 		return ErrTypeNotSupported
 	}
@@ -143,6 +153,22 @@ func WriteBin(w Writer, b []byte) { writeBin(w, b) }
 //WriteExt writes a messagepack 'extension' (tuple of type, data) to a msg.Writer
 func WriteExt(w Writer, etype int8, data []byte) { writeExt(w, etype, data) }
 
+//WriteTime writes a time.Time as a messagepack timestamp extension (type -1),
+//using the smallest form (32, 64, or 96 bits) that represents 't' exactly.
+func WriteTime(w Writer, t time.Time) { writeTime(w, t) }
+
+//WriteArrayHeader writes the header of an array of 'n' values to a msg.Writer.
+//It must be followed by exactly 'n' values of the same Type.
+func WriteArrayHeader(w Writer, n uint32) { writeArrayHeader(w, n) }
+
+//WriteMapHeader writes the header of a map of 'n' key-value pairs to a msg.Writer.
+//It must be followed by exactly 'n' pairs of values.
+func WriteMapHeader(w Writer, n uint32) { writeMapHeader(w, n) }
+
+//WriteNil writes a messagepack 'nil' to a msg.Writer. Nil may only be
+//used in place of the value of an Optional Schema Object.
+func WriteNil(w Writer) { writeNil(w) }
+
 // ReadXxxx() methods try to read values
 // from a msg.Reader into a value.
 // If the reader reads a leading tag that does not
@@ -153,33 +179,21 @@ func WriteExt(w Writer, etype int8, data []byte) { writeExt(w, etype, data) }
 // ReadFloat tries to read into a float64.
 func ReadFloat(r Reader) (f float64, err error) {
 	f, err = readFloat(r)
-	if err != nil {
-		if err == ErrBadTag {
-			r.UnreadByte()
-		}
-	}
+	err = unreadTag(r, err)
 	return
 }
 
 // ReadFloat32 reads a float32
 func ReadFloat32(r Reader) (f float32, err error) {
 	f, err = readFloat32(r)
-	if err != nil {
-		if err == ErrBadTag {
-			r.UnreadByte()
-		}
-	}
+	err = unreadTag(r, err)
 	return
 }
 
 // ReadFloat64 reads a float64
 func ReadFloat64(r Reader) (f float64, err error) {
 	f, err = readFloat64(r)
-	if err != nil {
-		if err == ErrBadTag {
-			r.UnreadByte()
-		}
-	}
+	err = unreadTag(r, err)
 	return
 }
 
@@ -195,28 +209,23 @@ func ReadFloat64Bytes(p []byte) (f float64, n int, err error) { return readFloat
 // ReadFloat32Bytes attempts to read a float32 out of 'p'
 func ReadFloat32Bytes(p []byte) (f float32, n int, err error) { return readFloat32Bytes(p) }
 
-// ReadInt tries to read into an int64
+// ReadInt tries to read into an int64.
+// Earlier versions of this package wrote 128 to 255
+// as int8, so int8 values are read as unsigned (use
+// Schema.FromMsgpackMap to convert standard MessagePack).
 func ReadInt(r Reader) (i int64, err error) {
 	i, err = readInt(r)
-	if err != nil {
-		if err == ErrBadTag {
-			r.UnreadByte()
-		}
-	}
+	err = unreadTag(r, err)
 	return
 }
 
-// ReadIntBytes reads an int64 from 'p'
+// ReadIntBytes reads an int64 from 'p' (see ReadInt)
 func ReadIntBytes(p []byte) (i int64, n int, err error) { return readIntBytes(p) }
 
 // ReadUint tries to read into a uint64.
 func ReadUint(r Reader) (u uint64, err error) {
 	u, err = readUint(r)
-	if err != nil {
-		if err == ErrBadTag {
-			r.UnreadByte()
-		}
-	}
+	err = unreadTag(r, err)
 	return
 }
 
@@ -225,11 +234,7 @@ func ReadUintBytes(p []byte) (u uint64, n int, err error) { return readUintBytes
 // ReadString tries to read into a string.
 func ReadString(r Reader) (s string, err error) {
 	s, err = readString(r)
-	if err != nil {
-		if err == ErrBadTag {
-			r.UnreadByte()
-		}
-	}
+	err = unreadTag(r, err)
 	return
 }
 
@@ -242,11 +247,7 @@ func ReadStringZeroCopy(p []byte) (s string, n int, err error) { return readStri
 // ReadBool tries to read into a bool.
 func ReadBool(r Reader) (b bool, err error) {
 	b, err = readBool(r)
-	if err != nil {
-		if err == ErrBadTag {
-			r.UnreadByte()
-		}
-	}
+	err = unreadTag(r, err)
 	return
 }
 
@@ -263,11 +264,7 @@ func ReadBoolBytes(p []byte) (b bool, n int, err error) { return readBoolBytes(p
 // then they point to the same underlying array.)
 func ReadBin(r Reader, b []byte) (dat []byte, err error) {
 	dat, err = readBin(r, b)
-	if err != nil {
-		if err == ErrBadTag {
-			r.UnreadByte()
-		}
-	}
+	err = unreadTag(r, err)
 	return
 }
 
@@ -283,10 +280,7 @@ func ReadBinZeroCopy(p []byte) (dat []byte, n int, err error) { return readBinZe
 func ReadExt(r Reader, b []byte) (p *PackExt, err error) {
 	dat, etype, err := readExt(r, b)
 	if err != nil {
-		if err == ErrBadTag {
-			r.UnreadByte()
-		}
-		return nil, err
+		return nil, unreadTag(r, err)
 	}
 	p = &PackExt{EType: etype, Data: dat}
 	return p, nil
@@ -297,6 +291,80 @@ func ReadExt(r Reader, b []byte) (p *PackExt, err error) {
 // Note that 'dat' is a slice of 'p' - changes to 'p' will be reflected in 'dat' and vice-versa.
 func ReadExtZeroCopy(p []byte) (dat []byte, etype int8, n int, err error) { return readExtZeroCopy(p) }
 
+// ReadTime reads a messagepack timestamp extension into a time.Time (in UTC).
+// If the leading object is an extension of a different type, ReadTime
+// returns ErrNotTime.
+func ReadTime(r Reader) (t time.Time, err error) {
+	t, err = readTime(r)
+	err = unreadTag(r, err)
+	return
+}
+
+// ReadTimeBytes reads a messagepack timestamp extension from 'p' into
+// a time.Time (in UTC), along with the number of bytes read, or an error.
+func ReadTimeBytes(p []byte) (t time.Time, n int, err error) { return readTimeBytes(p) }
+
+// ReadArrayHeader reads the length of an array.
+func ReadArrayHeader(r Reader) (n uint32, err error) {
+	n, err = readArrayHeader(r)
+	err = unreadTag(r, err)
+	return
+}
+
+// ReadArrayHeaderBytes reads the length of an array from 'p',
+// along with the number of bytes read, or an error.
+func ReadArrayHeaderBytes(p []byte) (sz uint32, n int, err error) { return readArrayHeaderBytes(p) }
+
+// ReadMapHeader reads the number of key-value pairs in a map.
+func ReadMapHeader(r Reader) (n uint32, err error) {
+	n, err = readMapHeader(r)
+	err = unreadTag(r, err)
+	return
+}
+
+// ReadMapHeaderBytes reads the number of key-value pairs in a map
+// from 'p', along with the number of bytes read, or an error.
+func ReadMapHeaderBytes(p []byte) (sz uint32, n int, err error) { return readMapHeaderBytes(p) }
+
+// ReadNil reads a 'nil' from a msg.Reader, returning
+// ErrBadTag (and unreading the leading byte) if the leading
+// object is not nil.
+func ReadNil(r Reader) (err error) {
+	return unreadTag(r, readNil(r))
+}
+
+// ReadNilBytes reads a 'nil' from 'p', returning the number
+// of bytes read, or an error.
+func ReadNilBytes(p []byte) (n int, err error) { return readNilBytes(p) }
+
+// IsNil returns whether or not the leading object in 'p' is 'nil'.
+func IsNil(p []byte) bool { return len(p) > 0 && p[0] == mnil }
+
+// Skip reads and discards the leading object in a msg.Reader,
+// including all of the objects in an array or map.
+func Skip(r Reader) error { return skip(r) }
+
+// SkipBytes returns the number of bytes in the leading object
+// in 'p', including all of the objects in an array or map, or an error
+// if 'p' is too short or contains a bad tag.
+func SkipBytes(p []byte) (n int, err error) { return skipBytes(p) }
+
+// ValueLen returns the number of bytes in the leading
+// object in 'p' (see SkipBytes), or 0 if it is malformed.
+func ValueLen(p []byte) int {
+	n, err := skipBytes(p)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// NextType returns the msg.Type of the leading object in 'p'
+// without reading it. Positive fixints are reported as msg.Int,
+// and timestamp extensions as msg.Time. If the leading object is
+// nil, NextType returns ErrNil.
+func NextType(p []byte) (Type, error) { return nextType(p) }
+
 // ReadInterface returns an interface{} containing the leading object in the reader,
 // along with its msg.Type.
 //
@@ -305,6 +373,7 @@ func ReadExtZeroCopy(p []byte) (dat []byte, etype int8, n int, err error) { retu
 //  - msg.Uint -> uint64
 //  - msg.Bool -> bool
 //  - msg.Ext -> *msg.PackExt
+//  - msg.Time -> time.Time (for timestamp extensions)
 //  - msg.Bin -> []byte
 //  - msg.String -> string
 //  - msg.Float -> float64
@@ -395,6 +464,15 @@ func ReadInterface(r Reader) (v interface{}, t Type, err error) {
 		if err != nil {
 			return
 		}
+		if etype == timeExt {
+			var tm time.Time
+			tm, err = extTime(dat)
+			if err == nil {
+				v, t = tm, Time
+				return
+			}
+			err = nil
+		}
 		v = &PackExt{EType: etype, Data: dat}
 		return
 	case mstr8, mstr16, mstr32:
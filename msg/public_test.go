@@ -2,8 +2,10 @@ package msg
 
 import (
 	"bytes"
+	"io"
 	"reflect"
 	"testing"
+	"time"
 )
 
 // this should cover all of the public read/write methods
@@ -47,3 +49,234 @@ func TestReadWriteInterfacePublic(t *testing.T) {
 	}
 
 }
+
+func TestReadWriteNil(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	WriteNil(buf)
+	WriteInt(buf, 3)
+	if !IsNil(buf.Bytes()) {
+		t.Error("IsNil should be true")
+	}
+	n, err := ReadNilBytes(buf.Bytes())
+	if err != nil || n != 1 {
+		t.Errorf("ReadNilBytes: %d bytes, %v", n, err)
+	}
+	err = ReadNil(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsNil(buf.Bytes()) {
+		t.Error("IsNil should be false")
+	}
+	err = ReadNil(buf)
+	if err != ErrBadTag {
+		t.Errorf("Expected ErrBadTag; got %v", err)
+	}
+	// the tag should have been unread
+	i, err := ReadInt(buf)
+	if err != nil || i != 3 {
+		t.Errorf("Expected 3; got %d (%v)", i, err)
+	}
+}
+
+func TestReadWriteTime(t *testing.T) {
+	testvals := []struct {
+		t    time.Time
+		size int // encoded size
+	}{
+		{time.Unix(0, 0), 6},
+		{time.Unix(1<<32-1, 0), 6},
+		{time.Unix(1<<32, 0), 10},
+		{time.Unix(1414000000, 123456789), 10},
+		{time.Unix(1<<34-1, 999999999), 10},
+		{time.Unix(1<<34, 0), 15},
+		{time.Unix(-1, 500), 15},
+		{time.Date(1900, 1, 1, 0, 0, 0, 1, time.UTC), 15},
+	}
+	for i, tv := range testvals {
+		buf := bytes.NewBuffer(nil)
+		WriteTime(buf, tv.t)
+		if buf.Len() != tv.size {
+			t.Errorf("Test case %d: encoded size is %d; expected %d", i, buf.Len(), tv.size)
+		}
+		bts := buf.Bytes()
+
+		out, n, err := ReadTimeBytes(bts)
+		if err != nil {
+			t.Errorf("Test case %d: %s", i, err)
+			continue
+		}
+		if n != tv.size {
+			t.Errorf("Test case %d: read %d bytes; expected %d", i, n, tv.size)
+		}
+		if !out.Equal(tv.t) {
+			t.Errorf("Test case %d: ReadTimeBytes: expected %s; got %s", i, tv.t, out)
+		}
+
+		out, err = ReadTime(buf)
+		if err != nil {
+			t.Errorf("Test case %d: %s", i, err)
+			continue
+		}
+		if !out.Equal(tv.t) {
+			t.Errorf("Test case %d: ReadTime: expected %s; got %s", i, tv.t, out)
+		}
+	}
+
+	// other extensions aren't timestamps
+	buf := bytes.NewBuffer(nil)
+	WriteExt(buf, 3, []byte{1, 2, 3, 4})
+	_, _, err := ReadTimeBytes(buf.Bytes())
+	if err != ErrNotTime {
+		t.Errorf("Expected ErrNotTime; got %v", err)
+	}
+	_, err = ReadTime(buf)
+	if err != ErrNotTime {
+		t.Errorf("Expected ErrNotTime; got %v", err)
+	}
+
+	// bad tags are unread
+	WriteInt(buf, 3)
+	_, err = ReadTime(buf)
+	if err != ErrBadTag {
+		t.Errorf("Expected ErrBadTag; got %v", err)
+	}
+	i, err := ReadInt(buf)
+	if err != nil || i != 3 {
+		t.Errorf("Expected 3; got %d (%v)", i, err)
+	}
+
+	// ReadInterface returns a time.Time
+	tm := time.Unix(1414000000, 5).UTC()
+	WriteInterface(buf, tm, Time)
+	v, typ, err := ReadInterface(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != Time || v != tm {
+		t.Errorf("Expected %s (Time); got %v (%d)", tm, v, typ)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	// one value for every tag, with its Type
+	type value struct {
+		t     Type
+		write func(w Writer)
+	}
+	str := func(n int) string { return string(bytes.Repeat([]byte{'a'}, n)) }
+	bin := func(n int) []byte { return bytes.Repeat([]byte{'b'}, n) }
+	values := []value{
+		{Int, func(w Writer) { WriteInt(w, 5) }},
+		{Int, func(w Writer) { WriteInt(w, -5) }},
+		{Int, func(w Writer) { WriteInt(w, -100) }},
+		{Int, func(w Writer) { WriteInt(w, -1000) }},
+		{Int, func(w Writer) { WriteInt(w, -100000) }},
+		{Int, func(w Writer) { WriteInt(w, -1<<40) }},
+		{Uint, func(w Writer) { WriteUint(w, 200) }},
+		{Uint, func(w Writer) { WriteUint(w, 1000) }},
+		{Uint, func(w Writer) { WriteUint(w, 100000) }},
+		{Uint, func(w Writer) { WriteUint(w, 1<<40) }},
+		{Float, func(w Writer) { WriteFloat32(w, 1.5) }},
+		{Float, func(w Writer) { WriteFloat64(w, 1.1) }},
+		{Bool, func(w Writer) { WriteBool(w, true) }},
+		{Bool, func(w Writer) { WriteBool(w, false) }},
+		{String, func(w Writer) { WriteString(w, str(3)) }},
+		{String, func(w Writer) { WriteString(w, str(40)) }},
+		{String, func(w Writer) { WriteString(w, str(300)) }},
+		{String, func(w Writer) { WriteString(w, str(70000)) }},
+		{Bin, func(w Writer) { WriteBin(w, bin(3)) }},
+		{Bin, func(w Writer) { WriteBin(w, bin(300)) }},
+		{Bin, func(w Writer) { WriteBin(w, bin(70000)) }},
+		{Ext, func(w Writer) { WriteExt(w, 3, bin(1)) }},
+		{Ext, func(w Writer) { WriteExt(w, 3, bin(2)) }},
+		{Ext, func(w Writer) { WriteExt(w, 3, bin(4)) }},
+		{Ext, func(w Writer) { WriteExt(w, 3, bin(8)) }},
+		{Ext, func(w Writer) { WriteExt(w, 3, bin(16)) }},
+		{Ext, func(w Writer) { WriteExt(w, 3, bin(3)) }},
+		{Ext, func(w Writer) { WriteExt(w, 3, bin(300)) }},
+		{Ext, func(w Writer) { WriteExt(w, 3, bin(70000)) }},
+		{Time, func(w Writer) { WriteTime(w, time.Unix(1414000000, 0)) }},
+		{Time, func(w Writer) { WriteTime(w, time.Unix(1414000000, 5)) }},
+		{Time, func(w Writer) { WriteTime(w, time.Unix(-1, 0)) }},
+		{Array, func(w Writer) { WriteArrayHeader(w, 2); WriteInt(w, 1); WriteString(w, "x") }},
+		{Array, func(w Writer) {
+			WriteArrayHeader(w, 20)
+			for i := 0; i < 20; i++ {
+				WriteArrayHeader(w, 1)
+				WriteNil(w)
+			}
+		}},
+		{Array, func(w Writer) {
+			WriteArrayHeader(w, 70000)
+			for i := 0; i < 70000; i++ {
+				WriteBool(w, true)
+			}
+		}},
+		{Map, func(w Writer) { WriteMapHeader(w, 1); WriteString(w, "k"); WriteFloat(w, 2.5) }},
+		{Map, func(w Writer) {
+			WriteMapHeader(w, 20)
+			for i := 0; i < 20; i++ {
+				WriteInt(w, int64(i))
+				WriteMapHeader(w, 0)
+			}
+		}},
+		{Map, func(w Writer) {
+			WriteMapHeader(w, 70000)
+			for i := 0; i < 70000; i++ {
+				WriteUint(w, 1)
+				WriteNil(w)
+			}
+		}},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	var lens []int
+	for _, v := range values {
+		n := buf.Len()
+		v.write(buf)
+		lens = append(lens, buf.Len()-n)
+	}
+	WriteNil(buf)
+	p := buf.Bytes()
+	r := bytes.NewReader(p)
+
+	var off int
+	for i, v := range values {
+		tp, err := NextType(p[off:])
+		if err != nil || tp != v.t {
+			t.Errorf("Test case %d: expected type %s; got %s (%v)", i, v.t, tp, err)
+		}
+		n, err := SkipBytes(p[off:])
+		if err != nil || n != lens[i] {
+			t.Errorf("Test case %d: expected to skip %d bytes; got %d (%v)", i, lens[i], n, err)
+		}
+		if ValueLen(p[off:]) != lens[i] {
+			t.Errorf("Test case %d: expected ValueLen %d; got %d", i, lens[i], ValueLen(p[off:]))
+		}
+		if _, err := SkipBytes(p[off : off+lens[i]-1]); err != ErrShortBytes {
+			t.Errorf("Test case %d: expected ErrShortBytes; got %v", i, err)
+		}
+		err = Skip(r)
+		if err != nil {
+			t.Errorf("Test case %d: %s", i, err)
+		}
+		off += lens[i]
+		if r.Len() != len(p)-off {
+			t.Fatalf("Test case %d: Skip left %d bytes; expected %d", i, r.Len(), len(p)-off)
+		}
+	}
+
+	if _, err := NextType(p[off:]); err != ErrNil {
+		t.Errorf("Expected ErrNil; got %v", err)
+	}
+	if n, err := SkipBytes(p[off:]); n != 1 || err != nil {
+		t.Errorf("Expected to skip nil; got %d (%v)", n, err)
+	}
+	if _, err := SkipBytes([]byte{0xc1}); err != ErrBadTag {
+		t.Errorf("Expected ErrBadTag; got %v", err)
+	}
+	if err := Skip(bytes.NewReader([]byte{mstr8, 4, 'a'})); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF; got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// JSONError is returned by ReadJSON when
+// the input does not match the Schema.
+type JSONError struct {
+	// Key is the key of the value, including the keys
+	// of any enclosing objects (e.g. "location.lat")
+	Key string
+	// Reason describes the error
+	Reason string
+}
+
+func (j *JSONError) Error() string {
+	if j.Key == "" {
+		return "msg: JSON: " + j.Reason
+	}
+	return fmt.Sprintf("msg: JSON key %q: %s", j.Key, j.Reason)
+}
+
+// ReadJSON reads a JSON object from 'r' and writes it to 'w' as a
+// message, with each value taken from the key that matches the Name
+// of its Object. It accepts the output of WriteJSON: Bin values are
+// base64 strings, Ext values are {"extension_type":<int8>, "data":<base64 string>},
+// Time values are RFC3339 strings, and Struct values are nested objects.
+// A missing key is written as the Default of its Object (see Object.Since),
+// or as nil if the Object is Optional. Missing keys, keys that are not
+// in the Schema, and values of the wrong type are returned as a *JSONError,
+// and nothing is written to 'w'. ReadJSON may read past the end of
+// the object in 'r'.
+func (s *Schema) ReadJSON(r io.Reader, w Writer) error {
+	var raw json.RawMessage
+	err := json.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(nil)
+	err = s.encodeJSON(raw, buf, "")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// encodeJSON encodes the JSON object 'raw'. 'prefix'
+// is prepended to keys in errors.
+func (s *Schema) encodeJSON(raw json.RawMessage, w Writer, prefix string) error {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(raw, &obj)
+	if err != nil || obj == nil {
+		return &JSONError{Key: trimDot(prefix), Reason: "expected an object"}
+	}
+	for i := range *s {
+		o := &(*s)[i]
+		key := prefix + o.Name
+		v, ok := obj[o.Name]
+		if !ok {
+			switch {
+			case o.Default != nil:
+				err = encode(o.Default, *o, w)
+			case o.Optional:
+				writeNil(w)
+			default:
+				return &JSONError{Key: key, Reason: "missing"}
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		delete(obj, o.Name)
+		err = o.encodeJSON(v, w, key)
+		if err != nil {
+			return err
+		}
+	}
+	if len(obj) > 0 {
+		extra := make([]string, 0, len(obj))
+		for k := range obj {
+			extra = append(extra, k)
+		}
+		sort.Strings(extra)
+		return &JSONError{Key: prefix + extra[0], Reason: "not in schema"}
+	}
+	return nil
+}
+
+// encodeJSON encodes the JSON value 'v' of
+// the object named 'key' (see Schema.encodeJSON)
+func (o *Object) encodeJSON(v json.RawMessage, w Writer, key string) error {
+	if bytes.Equal(v, null) {
+		if !o.Optional {
+			return &JSONError{Key: key, Reason: "null, but " + o.T.String() + " is not optional"}
+		}
+		writeNil(w)
+		return nil
+	}
+	switch o.T {
+	case Struct:
+		return o.sub().encodeJSON(v, w, key+".")
+	case Ext:
+		err := checkKeys(v, exttype, data)
+		if err != nil {
+			return &JSONError{Key: key, Reason: "expected ext: " + err.Error()}
+		}
+	}
+	val, err := jsonValue(v, o)
+	if err != nil {
+		return &JSONError{Key: key, Reason: "expected " + o.typeName()}
+	}
+	return encode(val, *o, w)
+}
+
+// checkKeys returns an error unless the JSON
+// object 'raw' has exactly the given keys
+func checkKeys(raw json.RawMessage, keys ...[]byte) error {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(raw, &obj)
+	if err != nil || obj == nil {
+		return fmt.Errorf("not an object")
+	}
+	for _, k := range keys {
+		if _, ok := obj[string(k)]; !ok {
+			return fmt.Errorf("missing key %q", k)
+		}
+		delete(obj, string(k))
+	}
+	for k := range obj {
+		return fmt.Errorf("unexpected key %q", k)
+	}
+	return nil
+}
+
+func trimDot(prefix string) string {
+	if len(prefix) > 0 {
+		return prefix[:len(prefix)-1]
+	}
+	return prefix
+}
+
+// typeName returns the name of the type of 'o', including its Elem
+func (o *Object) typeName() string {
+	if o.T == Array || o.T == Map {
+		return o.T.String() + " of " + o.Elem.String()
+	}
+	return o.T.String()
+}
@@ -0,0 +1,111 @@
+package msg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadJSON(t *testing.T) {
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "id", T: Uint},
+		{Name: "val", T: Float},
+		{Name: "ok", T: Bool},
+		{Name: "raw", T: Bin},
+		{Name: "ext", T: Ext},
+		{Name: "seen", T: Time},
+		{Name: "tags", T: Array, Elem: String, Optional: true},
+		{Name: "labels", T: Map, Elem: Int},
+		{Name: "location", T: Struct, Schema: &Schema{{Name: "lat", T: Float}, {Name: "lon", T: Float}}},
+		{Name: "charge", T: Int, Since: 1, Default: int64(-1)},
+	}
+	in := []interface{}{
+		"bike", uint64(1<<63 + 1), 3.5, true, []byte("raw"), &PackExt{EType: 3, Data: []byte("ext")},
+		time.Unix(1414000000, 5).UTC(), nil, map[string]interface{}{"a": int64(-4)},
+		[]interface{}{1.5, -2.5}, int64(8),
+	}
+	buf := bytes.NewBuffer(nil)
+	err := s.EncodeSlice(in, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := buf.Bytes()
+
+	// WriteJSON -> ReadJSON is lossless
+	jbuf := bytes.NewBuffer(nil)
+	err = s.WriteJSON(expect, jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := bytes.NewBuffer(nil)
+	err = s.ReadJSON(jbuf, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), expect) {
+		t.Errorf("Expected %x; got %x", expect, out.Bytes())
+	}
+
+	// missing keys use defaults and nil
+	sv := Schema{{Name: "name", T: String}, {Name: "tags", T: Array, Elem: String, Optional: true}, s[10]}
+	out.Reset()
+	err = sv.ReadJSON(strings.NewReader(`{"name":"bike"}`), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := make([]interface{}, 3)
+	err = sv.DecodeToSliceZeroCopy(out.Bytes(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v[0] != "bike" || v[1] != nil || v[2] != int64(-1) {
+		t.Errorf("Bad values %v", v)
+	}
+
+	tests := []struct {
+		json string
+		key  string
+	}{
+		{`{"tags":null}`, "name"},
+		{`{"name":"bike","charge":1,"color":"red"}`, "color"},
+		{`{"name":1}`, "name"},
+		{`{"name":"bike","tags":[1]}`, "tags"},
+		{`{"name":"bike","charge":1.5}`, "charge"},
+		{`[]`, ""},
+	}
+	for i, tt := range tests {
+		out.Reset()
+		err = sv.ReadJSON(strings.NewReader(tt.json), out)
+		jerr, ok := err.(*JSONError)
+		if !ok {
+			t.Errorf("Test case %d: expected *JSONError; got %v", i, err)
+			continue
+		}
+		if jerr.Key != tt.key {
+			t.Errorf("Test case %d: expected key %q; got %s", i, tt.key, jerr)
+		}
+		if out.Len() != 0 {
+			t.Errorf("Test case %d: wrote %d bytes", i, out.Len())
+		}
+	}
+
+	errs := []struct {
+		json string
+		key  string
+	}{
+		{`{"location":{"lat":1}}`, "location.lon"},
+		{`{"location":{"lat":1,"lon":2,"alt":3}}`, "location.alt"},
+		{`{"location":null}`, "location"},
+		{`{"location":{"lat":1,"lon":2},"ext":{"data":"AA=="}}`, "ext"},
+	}
+	so := Schema{s[9], s[5]}
+	for i, tt := range errs {
+		err = so.ReadJSON(strings.NewReader(tt.json), out)
+		jerr, ok := err.(*JSONError)
+		if !ok || jerr.Key != tt.key {
+			t.Errorf("Test case %d: expected an error for key %q; got %v", i, tt.key, err)
+		}
+	}
+}
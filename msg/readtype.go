@@ -3,6 +3,8 @@ package msg
 import (
 	"errors"
 	"io"
+	"io/ioutil"
+	"time"
 	"unsafe"
 )
 
@@ -10,6 +12,10 @@ var (
 	//ErrBadTag blah blah blah
 	ErrBadTag     = errors.New("Bad tag.")
 	ErrShortBytes = errors.New("Byte array is too short for type.")
+	//ErrNotTime is returned when an extension is not a well-formed timestamp
+	ErrNotTime = errors.New("Extension is not a timestamp.")
+	//ErrNil is returned by NextType when the next value is nil
+	ErrNil = errors.New("Value is nil.")
 )
 
 //Reader must implement io.Reader, io.ByteReader, and be able to unread a byte.
@@ -21,6 +27,48 @@ type Reader interface {
 	UnreadByte() error
 }
 
+// tagError is ErrBadTag from reading a value from a
+// Reader, along with the tag that was found. It is
+// returned directly after reading the tag, so the
+// tag is the last byte read (see readError).
+type tagError byte
+
+func (t tagError) Error() string { return ErrBadTag.Error() }
+
+// Is returns whether or not 'target' is ErrBadTag.
+func (t tagError) Is(target error) bool { return target == ErrBadTag }
+
+// readFull reads len(b) bytes of a value whose tag has been
+// read, returning io.ErrUnexpectedEOF if 'r' ends first
+func readFull(r Reader, b []byte) error {
+	_, err := io.ReadFull(r, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// nextByte reads the next byte of a value whose tag has
+// been read, returning io.ErrUnexpectedEOF if 'r' ends
+func nextByte(r Reader) (byte, error) {
+	c, err := r.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return c, err
+}
+
+// unreadTag unreads the tag of a tagError from 'r' and
+// returns ErrBadTag in its place. Other errors are
+// returned as-is.
+func unreadTag(r Reader, err error) error {
+	if _, ok := err.(tagError); ok {
+		r.UnreadByte()
+		return ErrBadTag
+	}
+	return err
+}
+
 func readBool(r Reader) (b bool, err error) {
 	c, err := r.ReadByte()
 	if err != nil {
@@ -32,7 +80,7 @@ func readBool(r Reader) (b bool, err error) {
 	case mfalse:
 		b = false
 	default:
-		err = ErrBadTag
+		err = tagError(c)
 	}
 	return
 }
@@ -57,21 +105,21 @@ func readInt(r Reader) (i int64, err error) {
 
 	switch c {
 	case mint8:
-		n, err = r.ReadByte()
+		n, err = nextByte(r)
 		if err != nil {
 			return
 		}
 		i = int64(n)
 		return
 	case mint16:
-		_, err = r.Read(bs[:2])
+		err = readFull(r, bs[:2])
 		if err != nil {
 			return
 		}
 		i = int64(int16(bs[1]) | (int16(bs[0]) << 8))
 		return
 	case mint32:
-		_, err = r.Read(bs[:4])
+		err = readFull(r, bs[:4])
 		if err != nil {
 			return
 		}
@@ -81,7 +129,7 @@ func readInt(r Reader) (i int64, err error) {
 			(int32(bs[0]) << 24))
 		return
 	case mint64:
-		_, err = r.Read(bs[:8])
+		err = readFull(r, bs[:8])
 		if err != nil {
 			return
 		}
@@ -94,7 +142,7 @@ func readInt(r Reader) (i int64, err error) {
 			uint64(bs[1])<<48 |
 			uint64(bs[0])<<56)
 	default:
-		err = ErrBadTag
+		err = tagError(c)
 		return
 	}
 	return
@@ -115,7 +163,7 @@ func readUint(r Reader) (i uint64, err error) {
 
 	switch c {
 	case muint8:
-		_, err = r.Read(bs[:1])
+		err = readFull(r, bs[:1])
 		if err != nil {
 			return
 		}
@@ -123,7 +171,7 @@ func readUint(r Reader) (i uint64, err error) {
 		return
 
 	case muint16:
-		_, err = r.Read(bs[:2])
+		err = readFull(r, bs[:2])
 		if err != nil {
 			return
 		}
@@ -131,7 +179,7 @@ func readUint(r Reader) (i uint64, err error) {
 		return
 
 	case muint32:
-		_, err = r.Read(bs[:4])
+		err = readFull(r, bs[:4])
 		if err != nil {
 			return
 		}
@@ -142,7 +190,7 @@ func readUint(r Reader) (i uint64, err error) {
 		return
 
 	case muint64:
-		_, err = r.Read(bs[:8])
+		err = readFull(r, bs[:8])
 		if err != nil {
 			return
 		}
@@ -157,7 +205,7 @@ func readUint(r Reader) (i uint64, err error) {
 		return
 
 	default:
-		err = ErrBadTag
+		err = tagError(c)
 		return
 	}
 
@@ -183,7 +231,7 @@ func readString(r Reader) (s string, err error) {
 		if n > 31 {
 			panic("Impossible")
 		}
-		_, err = r.Read(bs[:n])
+		err = readFull(r, bs[:n])
 		if err != nil {
 			return
 		}
@@ -194,33 +242,35 @@ func readString(r Reader) (s string, err error) {
 	//determine length
 	switch c {
 	case mstr8:
-		c, err = r.ReadByte()
+		ns[0], err = nextByte(r)
 		if err != nil {
 			return
 		}
-		n = uint32(c)
+		n = uint32(ns[0])
 
 	case mstr16:
-		_, err = r.Read(ns[:2])
+		err = readFull(r, ns[:2])
 		if err != nil {
 			return
 		}
 		n = uint32(uint16(ns[1]) | (uint16(ns[0]) << 8))
 
 	case mstr32:
-		_, err = r.Read(ns[:4])
+		err = readFull(r, ns[:4])
 		if err != nil {
 			return
 		}
 		n = uint32(uint32(ns[3]) | (uint32(ns[2]) << 8) | (uint32(ns[1]) << 16) | (uint32(ns[0]) << 24))
 
 	default:
-		err = ErrBadTag
+		err = tagError(c)
+		return
+	}
+	err = checkSize(c, n)
+	if err != nil {
 		return
 	}
-	//make slice; read
-	bsl = make([]byte, n)
-	_, err = r.Read(bsl)
+	bsl, err = readSized(r, nil, n)
 	if err != nil {
 		return
 	}
@@ -242,43 +292,38 @@ func readBin(r Reader, p []byte) (dat []byte, err error) {
 	//find n
 	switch c {
 	case mbin8:
-		c, err = r.ReadByte()
+		ns[0], err = nextByte(r)
 		if err != nil {
 			return
 		}
-		n = uint32(uint8(c))
+		n = uint32(ns[0])
 
 	case mbin16:
-		_, err = r.Read(ns[:2])
+		err = readFull(r, ns[:2])
 		if err != nil {
 			return
 		}
 		n = uint32(uint16(ns[1]) | (uint16(ns[0]) << 8))
 
 	case mbin32:
-		_, err = r.Read(ns[:4])
+		err = readFull(r, ns[:4])
 		if err != nil {
 			return
 		}
 		n = uint32(uint32(ns[3]) | (uint32(ns[2]) << 8) | (uint32(ns[1]) << 16) | (uint32(ns[0]) << 24))
 
 	default:
-		err = ErrBadTag
+		err = tagError(c)
 		return
 
 	}
+	err = checkSize(c, n)
+	if err != nil {
+		return
+	}
 
 	//use p if possible
-	if p != nil {
-		if cap(p) >= int(n) {
-			p = p[:n]
-			_, err = r.Read(p)
-			dat = p
-			return
-		}
-	}
-	dat = make([]byte, n, n)
-	_, err = r.Read(dat)
+	dat, err = readSized(r, p, n)
 	return
 
 }
@@ -323,14 +368,14 @@ func readExt(r Reader, b []byte) (dat []byte, etype int8, err error) {
 	//read length
 	switch c {
 	case mext8:
-		ns[0], err = r.ReadByte()
+		ns[0], err = nextByte(r)
 		if err != nil {
 			return
 		}
 		n = uint32(ns[0])
 
 	case mext16:
-		_, err = r.Read(ns[:2])
+		err = readFull(r, ns[:2])
 		if err != nil {
 			return
 
@@ -338,34 +383,29 @@ func readExt(r Reader, b []byte) (dat []byte, etype int8, err error) {
 		n = uint32(uint16(ns[1]) | (uint16(ns[0]) << 8))
 
 	case mext32:
-		_, err = r.Read(ns[:4])
+		err = readFull(r, ns[:4])
 		if err != nil {
 			return
 		}
 		n = uint32(uint32(ns[3]) | (uint32(ns[2]) << 8) | (uint32(ns[1]) << 16) | (uint32(ns[0]) << 24))
 
 	default:
-		err = ErrBadTag
+		err = tagError(c)
+		return
+	}
+	err = checkSize(c, n)
+	if err != nil {
 		return
 	}
 
 	//read extension type
-	c, err = r.ReadByte()
+	c, err = nextByte(r)
 	if err != nil {
 		return
 	}
 	etype = int8(c)
 
-	if b != nil {
-		if cap(b) > int(n) {
-			b = b[:n]
-			_, err = r.Read(b)
-			dat = b
-			return
-		}
-	}
-	dat = make([]byte, n, n)
-	_, err = r.Read(dat)
+	dat, err = readSized(r, b, n)
 	return
 
 }
@@ -376,13 +416,13 @@ func readfixExt(r Reader, dat []byte, size uint8) (etype int8, err error) {
 	}
 
 	var c byte
-	c, err = r.ReadByte()
+	c, err = nextByte(r)
 	if err != nil {
 		return
 	}
 	etype = int8(c)
 
-	_, err = r.Read(dat[:size])
+	err = readFull(r, dat[:size])
 	if err != nil {
 		return
 	}
@@ -409,18 +449,57 @@ func readMapHeader(r Reader) (n uint32, err error) {
 	var ns [4]byte
 	switch c {
 	case mmap16:
-		_, err = r.Read(ns[:2])
+		err = readFull(r, ns[:2])
 		n = uint32(uint16(ns[1]) | (uint16(ns[0]) << 8))
 
 	case mmap32:
-		_, err = r.Read(ns[:4])
+		err = readFull(r, ns[:4])
+		n = uint32(uint32(ns[3]) | (uint32(ns[2]) << 8) | (uint32(ns[1]) << 16) | (uint32(ns[0]) << 24))
+
+	default:
+		err = tagError(c)
+		return
+
+	}
+	if err == nil {
+		err = checkSize(c, n)
+	}
+	return
+}
+
+//returns the length of the array
+func readArrayHeader(r Reader) (n uint32, err error) {
+	var c byte
+	c, err = r.ReadByte()
+	if err != nil {
+		return
+	}
+
+	//fixarray case
+	//if c & 11110000 == 10010000, b/c fixarray is 1001XXXX
+	if (c & 0xf0) == 0x90 {
+		n = uint32(c & 0xf)
+		return
+	}
+
+	var ns [4]byte
+	switch c {
+	case marray16:
+		err = readFull(r, ns[:2])
+		n = uint32(uint16(ns[1]) | (uint16(ns[0]) << 8))
+
+	case marray32:
+		err = readFull(r, ns[:4])
 		n = uint32(uint32(ns[3]) | (uint32(ns[2]) << 8) | (uint32(ns[1]) << 16) | (uint32(ns[0]) << 24))
 
 	default:
-		err = ErrBadTag
+		err = tagError(c)
 		return
 
 	}
+	if err == nil {
+		err = checkSize(c, n)
+	}
 	return
 }
 
@@ -431,7 +510,7 @@ func readNil(r Reader) (err error) {
 		return
 	}
 	if c != mnil {
-		err = ErrBadTag
+		err = tagError(c)
 	}
 	return
 }
@@ -445,11 +524,11 @@ func readFloat32(r Reader) (f float32, err error) {
 		return
 	}
 	if c != mfloat32 {
-		err = ErrBadTag
+		err = tagError(c)
 		return
 	}
 
-	_, err = r.Read(ns[:4])
+	err = readFull(r, ns[:4])
 	if err != nil {
 		return
 	}
@@ -467,11 +546,11 @@ func readFloat64(r Reader) (f float64, err error) {
 		return
 	}
 	if c != mfloat64 {
-		err = ErrBadTag
+		err = tagError(c)
 		return
 	}
 
-	_, err = r.Read(ns[:8])
+	err = readFull(r, ns[:8])
 	if err != nil {
 		return
 	}
@@ -501,7 +580,7 @@ func readFloat(r Reader) (f float64, err error) {
 
 	switch c {
 	case mfloat32:
-		_, err = r.Read(ns[:4])
+		err = readFull(r, ns[:4])
 		if err != nil {
 			return
 		}
@@ -511,7 +590,7 @@ func readFloat(r Reader) (f float64, err error) {
 		return
 
 	case mfloat64:
-		_, err = r.Read(ns[:8])
+		err = readFull(r, ns[:8])
 		if err != nil {
 			return
 		}
@@ -527,8 +606,72 @@ func readFloat(r Reader) (f float64, err error) {
 		return
 
 	default:
-		err = ErrBadTag
+		err = tagError(c)
+		return
+
+	}
+}
+
+// readTime reads a timestamp extension
+func readTime(r Reader) (t time.Time, err error) {
+	var bs [16]byte
+	var dat []byte
+	var etype int8
+	dat, etype, err = readExt(r, bs[:])
+	if err != nil {
 		return
+	}
+	if etype != timeExt {
+		err = ErrNotTime
+		return
+	}
+	return extTime(dat)
+}
+
+// skip reads and discards the leading value in 'r',
+// including the values in arrays and maps
+func skip(r Reader) error { return skipDepth(r, 0) }
 
+// skipDepth is skip for a value nested
+// in 'depth' arrays and maps
+func skipDepth(r Reader, depth int) error {
+	var ls [4]byte
+	c, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	size, lb, extra, mul, err := tagShape(c)
+	if err != nil {
+		return err
+	}
+	if lb > 0 {
+		_, err = io.ReadFull(r, ls[:lb])
+		if err != nil {
+			return err
+		}
+		size = beUint32(ls[:lb])
+		err = checkSize(c, size)
+		if err != nil {
+			return err
+		}
+	}
+	if mul == 0 {
+		n := int64(size) + int64(extra)
+		m, err := io.CopyN(ioutil.Discard, r, n)
+		if m < n && err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	err = checkDepth(depth + 1)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < uint64(size)*uint64(mul); i++ {
+		err = skipDepth(r, depth+1)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
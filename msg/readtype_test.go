@@ -354,3 +354,67 @@ func TestReadFloatBytes(t *testing.T) {
 		}
 	}
 }
+
+func TestReadArrayHeader(t *testing.T) {
+	testvals := []uint32{0, 3, 15, 16, 300, 70000}
+	buf := bytes.NewBuffer(nil)
+	for _, x := range testvals {
+		writeArrayHeader(buf, x)
+	}
+	bts := buf.Bytes()
+
+	var nr int
+	for i, x := range testvals {
+		sz, n, err := readArrayHeaderBytes(bts[nr:])
+		if err != nil {
+			t.Fatalf("Test case %d: %s", i, err)
+		}
+		if sz != x {
+			t.Errorf("Test case %d: readArrayHeaderBytes: %d != %d", i, sz, x)
+		}
+		nr += n
+
+		sz, err = readArrayHeader(buf)
+		if err != nil {
+			t.Fatalf("Test case %d: %s", i, err)
+		}
+		if sz != x {
+			t.Errorf("Test case %d: readArrayHeader: %d != %d", i, sz, x)
+		}
+	}
+	if nr != len(bts) {
+		t.Errorf("Read %d bytes; expected %d", nr, len(bts))
+	}
+}
+
+func TestReadMapHeader(t *testing.T) {
+	testvals := []uint32{0, 3, 15, 16, 300, 70000}
+	buf := bytes.NewBuffer(nil)
+	for _, x := range testvals {
+		writeMapHeader(buf, x)
+	}
+	bts := buf.Bytes()
+
+	var nr int
+	for i, x := range testvals {
+		sz, n, err := readMapHeaderBytes(bts[nr:])
+		if err != nil {
+			t.Fatalf("Test case %d: %s", i, err)
+		}
+		if sz != x {
+			t.Errorf("Test case %d: readMapHeaderBytes: %d != %d", i, sz, x)
+		}
+		nr += n
+
+		sz, err = readMapHeader(buf)
+		if err != nil {
+			t.Fatalf("Test case %d: %s", i, err)
+		}
+		if sz != x {
+			t.Errorf("Test case %d: readMapHeader: %d != %d", i, sz, x)
+		}
+	}
+	if nr != len(bts) {
+		t.Errorf("Read %d bytes; expected %d", nr, len(bts))
+	}
+}
@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/A2B-Bikeshare/go-flux/msg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is the file format used by a Dir registry.
+type Format int
+
+const (
+	// Binary files contain the output of msg.Schema.Encode.
+	Binary Format = iota
+	// JSON files contain the JSON form of a msg.Schema
+	// (see msg.Schema.MarshalJSON).
+	JSON
+)
+
+// extension returns the file extension for the format
+func (f Format) extension() string {
+	if f == JSON {
+		return ".json"
+	}
+	return ".flux"
+}
+
+// Dir is a Registry that keeps each Entry in a file named
+// {name}@{version}.flux (Binary) or {name}@{version}.json (JSON)
+// in a directory. It is safe for concurrent use, but not
+// for use by more than one process at a time.
+type Dir struct {
+	path   string
+	format Format
+	mem    *Memory
+}
+
+// OpenDir loads every Schema file (in either format) in the directory 'path',
+// and returns a Dir that writes new entries to 'path' in 'format'.
+func OpenDir(path string, format Format) (*Dir, error) {
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dir{path: path, format: format, mem: NewMemory()}
+	var es []*Entry
+	for _, fi := range fis {
+		if fi.IsDir() {
+			continue
+		}
+		name, version, format, ok := parseFilename(fi.Name())
+		if !ok {
+			continue
+		}
+		s, err := readSchema(filepath.Join(path, fi.Name()), format)
+		if err != nil {
+			return nil, err
+		}
+		if s.Version() != version {
+			return nil, fmt.Errorf("registry: %s contains a Schema with version %d", fi.Name(), s.Version())
+		}
+		es = append(es, &Entry{Name: name, Version: version, Fingerprint: s.Fingerprint(), Schema: s})
+	}
+	// add in version order so that fingerprints
+	// resolve to the earliest registration
+	sort.Stable(byVersion(es))
+	for _, e := range es {
+		if _, err := d.mem.Lookup(e.Name, e.Version); err == nil {
+			return nil, fmt.Errorf("registry: %s@%d is stored more than once", e.Name, e.Version)
+		}
+		d.mem.add(e)
+	}
+	return d, nil
+}
+
+// Register implements Registry.Register. The new
+// Entry is written to disk before it is returned.
+func (d *Dir) Register(name string, s msg.Schema) (*Entry, error) {
+	d.mem.lock.Lock()
+	defer d.mem.lock.Unlock()
+	e, isnew, err := d.mem.check(name, s)
+	if err != nil || !isnew {
+		return e, err
+	}
+	err = d.write(e)
+	if err != nil {
+		return nil, err
+	}
+	d.mem.add(e)
+	return e, nil
+}
+
+// Lookup implements Registry.Lookup.
+func (d *Dir) Lookup(name string, version int) (*Entry, error) { return d.mem.Lookup(name, version) }
+
+// Latest implements Registry.Latest.
+func (d *Dir) Latest(name string) (*Entry, error) { return d.mem.Latest(name) }
+
+// Fingerprint implements Registry.Fingerprint.
+func (d *Dir) Fingerprint(f msg.Fingerprint) (*Entry, error) { return d.mem.Fingerprint(f) }
+
+// write atomically writes an Entry to its file
+func (d *Dir) write(e *Entry) error {
+	buf := bytes.NewBuffer(nil)
+	if d.format == JSON {
+		bts, err := json.MarshalIndent(&e.Schema, "", "\t")
+		if err != nil {
+			return err
+		}
+		buf.Write(bts)
+		buf.WriteByte('\n')
+	} else {
+		e.Schema.Encode(buf)
+	}
+
+	f, err := ioutil.TempFile(d.path, "."+e.Name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(d.path, e.Name+"@"+strconv.Itoa(e.Version)+d.format.extension()))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// readSchema reads a Schema file
+func readSchema(path string, format Format) (msg.Schema, error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s msg.Schema
+	if format == JSON {
+		err = json.Unmarshal(bts, &s)
+	} else {
+		err = s.Decode(bytes.NewReader(bts))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("registry: %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// parseFilename splits {name}@{version}.{ext}
+func parseFilename(fname string) (name string, version int, format Format, ok bool) {
+	ext := filepath.Ext(fname)
+	switch ext {
+	case ".flux":
+		format = Binary
+	case ".json":
+		format = JSON
+	default:
+		return
+	}
+	base := strings.TrimSuffix(fname, ext)
+	at := strings.LastIndex(base, "@")
+	if at < 0 {
+		return
+	}
+	name = base[:at]
+	version, err := strconv.Atoi(base[at+1:])
+	if err != nil || version < 0 || !validName(name) {
+		return
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,190 @@
+/*
+Package registry stores msg.Schemas under a name and version,
+so that producers and consumers can share them by reference.
+
+The version of a registered Schema is its msg.Schema.Version(), and
+each new version of a name must be compatible with the latest
+one (see msg.Schema.Compatible). Entries can be looked up by name and
+version, or by the msg.Fingerprint carried in a message envelope.
+
+A Memory registry keeps its entries in memory; a Dir registry also
+persists each entry to a file in a directory, so that it can be shared
+and reloaded.
+*/
+package registry
+
+import (
+	"errors"
+	"github.com/A2B-Bikeshare/go-flux/msg"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNotFound is returned when there is no matching entry.
+	ErrNotFound = errors.New("Schema not found.")
+	// ErrVersionExists is returned when a different Schema has
+	// already been registered under the same name and version.
+	ErrVersionExists = errors.New("A different Schema is registered with this name and version.")
+	// ErrOldVersion is returned when a Schema is older than
+	// the latest version registered under its name.
+	ErrOldVersion = errors.New("Schema is older than the latest registered version.")
+	// ErrBadName is returned for names that are empty or contain
+	// characters other than letters, digits, '-', '_', and '.'.
+	ErrBadName = errors.New("Bad Schema name.")
+)
+
+// Entry is a Schema registered under a name and version.
+type Entry struct {
+	Name        string
+	Version     int
+	Fingerprint msg.Fingerprint
+	Schema      msg.Schema
+}
+
+// Registry is a collection of named, versioned Schemas.
+type Registry interface {
+	// Register adds 's' under 'name' with version s.Version(). Registering
+	// an identical Schema again returns the existing Entry. Otherwise, 's'
+	// must be newer than and compatible with the latest version of 'name',
+	// or Register returns an error (possibly a *msg.CompatError).
+	Register(name string, s msg.Schema) (*Entry, error)
+
+	// Lookup returns the Entry with the given name and version.
+	Lookup(name string, version int) (*Entry, error)
+
+	// Latest returns the Entry with the highest version for 'name'.
+	Latest(name string) (*Entry, error)
+
+	// Fingerprint returns the Entry for the Schema with Fingerprint 'f'.
+	// If identical Schemas are registered under different names,
+	// the first one registered is returned.
+	Fingerprint(f msg.Fingerprint) (*Entry, error)
+}
+
+// Memory is an in-memory Registry. It is safe
+// for concurrent use. The zero value is not usable;
+// use NewMemory.
+type Memory struct {
+	lock  sync.RWMutex
+	names map[string][]*Entry // sorted by version
+	fps   map[msg.Fingerprint]*Entry
+}
+
+// NewMemory returns an empty Memory registry.
+func NewMemory() *Memory {
+	return &Memory{
+		names: make(map[string][]*Entry),
+		fps:   make(map[msg.Fingerprint]*Entry),
+	}
+}
+
+// Register implements Registry.Register.
+func (m *Memory) Register(name string, s msg.Schema) (*Entry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, isnew, err := m.check(name, s)
+	if err != nil || !isnew {
+		return e, err
+	}
+	m.add(e)
+	return e, nil
+}
+
+// check validates a new registration, returning the
+// Entry to add (or the existing one, with isnew == false)
+// m.lock must be held
+func (m *Memory) check(name string, s msg.Schema) (e *Entry, isnew bool, err error) {
+	if !validName(name) {
+		return nil, false, ErrBadName
+	}
+	e = &Entry{Name: name, Version: s.Version(), Fingerprint: s.Fingerprint(), Schema: s}
+	es := m.names[name]
+	if len(es) == 0 {
+		return e, true, nil
+	}
+	for _, old := range es {
+		if old.Version == e.Version {
+			if old.Fingerprint == e.Fingerprint {
+				return old, false, nil
+			}
+			return nil, false, ErrVersionExists
+		}
+	}
+	latest := es[len(es)-1]
+	if e.Version < latest.Version {
+		return nil, false, ErrOldVersion
+	}
+	err = s.Compatible(&latest.Schema)
+	if err != nil {
+		return nil, false, err
+	}
+	return e, true, nil
+}
+
+// add inserts an Entry without any checks
+// m.lock must be held
+func (m *Memory) add(e *Entry) {
+	es := append(m.names[e.Name], e)
+	sort.Sort(byVersion(es))
+	m.names[e.Name] = es
+	if _, ok := m.fps[e.Fingerprint]; !ok {
+		m.fps[e.Fingerprint] = e
+	}
+}
+
+// Lookup implements Registry.Lookup.
+func (m *Memory) Lookup(name string, version int) (*Entry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, e := range m.names[name] {
+		if e.Version == version {
+			return e, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Latest implements Registry.Latest.
+func (m *Memory) Latest(name string) (*Entry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	es := m.names[name]
+	if len(es) == 0 {
+		return nil, ErrNotFound
+	}
+	return es[len(es)-1], nil
+}
+
+// Fingerprint implements Registry.Fingerprint.
+func (m *Memory) Fingerprint(f msg.Fingerprint) (*Entry, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	e, ok := m.fps[f]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+type byVersion []*Entry
+
+func (b byVersion) Len() int           { return len(b) }
+func (b byVersion) Less(i, j int) bool { return b[i].Version < b[j].Version }
+func (b byVersion) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+func validName(name string) bool {
+	if name == "" || strings.Trim(name, ".") == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"github.com/A2B-Bikeshare/go-flux/msg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var (
+	teleV0 = msg.Schema{
+		{Name: "name", T: msg.String},
+		{Name: "val", T: msg.Float},
+	}
+	teleV1 = msg.Schema{
+		{Name: "name", T: msg.String},
+		{Name: "val", T: msg.Float},
+		{Name: "charge", T: msg.Int, Since: 1, Default: int64(-1)},
+	}
+)
+
+func testRegistry(t *testing.T, r Registry) {
+	e0, err := r.Register("tele", teleV0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e0.Version != 0 || e0.Fingerprint != teleV0.Fingerprint() {
+		t.Errorf("Bad entry: %v", e0)
+	}
+	e1, err := r.Register("tele", teleV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e1.Version != 1 {
+		t.Errorf("Expected version 1; got %d", e1.Version)
+	}
+
+	// re-registering is a no-op
+	e, err := r.Register("tele", teleV0)
+	if err != nil || e != e0 {
+		t.Errorf("Re-registering returned %v, %v", e, err)
+	}
+
+	_, err = r.Register("tele", msg.Schema{{Name: "name", T: msg.String}, {Name: "val", T: msg.Int}})
+	if err != ErrVersionExists {
+		t.Errorf("Expected ErrVersionExists; got %v", err)
+	}
+	bad := append(teleV1[:3:3], msg.Object{Name: "x", T: msg.Int, Since: 2})
+	_, err = r.Register("tele", bad)
+	if _, ok := err.(*msg.CompatError); !ok {
+		t.Errorf("Expected *msg.CompatError; got %v", err)
+	}
+	_, err = r.Register("../tele", teleV0)
+	if err != ErrBadName {
+		t.Errorf("Expected ErrBadName; got %v", err)
+	}
+
+	e, err = r.Lookup("tele", 0)
+	if err != nil || e != e0 {
+		t.Errorf("Lookup returned %v, %v", e, err)
+	}
+	e, err = r.Latest("tele")
+	if err != nil || e != e1 {
+		t.Errorf("Latest returned %v, %v", e, err)
+	}
+	e, err = r.Fingerprint(teleV1.Fingerprint())
+	if err != nil || e != e1 {
+		t.Errorf("Fingerprint returned %v, %v", e, err)
+	}
+	_, err = r.Lookup("tele", 5)
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound; got %v", err)
+	}
+	_, err = r.Latest("none")
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound; got %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testRegistry(t, NewMemory())
+}
+
+func TestDir(t *testing.T) {
+	for _, format := range []Format{Binary, JSON} {
+		path, err := ioutil.TempDir("", "registry")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(path)
+
+		d, err := OpenDir(path, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testRegistry(t, d)
+
+		if _, err := os.Stat(filepath.Join(path, "tele@1"+format.extension())); err != nil {
+			t.Error(err)
+		}
+
+		// reload from disk
+		d, err = OpenDir(path, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := d.Latest("tele")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Version != 1 || !reflect.DeepEqual(e.Schema, teleV1) {
+			t.Errorf("Reloaded %v; expected %v", e.Schema, teleV1)
+		}
+		e, err = d.Fingerprint(teleV0.Fingerprint())
+		if err != nil || e.Version != 0 {
+			t.Errorf("Fingerprint returned %v, %v", e, err)
+		}
+	}
+}
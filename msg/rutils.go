@@ -2,6 +2,7 @@ package msg
 
 import (
 	"reflect"
+	"time"
 	"unsafe"
 )
 
@@ -115,6 +116,97 @@ func readUintBytes(p []byte) (i uint64, n int, err error) {
 	}
 }
 
+//array length, bytes read, error
+func readArrayHeaderBytes(p []byte) (sz uint32, n int, err error) {
+	np := len(p)
+	if np == 0 {
+		err = ErrShortBytes
+		return
+	}
+	c := p[0]
+	n = 1
+
+	//fixarray
+	if (c & 0xf0) == 0x90 {
+		sz = uint32(c & 0xf)
+		return
+	}
+
+	switch c {
+	case marray16:
+		if np < 3 {
+			err = ErrShortBytes
+			return
+		}
+		sz = uint32(ruint16(p[1:]))
+		n += 2
+	case marray32:
+		if np < 5 {
+			err = ErrShortBytes
+			return
+		}
+		sz = uint32(ruint32(p[1:]))
+		n += 4
+	default:
+		err = ErrBadTag
+		return
+	}
+	err = checkSize(c, sz)
+	return
+}
+
+//map length, bytes read, error
+func readMapHeaderBytes(p []byte) (sz uint32, n int, err error) {
+	np := len(p)
+	if np == 0 {
+		err = ErrShortBytes
+		return
+	}
+	c := p[0]
+	n = 1
+
+	//fixmap
+	if (c & 0xf0) == 0x80 {
+		sz = uint32(c & 0xf)
+		return
+	}
+
+	switch c {
+	case mmap16:
+		if np < 3 {
+			err = ErrShortBytes
+			return
+		}
+		sz = uint32(ruint16(p[1:]))
+		n += 2
+	case mmap32:
+		if np < 5 {
+			err = ErrShortBytes
+			return
+		}
+		sz = uint32(ruint32(p[1:]))
+		n += 4
+	default:
+		err = ErrBadTag
+		return
+	}
+	err = checkSize(c, sz)
+	return
+}
+
+func readNilBytes(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		err = ErrShortBytes
+		return
+	}
+	if p[0] != mnil {
+		err = ErrBadTag
+		return
+	}
+	n = 1
+	return
+}
+
 func readBoolBytes(p []byte) (b bool, n int, err error) {
 	if len(p) == 0 {
 		err = ErrShortBytes
@@ -311,6 +403,9 @@ func readStringZeroCopy(p []byte) (s string, n int, err error) {
 			return
 		}
 
+		if strlen == 0 {
+			return
+		}
 		sh := &reflect.StringHeader{Data: uintptr(unsafe.Pointer(&p[1])), Len: strlen}
 		s = *(*string)(unsafe.Pointer(sh))
 		n += strlen
@@ -348,10 +443,17 @@ func readStringZeroCopy(p []byte) (s string, n int, err error) {
 		err = ErrBadTag
 		return
 	}
+	err = checkSize(c, uint32(strlen))
+	if err != nil {
+		return
+	}
 	if np < n+strlen {
 		err = ErrShortBytes
 		return
 	}
+	if strlen == 0 {
+		return
+	}
 	//read from p[n] into *StringHeader; unsafe cast to string
 	sh := &reflect.StringHeader{Data: uintptr(unsafe.Pointer(&p[n])), Len: strlen}
 	s = *(*string)(unsafe.Pointer(sh))
@@ -394,6 +496,10 @@ func readBinZeroCopy(p []byte) (dat []byte, n int, err error) {
 		err = ErrShortBytes
 		return
 	}
+	err = checkSize(c, uint32(binlen))
+	if err != nil {
+		return
+	}
 	if np < n+binlen {
 		err = ErrShortBytes
 		return
@@ -472,17 +578,181 @@ func readExtZeroCopy(p []byte) (dat []byte, etype int8, n int, err error) {
 		datlen = int(uint32(p[1]))
 		n++
 	case mext16:
+		if np < 4 {
+			err = ErrShortBytes
+			return
+		}
 		datlen = int(uint32(p[2]) | (uint32(p[1]) << 8))
 		n += 2
 	case mext32:
+		if np < 6 {
+			err = ErrShortBytes
+			return
+		}
 		datlen = int(uint32(p[4]) | (uint32(p[3]) << 8) | (uint32(p[2]) << 16) | (uint32(p[1]) << 24))
 		n += 4
 	default:
+		err = ErrBadTag
+		return
+	}
+	err = checkSize(c, uint32(datlen))
+	if err != nil {
+		return
+	}
+	if np < n+1+datlen {
 		err = ErrShortBytes
 		return
 	}
 	etype = int8(p[n])
 	n++
 	dat = p[n : n+datlen]
+	n += datlen
+	return
+}
+
+// readTimeBytes reads a timestamp extension from 'p'
+func readTimeBytes(p []byte) (t time.Time, n int, err error) {
+	var dat []byte
+	var etype int8
+	dat, etype, n, err = readExtZeroCopy(p)
+	if err != nil {
+		return
+	}
+	if etype != timeExt {
+		err = ErrNotTime
+		return
+	}
+	t, err = extTime(dat)
+	return
+}
+
+// extTime decodes the data of a 32-, 64-, or
+// 96-bit timestamp extension
+func extTime(dat []byte) (t time.Time, err error) {
+	switch len(dat) {
+	case 4:
+		t = time.Unix(int64(ruint32(dat)), 0).UTC()
+	case 8:
+		d := ruint64(dat)
+		t = time.Unix(int64(d&(1<<34-1)), int64(d>>34)).UTC()
+	case 12:
+		t = time.Unix(rint64(dat[4:]), int64(ruint32(dat))).UTC()
+	default:
+		err = ErrNotTime
+	}
+	return
+}
+
+// skipBytes returns the length of the leading value in 'p',
+// including the values in arrays and maps
+func skipBytes(p []byte) (n int, err error) { return skipBytesDepth(p, 0) }
+
+// skipBytesDepth is skipBytes for a value
+// nested in 'depth' arrays and maps
+func skipBytesDepth(p []byte, depth int) (n int, err error) {
+	if len(p) < 1 {
+		err = ErrShortBytes
+		return
+	}
+	size, lb, extra, mul, err := tagShape(p[0])
+	if err != nil {
+		return
+	}
+	n = 1
+	if lb > 0 {
+		if len(p) < 1+lb {
+			err = ErrShortBytes
+			return
+		}
+		size = beUint32(p[1 : 1+lb])
+		n += lb
+		err = checkSize(p[0], size)
+		if err != nil {
+			return
+		}
+	}
+	if mul == 0 {
+		if uint64(len(p)-n) < uint64(size)+uint64(extra) {
+			err = ErrShortBytes
+			return
+		}
+		n += int(size) + int(extra)
+		return
+	}
+	err = checkDepth(depth + 1)
+	if err != nil {
+		return
+	}
+	var m int
+	for i := uint64(0); i < uint64(size)*uint64(mul); i++ {
+		m, err = skipBytesDepth(p[n:], depth+1)
+		if err != nil {
+			return
+		}
+		n += m
+	}
 	return
 }
+
+// nextType returns the Type of the leading value in 'p'
+func nextType(p []byte) (t Type, err error) {
+	if len(p) < 1 {
+		err = ErrShortBytes
+		return
+	}
+	c := p[0]
+	switch {
+	case c <= mfixintMAX || c >= mnfixint:
+		return Int, nil
+	case c <= mfixmapMAX:
+		return Map, nil
+	case c <= mfixarrayMAX:
+		return Array, nil
+	case c <= mfixstrMAX:
+		return String, nil
+	}
+
+	// offset of the extension type
+	var eoff int
+	switch c {
+	case mnil:
+		err = ErrNil
+	case mfalse, mtrue:
+		t = Bool
+	case mbin8, mbin16, mbin32:
+		t = Bin
+	case mfloat32, mfloat64:
+		t = Float
+	case muint8, muint16, muint32, muint64:
+		t = Uint
+	case mint8, mint16, mint32, mint64:
+		t = Int
+	case mstr8, mstr16, mstr32:
+		t = String
+	case marray16, marray32:
+		t = Array
+	case mmap16, mmap32:
+		t = Map
+	case mfixext1, mfixext2, mfixext4, mfixext8, mfixext16:
+		eoff = 1
+	case mext8:
+		eoff = 2
+	case mext16:
+		eoff = 3
+	case mext32:
+		eoff = 5
+	default:
+		err = ErrBadTag
+	}
+	if eoff == 0 {
+		return
+	}
+	if len(p) <= eoff {
+		err = ErrShortBytes
+		return
+	}
+	if p[eoff] == mtimeExt {
+		return Time, nil
+	}
+	return Ext, nil
+}
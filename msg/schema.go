@@ -3,8 +3,11 @@ package msg
 import (
 	"encoding/base64"
 	"errors"
+	"math"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 )
 
 const (
@@ -26,28 +29,38 @@ var (
 	ErrBadArgs = errors.New("Bad arguments.")
 	//ErrShortSlice is returned when an argument slice was too short.
 	ErrShortSlice = errors.New("Slice too short.")
+	// ErrBadValue is returned when an encoded value has
+	// the right type but is meaningless (e.g. a negative length).
+	ErrBadValue = errors.New("Bad value.")
+	// ErrNestedVersion is returned for a Struct object
+	// whose Schema has an object with a Since.
+	ErrNestedVersion = errors.New("Nested object has a version.")
 )
 
 var (
 	exttype = []byte("extension_type")
 	data    = []byte("data")
+	null    = []byte("null")
 	bpool   *sync.Pool
 )
 
 func init() {
 	bpool = new(sync.Pool)
-	bpool.New = func() interface{} { return make([]byte, 64) }
+	bpool.New = func() interface{} {
+		b := make([]byte, 0, 64)
+		return &b
+	}
 }
 
-func newBytes() []byte {
-	bts, ok := bpool.Get().([]byte)
-	if !ok {
-		return bpool.New().([]byte)
-	}
-	return bts[0:0]
+// newBytes returns an empty pooled buffer. (The pool
+// holds pointers so that putting one back doesn't allocate.)
+func newBytes() *[]byte {
+	bp := bpool.Get().(*[]byte)
+	*bp = (*bp)[:0]
+	return bp
 }
 
-func putBytes(p []byte) { bpool.Put(p) }
+func putBytes(bp *[]byte) { bpool.Put(bp) }
 
 // Encoder wraps the Encode() method.
 // Encode should marshal information from the calling
@@ -56,6 +69,15 @@ type Encoder interface {
 	Encode(w Writer) error
 }
 
+// Appender wraps the Append() method.
+// Append should append the encoding of the
+// calling object to 'b' and return the extended
+// slice. Its output should be identical to that
+// of Encode, for types that implement both.
+type Appender interface {
+	Append(b []byte) ([]byte, error)
+}
+
 // Decoder wraps the Decode() method.
 // Decode should overwrite the information
 // contained in the calling object by
@@ -76,15 +98,63 @@ type SelfMessager interface {
 // Schema represents an ordering of named objects
 type Schema []Object
 
+// optional objects have this bit set in their encoded type
+const optionalFlag = 1 << 7
+
+// objects with a version (Since > 0) have this bit set in their encoded type
+const versionFlag = 1 << 6
+
 //Object represents a named object of known type
 type Object struct {
 	Name string
 	T    Type
+	// Optional objects may be encoded as 'nil' (see WriteNil)
+	// instead of a value of type T. Optional objects that are
+	// nil decode to a nil interface{} and are written as JSON null.
+	Optional bool
+	// Elem is the Type of each element of an Array object,
+	// or of each value in a Map object. Elem may not be
+	// Array, Struct, or Map.
+	Elem Type
+	// Schema describes the fields of a Struct object.
+	Schema *Schema
+	// Since is the version of the Schema that added this object.
+	// Objects with Since > 0 must be appended after the objects
+	// of earlier versions (see (*Schema).Compatible).
+	//
+	// Readers recognize an older message by reaching its end
+	// before a newer object, so each message must be read on its
+	// own: from a byte slice or Reader that ends where the message
+	// does, not from a stream of back-to-back messages. For the
+	// same reason, only top-level objects may have a Since; a
+	// nested Struct is usually followed by other objects, so the
+	// end of its message is not the end of the Struct. Schemas
+	// that break this rule fail with ErrNestedVersion.
+	Since int
+	// Default is the value of an object with Since > 0 when it is
+	// missing from a message written with an earlier version of
+	// the Schema. It must have the type EncodeSlice expects for T,
+	// or be nil if the object is Optional.
+	Default interface{}
+}
+
+// emptySchema stands in for a nil Object.Schema
+var emptySchema = &Schema{}
+
+// sub returns the child Schema of a Struct object
+func (o *Object) sub() *Schema {
+	if o.Schema == nil {
+		return emptySchema
+	}
+	return o.Schema
 }
 
 // Encode implements the Encoder interface
 func (s *Schema) Encode(w Writer) {
-	// Schemas are encoded as a length followed by Uint-String pairs representing Type and Name
+	// Schemas are encoded as a length followed by Uint-String pairs representing Type and Name.
+	// The high bit of the Type is set for Optional objects. Arrays and Maps are followed by their Elem Type,
+	// and Structs are followed by their encoded Schema. Objects with a version have the second-highest
+	// bit of the Type set, and end with their Since version and Default value (or nil).
 
 	// Write Length
 	n := len(*s)
@@ -92,26 +162,62 @@ func (s *Schema) Encode(w Writer) {
 
 	// Write Objects
 	for _, o := range *s {
-		WriteUint(w, uint64(o.T))
+		t := uint64(o.T)
+		if o.Optional {
+			t |= optionalFlag
+		}
+		if o.Since > 0 {
+			t |= versionFlag
+		}
+		WriteUint(w, t)
 		WriteString(w, o.Name)
+		switch o.T {
+		case Array, Map:
+			WriteUint(w, uint64(o.Elem))
+		case Struct:
+			o.sub().Encode(w)
+		}
+		if o.Since > 0 {
+			WriteUint(w, uint64(o.Since))
+			o.encodeDefault(w)
+		}
 	}
 }
 
 // Decode implements the Decoder interface
 // If Decode returns an error, the Schema remains unchanged.
-func (s *Schema) Decode(r Reader) error {
+// Schemas with more Objects or more deeply nested Structs
+// than the Limits allow return ErrTooLarge (see SetLimits),
+// a negative number of Objects returns ErrBadValue, and an
+// Array or Map of a non-scalar Elem (Array, Map or Struct)
+// returns ErrTypeNotSupported.
+func (s *Schema) Decode(r Reader) error { return s.decode(r, 0) }
+
+// decode is Decode for a Schema nested in 'depth' Structs
+func (s *Schema) decode(r Reader, depth int) error {
 	// read length
 	n, err := ReadInt(r)
 	if err != nil {
 		return err
 	}
+	if n < 0 {
+		return ErrBadValue
+	}
+	if n > math.MaxUint32 || tooLarge(uint32(n), currentLimits().MaxArray) {
+		return ErrTooLarge
+	}
 
 	var name string
 	var t uint64
+	var flags uint64
 
-	// read type-name pairs
-	os := make([]Object, n)
+	// read type-name pairs; 'n' is only a claim
+	// until the Objects have been read
+	os := make([]Object, prealloc(uint32(n)))
 	for i := 0; i < int(n); i++ {
+		if i == len(os) {
+			os = append(os, Object{})
+		}
 		t, err = ReadUint(r)
 		if err != nil {
 			return err
@@ -121,7 +227,42 @@ func (s *Schema) Decode(r Reader) error {
 			return err
 		}
 
-		os[i] = Object{T: Type(uint8(t)), Name: name}
+		flags = t & (optionalFlag | versionFlag)
+		os[i] = Object{T: Type(uint8(t &^ flags)), Name: name, Optional: flags&optionalFlag != 0}
+		switch os[i].T {
+		case Array, Map:
+			t, err = ReadUint(r)
+			if err != nil {
+				return err
+			}
+			os[i].Elem = Type(uint8(t))
+		case Struct:
+			err = checkDepth(depth + 1)
+			if err != nil {
+				return err
+			}
+			sub := new(Schema)
+			err = sub.decode(r, depth+1)
+			if err != nil {
+				return err
+			}
+			os[i].Schema = sub
+		}
+		err = os[i].checkElem()
+		if err != nil {
+			return err
+		}
+		if flags&versionFlag != 0 {
+			t, err = ReadUint(r)
+			if err != nil {
+				return err
+			}
+			os[i].Since = int(t)
+			os[i].Default, err = os[i].decodeDefault(r)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	*s = (Schema)(os)
 	return nil
@@ -137,6 +278,9 @@ func (s *Schema) Decode(r Reader) error {
 //  bool
 //  string
 //  []byte (binary)
+//  []float64, []int64, []uint64, []bool, []string, []time.Time (arrays)
+//  map[string]string, map[string]float64, map[string]int64 (maps)
+//  time.Time
 //
 // Note that even though MakeSchema accepts non-64-bit types, the types used in
 // Encode() *must* be 64-bit (float64, int64, uint64)
@@ -162,6 +306,26 @@ func MakeSchema(names []string, types []interface{}) (s *Schema, err error) {
 			o[i].T = String
 		case []byte:
 			o[i].T = Bin
+		case []float64:
+			o[i].T, o[i].Elem = Array, Float
+		case []int64:
+			o[i].T, o[i].Elem = Array, Int
+		case []uint64:
+			o[i].T, o[i].Elem = Array, Uint
+		case []bool:
+			o[i].T, o[i].Elem = Array, Bool
+		case []string:
+			o[i].T, o[i].Elem = Array, String
+		case map[string]string:
+			o[i].T, o[i].Elem = Map, String
+		case map[string]float64:
+			o[i].T, o[i].Elem = Map, Float
+		case map[string]int64:
+			o[i].T, o[i].Elem = Map, Int
+		case time.Time:
+			o[i].T = Time
+		case []time.Time:
+			o[i].T, o[i].Elem = Array, Time
 		default:
 			return nil, ErrTypeNotSupported
 		}
@@ -172,6 +336,10 @@ func MakeSchema(names []string, types []interface{}) (s *Schema, err error) {
 
 // DecodeToSlice reads values from a msg.Reader into a []interface{}, provided that
 // the provided slice is long enough. (If not, ErrShortSlice is returned.)
+// Struct objects are decoded into nested []interface{}.
+// If the message was written with an older version of the Schema, objects
+// added since then (see Object.Since) are set to their Default values.
+// Malformed messages are reported with a *TagError or *ShortError.
 // DecodeToSlice is a higher-performance alternative to DecodeToMap.
 func (s *Schema) DecodeToSlice(r Reader, v []interface{}) error {
 	if len(v) < len(*s) {
@@ -182,13 +350,36 @@ func (s *Schema) DecodeToSlice(r Reader, v []interface{}) error {
 	var err error      //error
 
 	for i, o := range *s {
+		// older messages end before newer objects
+		if o.Since > 0 {
+			var eof bool
+			eof, err = atEOF(r)
+			if err != nil {
+				return err
+			}
+			if eof {
+				s.defaultsToSlice(i, v)
+				return nil
+			}
+		}
+		if o.Optional {
+			var isnil bool
+			isnil, err = readIsNil(r)
+			if err != nil {
+				return err
+			}
+			if isnil {
+				v[i] = nil
+				continue
+			}
+		}
 		t = o.T
 		switch t {
 
 		case String:
 			ns, err = readString(r)
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			v[i] = ns
 			continue
@@ -196,7 +387,7 @@ func (s *Schema) DecodeToSlice(r Reader, v []interface{}) error {
 		case Int:
 			ns, err = readInt(r)
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			v[i] = ns
 			continue
@@ -204,7 +395,7 @@ func (s *Schema) DecodeToSlice(r Reader, v []interface{}) error {
 		case Uint:
 			ns, err = readUint(r)
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			v[i] = ns
 			continue
@@ -212,7 +403,15 @@ func (s *Schema) DecodeToSlice(r Reader, v []interface{}) error {
 		case Float:
 			ns, err = readFloat(r)
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
+			}
+			v[i] = ns
+			continue
+
+		case Bool:
+			ns, err = readBool(r)
+			if err != nil {
+				return readError(r, t, o.Name, err)
 			}
 			v[i] = ns
 			continue
@@ -222,7 +421,7 @@ func (s *Schema) DecodeToSlice(r Reader, v []interface{}) error {
 			var bs [32]byte //try to avoid allocations for small bins
 			dat, err = readBin(r, bs[:32])
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			v[i] = dat
 			continue
@@ -233,11 +432,45 @@ func (s *Schema) DecodeToSlice(r Reader, v []interface{}) error {
 			var bs [32]byte //try to avoid allocations for small exts
 			dat, etype, err = readExt(r, bs[:32])
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			v[i] = &PackExt{EType: etype, Data: dat}
 			continue
 
+		case Time:
+			ns, err = readTime(r)
+			if err != nil {
+				return readError(r, t, o.Name, err)
+			}
+			v[i] = ns
+			continue
+
+		case Array:
+			ns, err = readArray(r, o.Elem)
+			if err != nil {
+				return readError(r, t, o.Name, err)
+			}
+			v[i] = ns
+			continue
+
+		case Map:
+			ns, err = readMap(r, o.Elem)
+			if err != nil {
+				return readError(r, t, o.Name, err)
+			}
+			v[i] = ns
+			continue
+
+		case Struct:
+			sub := o.sub()
+			sv := make([]interface{}, len(*sub))
+			err = sub.DecodeToSlice(r, sv)
+			if err != nil {
+				return readError(r, t, o.Name, err)
+			}
+			v[i] = sv
+			continue
+
 		default:
 			err = ErrIncorrectType
 			return err
@@ -253,19 +486,36 @@ func (s *Schema) DecodeToSlice(r Reader, v []interface{}) error {
 // as 'p' remains untouched. This is both "dangerous" and highly performant. Use
 // at your own risk.
 func (s *Schema) DecodeToSliceZeroCopy(p []byte, v []interface{}) error {
+	_, err := s.decodeToSliceZeroCopy(p, v)
+	return s.explain(p, err)
+}
+
+// decodeToSliceZeroCopy implements DecodeToSliceZeroCopy,
+// and also returns the number of bytes read.
+func (s *Schema) decodeToSliceZeroCopy(p []byte, v []interface{}) (int, error) {
 	var nn int //total bytewise progress
 
 	//check for sanity
 	if len(v) < len(*s) {
-		return ErrShortSlice
+		return nn, ErrShortSlice
 	}
 
 	for i, o := range *s {
+		// older messages end before newer objects
+		if o.Since > 0 && nn >= len(p) {
+			s.defaultsToSlice(i, v)
+			return nn, nil
+		}
+		if o.Optional && IsNil(p[nn:]) {
+			v[i] = nil
+			nn++
+			continue
+		}
 		switch o.T {
 		case String:
 			s, n, err := readStringZeroCopy(p[nn:])
 			if err != nil {
-				return err
+				return nn, err
 			}
 			v[i] = s
 			nn += n
@@ -273,7 +523,7 @@ func (s *Schema) DecodeToSliceZeroCopy(p []byte, v []interface{}) error {
 		case Int:
 			in, n, err := readIntBytes(p[nn:])
 			if err != nil {
-				return err
+				return nn, err
 			}
 			v[i] = in
 			nn += n
@@ -281,7 +531,7 @@ func (s *Schema) DecodeToSliceZeroCopy(p []byte, v []interface{}) error {
 		case Uint:
 			uin, n, err := readUintBytes(p[nn:])
 			if err != nil {
-				return err
+				return nn, err
 			}
 			v[i] = uin
 			nn += n
@@ -289,7 +539,7 @@ func (s *Schema) DecodeToSliceZeroCopy(p []byte, v []interface{}) error {
 		case Float:
 			f, n, err := readFloatBytes(p[nn:])
 			if err != nil {
-				return err
+				return nn, err
 			}
 			v[i] = f
 			nn += n
@@ -297,7 +547,7 @@ func (s *Schema) DecodeToSliceZeroCopy(p []byte, v []interface{}) error {
 		case Bool:
 			b, n, err := readBoolBytes(p[nn:])
 			if err != nil {
-				return err
+				return nn, err
 			}
 			v[i] = b
 			nn += n
@@ -305,7 +555,7 @@ func (s *Schema) DecodeToSliceZeroCopy(p []byte, v []interface{}) error {
 		case Bin:
 			dat, n, err := readBinZeroCopy(p[nn:])
 			if err != nil {
-				return err
+				return nn, err
 			}
 			v[i] = dat
 			nn += n
@@ -313,57 +563,123 @@ func (s *Schema) DecodeToSliceZeroCopy(p []byte, v []interface{}) error {
 		case Ext:
 			dat, etype, n, err := readExtZeroCopy(p[nn:])
 			if err != nil {
-				return err
+				return nn, err
 			}
 			p := &PackExt{EType: etype, Data: dat}
 			v[i] = p
 			nn += n
 
+		case Time:
+			t, n, err := readTimeBytes(p[nn:])
+			if err != nil {
+				return nn, err
+			}
+			v[i] = t
+			nn += n
+
+		case Array:
+			a, n, err := readArrayZeroCopy(p[nn:], o.Elem)
+			if err != nil {
+				return nn, err
+			}
+			v[i] = a
+			nn += n
+
+		case Map:
+			m, n, err := readMapZeroCopy(p[nn:], o.Elem)
+			if err != nil {
+				return nn, err
+			}
+			v[i] = m
+			nn += n
+
+		case Struct:
+			sub := o.sub()
+			sv := make([]interface{}, len(*sub))
+			n, err := sub.decodeToSliceZeroCopy(p[nn:], sv)
+			if err != nil {
+				return nn, err
+			}
+			v[i] = sv
+			nn += n
+
 		default:
-			return ErrIncorrectType
+			return nn, ErrIncorrectType
 		}
 
 	}
-	return nil //schema is nil...
+	return nn, nil
 }
 
 // DecodeToMap uses a schema to decode a fluxmsg stream into a map[string]interface{}.
 // The map keys are the Name fields of each msg.Object in the msg.Schema.
+// Struct objects are decoded into nested maps, and objects that are newer
+// than the message (see Object.Since) are set to their Default values.
 func (s *Schema) DecodeToMap(r Reader, m map[string]interface{}) error {
 	var t Type
 	var n string
 	var ns interface{}
 	var err error
-	for _, o := range *s {
+	for i, o := range *s {
 		t = o.T
 		n = o.Name
+		// older messages end before newer objects
+		if o.Since > 0 {
+			var eof bool
+			eof, err = atEOF(r)
+			if err != nil {
+				return err
+			}
+			if eof {
+				s.defaultsToMap(i, m)
+				return nil
+			}
+		}
+		if o.Optional {
+			var isnil bool
+			isnil, err = readIsNil(r)
+			if err != nil {
+				return err
+			}
+			if isnil {
+				m[n] = nil
+				continue
+			}
+		}
 		switch t {
 
 		case String:
 			ns, err = readString(r)
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			m[n] = ns
 
 		case Int:
 			ns, err = readInt(r)
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			m[n] = ns
 
 		case Uint:
 			ns, err = readUint(r)
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			m[n] = ns
 
 		case Float:
 			ns, err = readFloat(r)
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
+			}
+			m[n] = ns
+
+		case Bool:
+			ns, err = readBool(r)
+			if err != nil {
+				return readError(r, t, o.Name, err)
 			}
 			m[n] = ns
 
@@ -372,7 +688,7 @@ func (s *Schema) DecodeToMap(r Reader, m map[string]interface{}) error {
 			var dat []byte
 			dat, err = readBin(r, bs[:32])
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			m[n] = dat
 
@@ -382,10 +698,39 @@ func (s *Schema) DecodeToMap(r Reader, m map[string]interface{}) error {
 			var etype int8
 			dat, etype, err = readExt(r, bs[:32])
 			if err != nil {
-				return err
+				return readError(r, t, o.Name, err)
 			}
 			m[n] = &PackExt{EType: etype, Data: dat}
 
+		case Time:
+			ns, err = readTime(r)
+			if err != nil {
+				return readError(r, t, o.Name, err)
+			}
+			m[n] = ns
+
+		case Array:
+			ns, err = readArray(r, o.Elem)
+			if err != nil {
+				return readError(r, t, o.Name, err)
+			}
+			m[n] = ns
+
+		case Map:
+			ns, err = readMap(r, o.Elem)
+			if err != nil {
+				return readError(r, t, o.Name, err)
+			}
+			m[n] = ns
+
+		case Struct:
+			sm := make(map[string]interface{})
+			err = o.sub().DecodeToMap(r, sm)
+			if err != nil {
+				return readError(r, t, o.Name, err)
+			}
+			m[n] = sm
+
 		default:
 			err = ErrIncorrectType
 			return err
@@ -396,192 +741,948 @@ func (s *Schema) DecodeToMap(r Reader, m map[string]interface{}) error {
 }
 
 // EncodeSlice uses a schema to encode a slice-of-interface to a msg.Writer.
-func (s *Schema) EncodeSlice(a []interface{}, w Writer) (err error) {
+// Nil values are written as 'nil' for Optional objects, and Struct
+// objects are written from a nested []interface{}.
+func (s *Schema) EncodeSlice(a []interface{}, w Writer) error {
+	bp := newBytes()
+	b, err := s.AppendSlice(*bp, a)
+	if err == nil {
+		w.Write(b)
+	}
+	*bp = b
+	putBytes(bp)
+	return err
+}
+
+// AppendSlice is like EncodeSlice, but it appends the
+// message to 'b' and returns the extended slice.
+func (s *Schema) AppendSlice(b []byte, a []interface{}) ([]byte, error) {
+	var err error
 	for i, v := range a {
-		err = encode(v, (*s)[i], w)
+		b, err = appendValue(b, v, (*s)[i])
 		if err != nil {
-			return
+			return b, err
 		}
 	}
-	return
+	return b, nil
 }
 
 // WriteJSON writes an encoded message in memory
 // as a JSON-encoded map of key-value pairs. Ext-type
 // values are encoded as {"extension_type":<int8>, "data":<base64 string>}.
-// Bin values are encoded as base64 strings.
-// Each value is keyed by its Name field in the Schema.
+// Bin values are encoded as base64 strings, Time values are RFC3339 strings
+// (with nanoseconds), nil Optional values are null, and Struct values are
+// nested JSON objects. Objects that are newer than the message
+// (see Object.Since) are written with their Default values.
+// Each value is keyed by its Name field in the Schema. Strings and
+// keys are escaped with AppendJSONString, so invalid UTF-8 is
+// replaced with U+FFFD. (WriteJSONWith renders values differently.)
 func (s *Schema) WriteJSON(p []byte, w Writer) error {
 	// TODO: performance improvements. strconv is overkill in most cases.
 
 	// varray underlies 'empty' to pre-empt allocs on append()
 	varray := [64]byte{}
+	_, err := s.writeJSON(p, w, varray[0:0], &defaultJSON)
+	return s.explain(p, err)
+}
+
+// writeJSON implements WriteJSON, and also returns
+// the number of bytes read from 'p'.
+func (s *Schema) writeJSON(p []byte, w Writer, empty []byte, opt *JSONOptions) (int, error) {
 	var nr int //totoal number of bytes read
 	var n int  //each number of bytes read
 	var err error
-	empty := varray[0:0]
 	w.WriteByte(lcurly)
 
 	// Read-Write loop
-	for i, o := range *s {
+	for i := range *s {
+		o := &(*s)[i]
 		// write comma
 		if i != 0 {
 			w.WriteByte(comma)
 
 		}
 		// Write Name - "name":
-		w.WriteByte(qte)
-		w.WriteString(o.Name)
-		w.WriteByte(qte)
-		w.WriteByte(colon)
+		key, err := AppendJSONString(empty, o.Name, opt.Strings)
+		if err != nil {
+			return nr, err
+		}
+		w.Write(append(key, colon))
 
-		// Read value, write value
-		switch o.T {
-		case String:
-			var s string
-			s, n, err = ReadStringZeroCopy(p[nr:]) //safe, b/c we only retain the reference internally
+		// older messages end before newer objects
+		if o.Since > 0 && nr >= len(p) {
+			err = o.writeJSONDefault(w, empty, opt)
 			if err != nil {
-				return err
+				return nr, err
 			}
-			w.Write(strconv.AppendQuote(empty, s))
-
-			nr += n
 			continue
+		}
 
-		case Int:
-			var i int64
-			i, n, err = ReadIntBytes(p[nr:])
-			if err != nil {
-				return err
-			}
-			w.Write(strconv.AppendInt(empty, i, 10))
-			nr += n
-			continue
+		// Read value, write value
+		n, err = writeJSONValue(p[nr:], o, w, empty, opt)
+		if err != nil {
+			return nr, err
+		}
+		nr += n
+	}
+	err = w.WriteByte(rcurly)
+	return nr, err
+}
 
-		case Uint:
-			var u uint64
-			u, n, err = ReadUintBytes(p[nr:])
-			if err != nil {
-				return err
-			}
-			w.Write(strconv.AppendUint(empty, u, 10))
-			nr += n
-			continue
+// writeJSONValue writes the leading value in 'p' as JSON
+// and returns the number of bytes read. 'empty' is
+// scratch space for formatting numbers.
+func writeJSONValue(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	// nil -> null
+	if o.Optional && IsNil(p) {
+		w.Write(null)
+		n = 1
+		return
+	}
 
-		case Bool:
-			var b bool
-			b, n, err = ReadBoolBytes(p[nr:])
-			if err != nil {
-				return err
-			}
-			w.Write(strconv.AppendBool(empty, b))
-			nr += n
-			continue
+	return jsonWriterFor(o.T)(p, o, w, empty, opt)
+}
 
-		case Float:
-			var f float64
-			f, n, err = ReadFloatBytes(p[nr:])
-			if err != nil {
-				return err
-			}
-			w.Write(strconv.AppendFloat(empty, f, 'f', -1, 64))
-			nr += n
-			continue
+// jsonWriter writes the leading value in 'p', which has
+// the Type o.T, as JSON, and returns the number of bytes read.
+type jsonWriter func(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (int, error)
 
-		case Bin:
-			var dat []byte
-			dat, n, err = ReadBinZeroCopy(p[nr:]) //again, safe b/c of internal handling
-			if err != nil {
-				return err
-			}
-			w.WriteByte(qte)
-			w.WriteString(base64.StdEncoding.EncodeToString(dat))
-			w.WriteByte(qte)
-			nr += n
-			continue
+// jsonWriterFor returns the jsonWriter for values of Type 't'
+func jsonWriterFor(t Type) jsonWriter {
+	switch t {
+	case String:
+		return jsonString
+	case Int:
+		return jsonInt
+	case Uint:
+		return jsonUint
+	case Bool:
+		return jsonBool
+	case Float:
+		return jsonFloat
+	case Bin:
+		return jsonBin
+	case Ext:
+		return jsonExt
+	case Time:
+		return jsonTime
+	case Array:
+		return jsonArray
+	case Map:
+		return jsonMap
+	case Struct:
+		return jsonStruct
+	default:
+		return jsonUnsupported
+	}
+}
 
-		case Ext:
-			var dat []byte
-			var etype int8
-			// Ext is the only nested object
-			dat, etype, n, err = ReadExtZeroCopy(p[nr:])
-			if err != nil {
-				return err
-			}
-			w.WriteByte(lcurly)
-			w.WriteByte(qte)
-			w.Write(exttype)
-			w.WriteByte(qte)
-			w.WriteByte(colon)
-			w.Write(strconv.AppendInt(empty, int64(etype), 10))
-			w.WriteByte(comma)
-			w.WriteByte(qte)
-			w.Write(data)
-			w.WriteByte(qte)
-			w.WriteByte(colon)
-			w.WriteByte(qte)
-			w.WriteString(base64.StdEncoding.EncodeToString(dat))
-			w.WriteByte(qte)
-			w.WriteByte(rcurly)
-			nr += n
-			continue
+func jsonString(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	var s string
+	s, n, err = readStringZeroCopy(p) //safe, b/c we only retain the reference internally
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = AppendJSONString(empty, s, opt.Strings)
+	w.Write(b)
+	return
+}
 
-		default:
-			return ErrTypeNotSupported
+func jsonInt(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	// fast path for fixints
+	if len(p) > 0 && (p[0] <= mfixintMAX || p[0] >= mnfixint) {
+		w.Write(strconv.AppendInt(empty, int64(int8(p[0])), 10))
+		return 1, nil
+	}
+	var i int64
+	i, n, err = readIntBytes(p)
+	if err != nil {
+		return
+	}
+	w.Write(opt.appendInt(empty, i))
+	return
+}
 
-		}
+func jsonUint(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	var u uint64
+	u, n, err = readUintBytes(p)
+	if err != nil {
+		return
+	}
+	w.Write(opt.appendUint(empty, u))
+	return
+}
 
+func jsonBool(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	var b bool
+	b, n, err = readBoolBytes(p)
+	if err != nil {
+		return
 	}
-	err = w.WriteByte(rcurly)
-	return err
+	w.Write(strconv.AppendBool(empty, b))
+	return
 }
 
-// encode interface{} by declared Type
-func encode(v interface{}, o Object, w Writer) error {
-	switch o.T {
-	case Float:
-		f, ok := v.(float64)
-		if !ok {
-			return ErrIncorrectType
-		}
-		writeFloat(w, f)
-		return nil
-	case Uint:
-		i, ok := v.(uint64)
-		if !ok {
-			return ErrIncorrectType
-		}
-		writeUint(w, i)
-		return nil
-	case Int:
-		i, ok := v.(int64)
-		if !ok {
-			return ErrIncorrectType
-		}
-		writeInt(w, i)
-		return nil
-	case Bool:
-		b, ok := v.(bool)
+func jsonFloat(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	var f float64
+	f, n, err = readFloatBytes(p)
+	if err != nil {
+		return
+	}
+	w.Write(opt.appendFloat(empty, f))
+	return
+}
+
+func jsonBin(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	var dat []byte
+	dat, n, err = readBinZeroCopy(p) //again, safe b/c of internal handling
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = opt.appendBin(empty, dat)
+	w.Write(b)
+	return
+}
+
+func jsonExt(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	var dat []byte
+	var etype int8
+	dat, etype, n, err = readExtZeroCopy(p)
+	if err != nil {
+		return
+	}
+	if render := opt.Ext[etype]; render != nil {
+		var b []byte
+		b, err = render(empty, dat)
+		w.Write(b)
+		return
+	}
+	w.WriteByte(lcurly)
+	w.WriteByte(qte)
+	w.Write(exttype)
+	w.WriteByte(qte)
+	w.WriteByte(colon)
+	w.Write(strconv.AppendInt(empty, int64(etype), 10))
+	w.WriteByte(comma)
+	w.WriteByte(qte)
+	w.Write(data)
+	w.WriteByte(qte)
+	w.WriteByte(colon)
+	w.WriteByte(qte)
+	w.WriteString(base64.StdEncoding.EncodeToString(dat))
+	w.WriteByte(qte)
+	w.WriteByte(rcurly)
+	return
+}
+
+func jsonTime(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	var t time.Time
+	t, n, err = readTimeBytes(p)
+	if err != nil {
+		return
+	}
+	w.WriteByte(qte)
+	w.Write(t.AppendFormat(empty, time.RFC3339Nano))
+	w.WriteByte(qte)
+	return
+}
+
+func jsonArray(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	if err = o.checkElem(); err != nil {
+		return
+	}
+	var sz uint32
+	var en int
+	sz, n, err = readArrayHeaderBytes(p)
+	if err != nil {
+		return
+	}
+	eo := Object{T: o.Elem}
+	ew := jsonWriterFor(o.Elem)
+	w.WriteByte(lsqr)
+	for i := uint32(0); i < sz; i++ {
+		if i != 0 {
+			w.WriteByte(comma)
+		}
+		en, err = ew(p[n:], &eo, w, empty, opt)
+		if err != nil {
+			return
+		}
+		n += en
+	}
+	w.WriteByte(rsqr)
+	return
+}
+
+func jsonMap(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (n int, err error) {
+	if err = o.checkElem(); err != nil {
+		return
+	}
+	var sz uint32
+	var en int
+	var key string
+	sz, n, err = readMapHeaderBytes(p)
+	if err != nil {
+		return
+	}
+	eo := Object{T: o.Elem}
+	ew := jsonWriterFor(o.Elem)
+	w.WriteByte(lcurly)
+	for i := uint32(0); i < sz; i++ {
+		if i != 0 {
+			w.WriteByte(comma)
+		}
+		key, en, err = readStringZeroCopy(p[n:])
+		if err != nil {
+			return
+		}
+		n += en
+		var kb []byte
+		kb, err = AppendJSONString(empty, key, opt.Strings)
+		if err != nil {
+			return
+		}
+		w.Write(append(kb, colon))
+		en, err = ew(p[n:], &eo, w, empty, opt)
+		if err != nil {
+			return
+		}
+		n += en
+	}
+	w.WriteByte(rcurly)
+	return
+}
+
+func jsonStruct(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (int, error) {
+	return o.sub().writeJSON(p, w, empty, opt)
+}
+
+func jsonUnsupported(p []byte, o *Object, w Writer, empty []byte, opt *JSONOptions) (int, error) {
+	return 0, ErrTypeNotSupported
+}
+
+// readIsNil returns whether or not the leading object
+// in 'r' is nil, and consumes it if it is
+func readIsNil(r Reader) (bool, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	if c == mnil {
+		return true, nil
+	}
+	return false, r.UnreadByte()
+}
+
+// encode interface{} by declared Type
+func encode(v interface{}, o Object, w Writer) error {
+	bp := newBytes()
+	b, err := appendValue(*bp, v, o)
+	if err == nil {
+		w.Write(b)
+	}
+	*bp = b
+	putBytes(bp)
+	return err
+}
+
+// appendValue appends interface{} by declared Type
+func appendValue(b []byte, v interface{}, o Object) ([]byte, error) {
+	if v == nil && o.Optional {
+		return AppendNil(b), nil
+	}
+	switch o.T {
+	case Float:
+		f, ok := v.(float64)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		return AppendFloat(b, f), nil
+	case Uint:
+		i, ok := v.(uint64)
 		if !ok {
-			return ErrIncorrectType
+			return b, ErrIncorrectType
 		}
-		writeBool(w, b)
-		return nil
+		return AppendUint(b, i), nil
+	case Int:
+		i, ok := v.(int64)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		return AppendInt(b, i), nil
+	case Bool:
+		x, ok := v.(bool)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		return AppendBool(b, x), nil
 	case String:
 		s, ok := v.(string)
 		if !ok {
-			return ErrIncorrectType
+			return b, ErrIncorrectType
 		}
-		writeString(w, s)
-		return nil
+		return AppendString(b, s), nil
 	case Bin:
 		bs, ok := v.([]byte)
 		if !ok {
-			return ErrIncorrectType
+			return b, ErrIncorrectType
+		}
+		return AppendBin(b, bs), nil
+	case Time:
+		t, ok := v.(time.Time)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		return AppendTime(b, t), nil
+	case Ext:
+		e, ok := v.(*PackExt)
+		if !ok || e == nil {
+			return b, ErrIncorrectType
+		}
+		return AppendExt(b, e.EType, e.Data), nil
+	case Array:
+		return appendArray(b, v, o.Elem)
+	case Map:
+		return appendMap(b, v, o.Elem)
+	case Struct:
+		a, ok := v.([]interface{})
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		sub := o.sub()
+		if len(a) != len(*sub) {
+			return b, ErrBadArgs
+		}
+		return sub.AppendSlice(b, a)
+	default:
+		return b, ErrTypeNotSupported
+	}
+}
+
+// appendArray appends a typed slice as an array of 'elem'
+func appendArray(b []byte, v interface{}, elem Type) ([]byte, error) {
+	switch elem {
+	case Float:
+		a, ok := v.([]float64)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		b = AppendArrayHeader(b, uint32(len(a)))
+		for _, f := range a {
+			b = AppendFloat(b, f)
+		}
+	case Int:
+		a, ok := v.([]int64)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		b = AppendArrayHeader(b, uint32(len(a)))
+		for _, i := range a {
+			b = AppendInt(b, i)
+		}
+	case Uint:
+		a, ok := v.([]uint64)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		b = AppendArrayHeader(b, uint32(len(a)))
+		for _, u := range a {
+			b = AppendUint(b, u)
+		}
+	case Bool:
+		a, ok := v.([]bool)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		b = AppendArrayHeader(b, uint32(len(a)))
+		for _, x := range a {
+			b = AppendBool(b, x)
+		}
+	case String:
+		a, ok := v.([]string)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		b = AppendArrayHeader(b, uint32(len(a)))
+		for _, s := range a {
+			b = AppendString(b, s)
+		}
+	case Bin:
+		a, ok := v.([][]byte)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		b = AppendArrayHeader(b, uint32(len(a)))
+		for _, bs := range a {
+			b = AppendBin(b, bs)
+		}
+	case Ext:
+		a, ok := v.([]*PackExt)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		b = AppendArrayHeader(b, uint32(len(a)))
+		for _, e := range a {
+			if e == nil {
+				return b, ErrIncorrectType
+			}
+			b = AppendExt(b, e.EType, e.Data)
+		}
+	case Time:
+		a, ok := v.([]time.Time)
+		if !ok {
+			return b, ErrIncorrectType
+		}
+		b = AppendArrayHeader(b, uint32(len(a)))
+		for _, t := range a {
+			b = AppendTime(b, t)
 		}
-		writeBin(w, bs)
-		return nil
 	default:
-		return ErrTypeNotSupported
+		return b, ErrTypeNotSupported
+	}
+	return b, nil
+}
+
+// scalar returns whether or not 't' can be
+// the Elem of an Array or Map
+func scalar(t Type) bool { return t <= Float || t == Time }
+
+// checkElem returns ErrTypeNotSupported if 'o' is an Array
+// or Map whose Elem is not scalar, and ErrNestedVersion if
+// 'o' is a Struct with an object that has a Since. Everything
+// that walks the elements of 'o' checks it first, since a Struct
+// Elem has no Schema and takes no bytes, so a hostile header
+// would otherwise spin through billions of empty elements.
+// (Deeper Structs are checked as they are reached.)
+func (o *Object) checkElem() error {
+	switch o.T {
+	case Array, Map:
+		if !scalar(o.Elem) {
+			return ErrTypeNotSupported
+		}
+	case Struct:
+		for i := range *o.sub() {
+			if (*o.sub())[i].Since > 0 {
+				return ErrNestedVersion
+			}
+		}
 	}
+	return nil
+}
+
+// appendMap appends a map[string]interface{} (or one of the typed
+// maps that MakeSchema accepts) as a map of 'elem', in sorted key order
+func appendMap(b []byte, v interface{}, elem Type) ([]byte, error) {
+	if !scalar(elem) {
+		return b, ErrTypeNotSupported
+	}
+	var keys []string
+	var err error
+	switch m := v.(type) {
+	case map[string]interface{}:
+		keys = make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = AppendMapHeader(b, uint32(len(keys)))
+		eo := Object{T: elem}
+		for _, k := range keys {
+			b = AppendString(b, k)
+			b, err = appendValue(b, m[k], eo)
+			if err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case map[string]string:
+		if elem != String {
+			return b, ErrIncorrectType
+		}
+		keys = make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = AppendMapHeader(b, uint32(len(keys)))
+		for _, k := range keys {
+			b = AppendString(b, k)
+			b = AppendString(b, m[k])
+		}
+		return b, nil
+	case map[string]float64:
+		if elem != Float {
+			return b, ErrIncorrectType
+		}
+		keys = make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = AppendMapHeader(b, uint32(len(keys)))
+		for _, k := range keys {
+			b = AppendString(b, k)
+			b = AppendFloat(b, m[k])
+		}
+		return b, nil
+	case map[string]int64:
+		if elem != Int {
+			return b, ErrIncorrectType
+		}
+		keys = make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = AppendMapHeader(b, uint32(len(keys)))
+		for _, k := range keys {
+			b = AppendString(b, k)
+			b = AppendInt(b, m[k])
+		}
+		return b, nil
+	default:
+		return b, ErrIncorrectType
+	}
+}
+
+// readMap reads a map of 'elem' into a map[string]interface{}
+func readMap(r Reader, elem Type) (v map[string]interface{}, err error) {
+	if !scalar(elem) {
+		err = ErrTypeNotSupported
+		return
+	}
+	var sz uint32
+	sz, err = readMapHeader(r)
+	if err != nil {
+		return
+	}
+	v = make(map[string]interface{}, prealloc(sz))
+	var key string
+	var val interface{}
+	for i := uint32(0); i < sz; i++ {
+		key, err = readString(r)
+		if err != nil {
+			err = readError(r, String, "", inValue(err))
+			return
+		}
+		val, err = readScalar(r, elem)
+		if err != nil {
+			err = readError(r, elem, "["+strconv.Quote(key)+"]", inValue(err))
+			return
+		}
+		v[key] = val
+	}
+	return
+}
+
+// readMapZeroCopy is readMap for a byte slice; keys and
+// values point to 'p'
+func readMapZeroCopy(p []byte, elem Type) (v map[string]interface{}, n int, err error) {
+	if !scalar(elem) {
+		err = ErrTypeNotSupported
+		return
+	}
+	var sz uint32
+	var en int
+	sz, n, err = readMapHeaderBytes(p)
+	if err != nil {
+		return
+	}
+	// every pair is at least two bytes
+	if int64(sz)*2 > int64(len(p)-n) {
+		err = ErrShortBytes
+		return
+	}
+	v = make(map[string]interface{}, sz)
+	var key string
+	var val interface{}
+	for i := uint32(0); i < sz; i++ {
+		key, en, err = readStringZeroCopy(p[n:])
+		if err != nil {
+			return
+		}
+		n += en
+		val, en, err = readScalarZeroCopy(p[n:], elem)
+		if err != nil {
+			return
+		}
+		n += en
+		v[key] = val
+	}
+	return
+}
+
+// readScalar reads one value of type 't'
+func readScalar(r Reader, t Type) (v interface{}, err error) {
+	switch t {
+	case String:
+		return readString(r)
+	case Int:
+		return readInt(r)
+	case Uint:
+		return readUint(r)
+	case Float:
+		return readFloat(r)
+	case Bool:
+		return readBool(r)
+	case Bin:
+		return readBin(r, nil)
+	case Ext:
+		var dat []byte
+		var etype int8
+		dat, etype, err = readExt(r, nil)
+		if err != nil {
+			return
+		}
+		return &PackExt{EType: etype, Data: dat}, nil
+	case Time:
+		return readTime(r)
+	default:
+		return nil, ErrTypeNotSupported
+	}
+}
+
+// readScalarZeroCopy reads one value of type 't' from 'p'
+func readScalarZeroCopy(p []byte, t Type) (v interface{}, n int, err error) {
+	switch t {
+	case String:
+		return readStringZeroCopy(p)
+	case Int:
+		return readIntBytes(p)
+	case Uint:
+		return readUintBytes(p)
+	case Float:
+		return readFloatBytes(p)
+	case Bool:
+		return readBoolBytes(p)
+	case Bin:
+		return readBinZeroCopy(p)
+	case Ext:
+		var dat []byte
+		var etype int8
+		dat, etype, n, err = readExtZeroCopy(p)
+		if err != nil {
+			return
+		}
+		return &PackExt{EType: etype, Data: dat}, n, nil
+	case Time:
+		return readTimeBytes(p)
+	default:
+		return nil, 0, ErrTypeNotSupported
+	}
+}
+
+// readArray reads an array of 'elem' into the
+// corresponding slice type (see appendArray)
+func readArray(r Reader, elem Type) (v interface{}, err error) {
+	var sz uint32
+	sz, err = readArrayHeader(r)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			err = readError(r, elem, "", inValue(err))
+		}
+	}()
+	switch elem {
+	case Float:
+		a := make([]float64, 0, prealloc(sz))
+		for i := uint32(0); i < sz; i++ {
+			var e float64
+			e, err = readFloat(r)
+			if err != nil {
+				return
+			}
+			a = append(a, e)
+		}
+		v = a
+	case Int:
+		a := make([]int64, 0, prealloc(sz))
+		for i := uint32(0); i < sz; i++ {
+			var e int64
+			e, err = readInt(r)
+			if err != nil {
+				return
+			}
+			a = append(a, e)
+		}
+		v = a
+	case Uint:
+		a := make([]uint64, 0, prealloc(sz))
+		for i := uint32(0); i < sz; i++ {
+			var e uint64
+			e, err = readUint(r)
+			if err != nil {
+				return
+			}
+			a = append(a, e)
+		}
+		v = a
+	case Bool:
+		a := make([]bool, 0, prealloc(sz))
+		for i := uint32(0); i < sz; i++ {
+			var e bool
+			e, err = readBool(r)
+			if err != nil {
+				return
+			}
+			a = append(a, e)
+		}
+		v = a
+	case String:
+		a := make([]string, 0, prealloc(sz))
+		for i := uint32(0); i < sz; i++ {
+			var e string
+			e, err = readString(r)
+			if err != nil {
+				return
+			}
+			a = append(a, e)
+		}
+		v = a
+	case Bin:
+		a := make([][]byte, 0, prealloc(sz))
+		for i := uint32(0); i < sz; i++ {
+			var e []byte
+			e, err = readBin(r, nil)
+			if err != nil {
+				return
+			}
+			a = append(a, e)
+		}
+		v = a
+	case Ext:
+		a := make([]*PackExt, 0, prealloc(sz))
+		for i := uint32(0); i < sz; i++ {
+			var dat []byte
+			var etype int8
+			dat, etype, err = readExt(r, nil)
+			if err != nil {
+				return
+			}
+			a = append(a, &PackExt{EType: etype, Data: dat})
+		}
+		v = a
+	case Time:
+		a := make([]time.Time, 0, prealloc(sz))
+		for i := uint32(0); i < sz; i++ {
+			var e time.Time
+			e, err = readTime(r)
+			if err != nil {
+				return
+			}
+			a = append(a, e)
+		}
+		v = a
+	default:
+		err = ErrTypeNotSupported
+	}
+	return
+}
+
+// readArrayZeroCopy is readArray for a byte slice; strings, bins,
+// and extensions point to 'p'
+func readArrayZeroCopy(p []byte, elem Type) (v interface{}, n int, err error) {
+	var sz uint32
+	var en int
+	sz, n, err = readArrayHeaderBytes(p)
+	if err != nil {
+		return
+	}
+	// every element is at least one byte
+	if int64(sz) > int64(len(p)-n) {
+		err = ErrShortBytes
+		return
+	}
+	switch elem {
+	case Float:
+		a := make([]float64, sz)
+		for i := range a {
+			a[i], en, err = readFloatBytes(p[n:])
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		v = a
+	case Int:
+		a := make([]int64, sz)
+		for i := range a {
+			a[i], en, err = readIntBytes(p[n:])
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		v = a
+	case Uint:
+		a := make([]uint64, sz)
+		for i := range a {
+			a[i], en, err = readUintBytes(p[n:])
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		v = a
+	case Bool:
+		a := make([]bool, sz)
+		for i := range a {
+			a[i], en, err = readBoolBytes(p[n:])
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		v = a
+	case String:
+		a := make([]string, sz)
+		for i := range a {
+			a[i], en, err = readStringZeroCopy(p[n:])
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		v = a
+	case Bin:
+		a := make([][]byte, sz)
+		for i := range a {
+			a[i], en, err = readBinZeroCopy(p[n:])
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		v = a
+	case Ext:
+		a := make([]*PackExt, sz)
+		for i := range a {
+			var dat []byte
+			var etype int8
+			dat, etype, en, err = readExtZeroCopy(p[n:])
+			if err != nil {
+				return
+			}
+			a[i] = &PackExt{EType: etype, Data: dat}
+			n += en
+		}
+		v = a
+	case Time:
+		a := make([]time.Time, sz)
+		for i := range a {
+			a[i], en, err = readTimeBytes(p[n:])
+			if err != nil {
+				return
+			}
+			n += en
+		}
+		v = a
+	default:
+		err = ErrTypeNotSupported
+	}
+	return
 }
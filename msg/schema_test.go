@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMakeSchema(t *testing.T) {
@@ -182,6 +183,20 @@ func TestWriteJSON(t *testing.T) {
 	t.Logf("Decoded: %#v", m)
 }
 
+func TestEncodeSliceAllocs(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	buf.Grow(256)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		if err := iterSchema.EncodeSlice(iterValues, buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("EncodeSlice made %v allocations; expected 0", allocs)
+	}
+}
+
 func BenchmarkEncodeSlice(b *testing.B) {
 	b.ReportAllocs()
 	names := []string{"float", "int", "uint", "string", "bin"}
@@ -211,13 +226,14 @@ func BenchmarkEncodeSlice(b *testing.B) {
 }
 
 func TestSchemaDecodeToSlice(t *testing.T) {
-	names := []string{"float", "int", "uint", "string", "bin"}
+	names := []string{"float", "int", "uint", "string", "bin", "bool"}
 	values := make([]interface{}, len(names))
 	values[0] = float64(3.5898493027815032478)
 	values[1] = int64(-2000)
 	values[2] = uint64(586)
 	values[3] = "here's a string"
 	values[4] = []byte{3, 4, 5}
+	values[5] = true
 
 	s, err := MakeSchema(names, values)
 	if err != nil {
@@ -244,13 +260,14 @@ func TestSchemaDecodeToSlice(t *testing.T) {
 }
 
 func TestSchemaDecodetoMap(t *testing.T) {
-	names := []string{"float", "int", "uint", "string", "bin"}
+	names := []string{"float", "int", "uint", "string", "bin", "bool"}
 	values := make([]interface{}, len(names))
 	values[0] = float64(3.589)
 	values[1] = int64(-2000)
 	values[2] = uint64(586)
 	values[3] = "here's a string"
 	values[4] = []byte{3, 4, 5}
+	values[5] = true
 
 	s, err := MakeSchema(names, values)
 	if err != nil {
@@ -315,6 +332,10 @@ func TestSchemaDecodetoMap(t *testing.T) {
 			if !reflect.DeepEqual(bts, values[4].([]byte)) {
 				t.Errorf("Expected %v, got %v", values[4], bts)
 			}
+		case "bool":
+			if val != values[5] {
+				t.Errorf("Expected %v, got %v", values[5], val)
+			}
 
 		default:
 			t.Errorf("Unknown name in map: %q", key)
@@ -472,3 +493,447 @@ func BenchmarkStdlibReadJSONWriteJSON(b *testing.B) {
 	}
 
 }
+
+func TestSchemaOptional(t *testing.T) {
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "charge", T: Int, Optional: true},
+		{Name: "data", T: Bin, Optional: true},
+		{Name: "id", T: Uint},
+	}
+	values := []interface{}{"bike", nil, []byte{1, 2}, uint64(7)}
+
+	buf := bytes.NewBuffer(nil)
+	err := s.EncodeSlice(values, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+
+	// optional flag must survive Encode/Decode
+	sbuf := bytes.NewBuffer(nil)
+	s.Encode(sbuf)
+	snew := new(Schema)
+	err = snew.Decode(sbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*snew, s) {
+		t.Errorf("Expected schema %v; got %v", s, *snew)
+	}
+
+	out := make([]interface{}, len(s))
+	err = s.DecodeToSliceZeroCopy(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, values) {
+		t.Errorf("DecodeToSliceZeroCopy: expected %v; got %v", values, out)
+	}
+
+	m := map[string]interface{}{"charge": int64(3)}
+	err = s.DecodeToMap(bytes.NewReader(bts), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := m["charge"]; !ok || v != nil {
+		t.Errorf("DecodeToMap: expected nil charge; got %v", v)
+	}
+
+	jbuf := bytes.NewBuffer(nil)
+	err = s.WriteJSON(bts, jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"name":"bike","charge":null,"data":"AQI=","id":7}`
+	if jbuf.String() != expect {
+		t.Errorf("WriteJSON: expected %s; got %s", expect, jbuf.String())
+	}
+
+	// nil is not allowed for non-optional objects
+	err = s.EncodeSlice([]interface{}{nil, nil, nil, uint64(7)}, buf)
+	if err != ErrIncorrectType {
+		t.Errorf("Expected ErrIncorrectType; got %v", err)
+	}
+}
+
+func TestSchemaArray(t *testing.T) {
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "readings", T: Array, Elem: Float},
+		{Name: "tags", T: Array, Elem: String},
+		{Name: "counts", T: Array, Elem: Uint, Optional: true},
+	}
+	values := []interface{}{"bike", []float64{0.5, -2.25, 100}, []string{"a", "bc"}, nil}
+
+	buf := bytes.NewBuffer(nil)
+	err := s.EncodeSlice(values, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+
+	sbuf := bytes.NewBuffer(nil)
+	s.Encode(sbuf)
+	snew := new(Schema)
+	err = snew.Decode(sbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*snew, s) {
+		t.Errorf("Expected schema %v; got %v", s, *snew)
+	}
+
+	out := make([]interface{}, len(s))
+	err = s.DecodeToSlice(bytes.NewReader(bts), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, values) {
+		t.Errorf("DecodeToSlice: expected %v; got %v", values, out)
+	}
+
+	out = make([]interface{}, len(s))
+	err = s.DecodeToSliceZeroCopy(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, values) {
+		t.Errorf("DecodeToSliceZeroCopy: expected %v; got %v", values, out)
+	}
+
+	m := make(map[string]interface{})
+	err = s.DecodeToMap(bytes.NewReader(bts), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m["tags"], values[2]) {
+		t.Errorf("DecodeToMap: expected %v; got %v", values[2], m["tags"])
+	}
+
+	jbuf := bytes.NewBuffer(nil)
+	err = s.WriteJSON(bts, jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"name":"bike","readings":[0.5,-2.25,100],"tags":["a","bc"],"counts":null}`
+	if jbuf.String() != expect {
+		t.Errorf("WriteJSON: expected %s; got %s", expect, jbuf.String())
+	}
+
+	err = s.EncodeSlice([]interface{}{"bike", []int64{1}, []string{}, nil}, buf)
+	if err != ErrIncorrectType {
+		t.Errorf("Expected ErrIncorrectType; got %v", err)
+	}
+}
+
+func TestSchemaBadElem(t *testing.T) {
+	for _, o := range []Object{
+		{Name: "a", T: Array, Elem: Struct},
+		{Name: "a", T: Array, Elem: Array},
+		{Name: "a", T: Map, Elem: Struct},
+		{Name: "a", T: Map, Elem: Map},
+	} {
+		s := Schema{o}
+		// a hostile header: as many elements as the Limits allow,
+		// none of which would take any bytes
+		var p []byte
+		if o.T == Array {
+			p = AppendArrayHeader(nil, DefaultLimits.MaxArray)
+		} else {
+			p = AppendMapHeader(nil, DefaultLimits.MaxMap)
+		}
+		name := o.typeName()
+
+		verr, ok := s.Validate(p).(*ValidationError)
+		if !ok || verr.Err != ErrTypeNotSupported {
+			t.Errorf("%s: Validate: expected ErrTypeNotSupported; got %v", name, verr)
+		}
+		if err := s.WriteJSON(p, bytes.NewBuffer(nil)); err == nil {
+			t.Errorf("%s: WriteJSON: expected an error", name)
+		}
+		it := NewIterator(&s, p)
+		if it.Next() || it.Err() == nil {
+			t.Errorf("%s: Iterator: expected an error", name)
+		}
+		if _, err := s.Compile(); err == nil {
+			t.Errorf("%s: Compile: expected an error", name)
+		}
+
+		buf := bytes.NewBuffer(nil)
+		s.Encode(buf)
+		if err := new(Schema).Decode(buf); err != ErrTypeNotSupported {
+			t.Errorf("%s: Decode: expected ErrTypeNotSupported; got %v", name, err)
+		}
+	}
+}
+
+func TestSchemaStruct(t *testing.T) {
+	loc := &Schema{
+		{Name: "lat", T: Float},
+		{Name: "lon", T: Float},
+	}
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "location", T: Struct, Schema: loc},
+		{Name: "id", T: Uint},
+	}
+	values := []interface{}{"bike", []interface{}{float64(42.5), float64(-83.75)}, uint64(9)}
+
+	buf := bytes.NewBuffer(nil)
+	err := s.EncodeSlice(values, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+
+	// nested values are inline
+	flat := Schema{{Name: "name", T: String}, {Name: "lat", T: Float}, {Name: "lon", T: Float}, {Name: "id", T: Uint}}
+	fbuf := bytes.NewBuffer(nil)
+	flat.EncodeSlice([]interface{}{"bike", float64(42.5), float64(-83.75), uint64(9)}, fbuf)
+	if !bytes.Equal(bts, fbuf.Bytes()) {
+		t.Errorf("Nested encoding %x differs from flat encoding %x", bts, fbuf.Bytes())
+	}
+
+	sbuf := bytes.NewBuffer(nil)
+	s.Encode(sbuf)
+	snew := new(Schema)
+	err = snew.Decode(sbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*snew, s) {
+		t.Errorf("Expected schema %v; got %v", s, *snew)
+	}
+
+	out := make([]interface{}, len(s))
+	err = s.DecodeToSlice(bytes.NewReader(bts), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, values) {
+		t.Errorf("DecodeToSlice: expected %v; got %v", values, out)
+	}
+
+	out = make([]interface{}, len(s))
+	err = s.DecodeToSliceZeroCopy(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, values) {
+		t.Errorf("DecodeToSliceZeroCopy: expected %v; got %v", values, out)
+	}
+
+	m := make(map[string]interface{})
+	err = s.DecodeToMap(bytes.NewReader(bts), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectm := map[string]interface{}{
+		"name":     "bike",
+		"location": map[string]interface{}{"lat": float64(42.5), "lon": float64(-83.75)},
+		"id":       uint64(9),
+	}
+	if !reflect.DeepEqual(m, expectm) {
+		t.Errorf("DecodeToMap: expected %v; got %v", expectm, m)
+	}
+
+	jbuf := bytes.NewBuffer(nil)
+	err = s.WriteJSON(bts, jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"name":"bike","location":{"lat":42.5,"lon":-83.75},"id":9}`
+	if jbuf.String() != expect {
+		t.Errorf("WriteJSON: expected %s; got %s", expect, jbuf.String())
+	}
+}
+
+func TestSchemaMap(t *testing.T) {
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "labels", T: Map, Elem: String},
+		{Name: "sensors", T: Map, Elem: Float, Optional: true},
+		{Name: "id", T: Uint},
+	}
+	values := []interface{}{
+		"bike",
+		map[string]string{"color": "red", "size": "L"},
+		map[string]interface{}{"temp": 20.5, "hum": 0.25},
+		uint64(9),
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := s.EncodeSlice(values, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+
+	sbuf := bytes.NewBuffer(nil)
+	s.Encode(sbuf)
+	snew := new(Schema)
+	err = snew.Decode(sbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*snew, s) {
+		t.Errorf("Expected schema %v; got %v", s, *snew)
+	}
+
+	expect := []interface{}{
+		"bike",
+		map[string]interface{}{"color": "red", "size": "L"},
+		map[string]interface{}{"temp": 20.5, "hum": 0.25},
+		uint64(9),
+	}
+	out := make([]interface{}, len(s))
+	err = s.DecodeToSlice(bytes.NewReader(bts), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, expect) {
+		t.Errorf("DecodeToSlice: expected %v; got %v", expect, out)
+	}
+
+	out = make([]interface{}, len(s))
+	err = s.DecodeToSliceZeroCopy(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, expect) {
+		t.Errorf("DecodeToSliceZeroCopy: expected %v; got %v", expect, out)
+	}
+
+	m := make(map[string]interface{})
+	err = s.DecodeToMap(bytes.NewReader(bts), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m["sensors"], expect[2]) {
+		t.Errorf("DecodeToMap: expected %v; got %v", expect[2], m["sensors"])
+	}
+
+	// keys are written in sorted order
+	jbuf := bytes.NewBuffer(nil)
+	err = s.WriteJSON(bts, jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jexpect := `{"name":"bike","labels":{"color":"red","size":"L"},"sensors":{"hum":0.25,"temp":20.5},"id":9}`
+	if jbuf.String() != jexpect {
+		t.Errorf("WriteJSON: expected %s; got %s", jexpect, jbuf.String())
+	}
+
+	// nil optional map
+	buf.Reset()
+	err = s.EncodeSlice([]interface{}{"bike", map[string]string{}, nil, uint64(1)}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jbuf.Reset()
+	err = s.WriteJSON(buf.Bytes(), jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jexpect = `{"name":"bike","labels":{},"sensors":null,"id":1}`
+	if jbuf.String() != jexpect {
+		t.Errorf("WriteJSON: expected %s; got %s", jexpect, jbuf.String())
+	}
+
+	// value types must match Elem
+	err = s.EncodeSlice([]interface{}{"bike", map[string]interface{}{"color": 3}, nil, uint64(1)}, buf)
+	if err == nil {
+		t.Error("Expected an error encoding a mistyped map value")
+	}
+
+	// every map type that MakeSchema accepts
+	names := []string{"strings", "floats", "ints"}
+	values = []interface{}{
+		map[string]string{"a": "b"},
+		map[string]float64{"pi": 3.25, "e": 2.75},
+		map[string]int64{"neg": -300, "pos": 200},
+	}
+	ms, err := MakeSchema(names, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	err = ms.EncodeSlice(values, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = make(map[string]interface{})
+	err = ms.DecodeToMap(buf, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mexpect := map[string]interface{}{
+		"strings": map[string]interface{}{"a": "b"},
+		"floats":  map[string]interface{}{"pi": 3.25, "e": 2.75},
+		"ints":    map[string]interface{}{"neg": int64(-300), "pos": int64(200)},
+	}
+	if !reflect.DeepEqual(m, mexpect) {
+		t.Errorf("MakeSchema maps: expected %v; got %v", mexpect, m)
+	}
+	err = ms.EncodeSlice([]interface{}{values[0], values[2], values[1]}, buf)
+	if err != ErrIncorrectType {
+		t.Errorf("Expected ErrIncorrectType for a map of the wrong Elem; got %v", err)
+	}
+}
+
+func TestSchemaTime(t *testing.T) {
+	when := time.Date(2014, 10, 22, 17, 30, 5, 250000000, time.UTC)
+	s, err := MakeSchema([]string{"name", "when", "history"}, []interface{}{"", when, []time.Time{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*s)[1].T != Time || (*s)[2].T != Array || (*s)[2].Elem != Time {
+		t.Fatalf("Bad schema: %v", *s)
+	}
+	values := []interface{}{"bike", when, []time.Time{time.Unix(0, 0).UTC(), when}}
+
+	buf := bytes.NewBuffer(nil)
+	err = s.EncodeSlice(values, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+
+	out := make([]interface{}, len(*s))
+	err = s.DecodeToSlice(bytes.NewReader(bts), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, values) {
+		t.Errorf("DecodeToSlice: expected %v; got %v", values, out)
+	}
+
+	out = make([]interface{}, len(*s))
+	err = s.DecodeToSliceZeroCopy(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, values) {
+		t.Errorf("DecodeToSliceZeroCopy: expected %v; got %v", values, out)
+	}
+
+	m := make(map[string]interface{})
+	err = s.DecodeToMap(bytes.NewReader(bts), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["when"] != when {
+		t.Errorf("DecodeToMap: expected %s; got %v", when, m["when"])
+	}
+
+	jbuf := bytes.NewBuffer(nil)
+	err = s.WriteJSON(bts, jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"name":"bike","when":"2014-10-22T17:30:05.25Z","history":["1970-01-01T00:00:00Z","2014-10-22T17:30:05.25Z"]}`
+	if jbuf.String() != expect {
+		t.Errorf("WriteJSON: expected %s; got %s", expect, jbuf.String())
+	}
+}
@@ -0,0 +1,193 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// jsonObject is the JSON form of an Object
+type jsonObject struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Optional bool            `json:"optional,omitempty"`
+	Elem     string          `json:"elem,omitempty"`
+	Schema   *Schema         `json:"schema,omitempty"`
+	Since    int             `json:"since,omitempty"`
+	Default  json.RawMessage `json:"default,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. A Schema is written as
+// an array of objects with "name" and "type" keys (see Type.String),
+// along with "optional", "elem", "schema", "since", and "default"
+// when they are relevant.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	jos := make([]jsonObject, len(*s))
+	for i, o := range *s {
+		jo := &jos[i]
+		jo.Name, jo.Type, jo.Optional, jo.Since = o.Name, o.T.String(), o.Optional, o.Since
+		switch o.T {
+		case Array, Map:
+			jo.Elem = o.Elem.String()
+		case Struct:
+			jo.Schema = o.sub()
+		}
+		if o.Default != nil {
+			d, err := json.Marshal(o.Default)
+			if err != nil {
+				return nil, err
+			}
+			jo.Default = d
+		}
+	}
+	return json.Marshal(jos)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Defaults
+// are converted to the types that EncodeSlice expects.
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	var jos []jsonObject
+	err := json.Unmarshal(b, &jos)
+	if err != nil {
+		return err
+	}
+	os := make([]Object, len(jos))
+	for i, jo := range jos {
+		o := &os[i]
+		o.Name, o.Optional, o.Since, o.Schema = jo.Name, jo.Optional, jo.Since, jo.Schema
+		var ok bool
+		o.T, ok = parseType(jo.Type)
+		if !ok {
+			return fmt.Errorf("msg: object %q has unknown type %q", jo.Name, jo.Type)
+		}
+		if jo.Elem != "" {
+			o.Elem, ok = parseType(jo.Elem)
+			if !ok {
+				return fmt.Errorf("msg: object %q has unknown elem type %q", jo.Name, jo.Elem)
+			}
+		}
+		if o.T == Struct && o.Schema == nil {
+			o.Schema = &Schema{}
+		}
+		o.Default, err = jsonValue(jo.Default, o)
+		if err != nil {
+			return fmt.Errorf("msg: object %q has a bad default: %s", jo.Name, err)
+		}
+	}
+	*s = Schema(os)
+	return nil
+}
+
+// jsonValue converts a JSON value into the
+// type that encode() expects for 'o'
+func jsonValue(raw json.RawMessage, o *Object) (interface{}, error) {
+	if len(raw) == 0 || bytes.Equal(raw, null) {
+		return nil, nil
+	}
+	switch o.T {
+	case Array:
+		v := jsonTarget(o.Elem, true)
+		if v == nil {
+			return nil, ErrTypeNotSupported
+		}
+		return deref(v, json.Unmarshal(raw, v))
+	case Map:
+		var rm map[string]json.RawMessage
+		err := json.Unmarshal(raw, &rm)
+		if err != nil {
+			return nil, err
+		}
+		eo := &Object{T: o.Elem}
+		m := make(map[string]interface{}, len(rm))
+		for k, r := range rm {
+			m[k], err = jsonValue(r, eo)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case Struct:
+		var ra []json.RawMessage
+		err := json.Unmarshal(raw, &ra)
+		if err != nil {
+			return nil, err
+		}
+		sub := o.sub()
+		if len(ra) != len(*sub) {
+			return nil, ErrBadArgs
+		}
+		a := make([]interface{}, len(ra))
+		for i := range ra {
+			a[i], err = jsonValue(ra[i], &(*sub)[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	default:
+		v := jsonTarget(o.T, false)
+		if v == nil {
+			return nil, ErrTypeNotSupported
+		}
+		return deref(v, json.Unmarshal(raw, v))
+	}
+}
+
+// jsonTarget returns a pointer to a value (or slice of values,
+// if 'slice' is true) of the Go type that corresponds to 't'
+func jsonTarget(t Type, slice bool) interface{} {
+	switch t {
+	case Int:
+		if slice {
+			return new([]int64)
+		}
+		return new(int64)
+	case Uint:
+		if slice {
+			return new([]uint64)
+		}
+		return new(uint64)
+	case Float:
+		if slice {
+			return new([]float64)
+		}
+		return new(float64)
+	case Bool:
+		if slice {
+			return new([]bool)
+		}
+		return new(bool)
+	case String:
+		if slice {
+			return new([]string)
+		}
+		return new(string)
+	case Bin:
+		if slice {
+			return new([][]byte)
+		}
+		return new([]byte)
+	case Time:
+		if slice {
+			return new([]time.Time)
+		}
+		return new(time.Time)
+	case Ext:
+		if slice {
+			return new([]*PackExt)
+		}
+		return new(*PackExt)
+	default:
+		return nil
+	}
+}
+
+// deref returns the value pointed to by a jsonTarget
+func deref(v interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(v).Elem().Interface(), nil
+}
@@ -0,0 +1,43 @@
+package msg
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSchemaJSON(t *testing.T) {
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "tags", T: Array, Elem: String, Optional: true},
+		{Name: "location", T: Struct, Schema: &Schema{{Name: "lat", T: Float}, {Name: "lon", T: Float}}},
+		{Name: "labels", T: Map, Elem: Int},
+		{Name: "uid", T: Uint, Since: 1, Default: uint64(1<<63 + 1)},
+		{Name: "seen", T: Time, Since: 1, Default: time.Unix(1414000000, 0).UTC()},
+		{Name: "readings", T: Array, Elem: Float, Since: 2, Default: []float64{0.5, 1}},
+		{Name: "origin", T: Struct, Schema: &Schema{{Name: "id", T: Int}}, Since: 2, Default: []interface{}{int64(-3)}},
+		{Name: "counts", T: Map, Elem: Uint, Since: 2, Default: map[string]interface{}{"a": uint64(4)}},
+	}
+	bts, err := json.Marshal(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snew := new(Schema)
+	err = json.Unmarshal(bts, snew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*snew, s) {
+		t.Errorf("Expected schema %v; got %v", s, *snew)
+	}
+
+	err = json.Unmarshal([]byte(`[{"name":"x","type":"complex"}]`), snew)
+	if err == nil {
+		t.Error("Expected an error for an unknown type")
+	}
+	err = json.Unmarshal([]byte(`[{"name":"x","type":"int","since":1,"default":"one"}]`), snew)
+	if err == nil {
+		t.Error("Expected an error for a mistyped default")
+	}
+}
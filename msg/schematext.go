@@ -0,0 +1,396 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// SyntaxError is returned by ParseSchema and ParseSchemaJSON,
+// and describes the position of an error in the source text.
+type SyntaxError struct {
+	// Line is the (1-based) line of the error
+	Line int
+	// Col is the (1-based) column of the error, in bytes
+	Col int
+	// Msg describes the error
+	Msg string
+}
+
+func (s *SyntaxError) Error() string {
+	return fmt.Sprintf("msg: schema:%d:%d: %s", s.Line, s.Col, s.Msg)
+}
+
+// ParseSchema parses the text form of a Schema, which is a
+// whitespace-separated list of objects written as name:type,
+// e.g.
+//
+//	name:string dir:string val:float
+//
+// Types are written as the names returned by Type.String. Arrays
+// are written as []elem, Maps as map[string]elem, and Structs as
+// a nested list of objects in braces, e.g. loc:{lat:float lon:float}.
+// Optional objects have a trailing '?', and objects with a version
+// have a trailing @since, optionally followed by =default, where the
+// default is a JSON value (see Schema.MarshalJSON):
+//
+//	name:string tags:[]string? charge:int@1=-1
+//
+// Names that contain characters other than letters, digits, '_',
+// '-', and '.' must be double-quoted. Text from '#' to the end of
+// a line is a comment. Errors are returned as a *SyntaxError.
+func ParseSchema(src string) (*Schema, error) {
+	p := &schemaParser{src: src}
+	s, err := p.schema(false)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ParseSchemaJSON parses the JSON form of a Schema (see
+// Schema.MarshalJSON). JSON syntax and type errors are
+// returned as a *SyntaxError.
+func ParseSchemaJSON(src []byte) (*Schema, error) {
+	s := new(Schema)
+	err := json.Unmarshal(src, s)
+	if err != nil {
+		var off int64
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			off = e.Offset
+		case *json.UnmarshalTypeError:
+			off = e.Offset
+		default:
+			return nil, err
+		}
+		line, col := position(src, int(off))
+		return nil, &SyntaxError{Line: line, Col: col, Msg: err.Error()}
+	}
+	return s, nil
+}
+
+// String returns the text form of the Schema (see ParseSchema).
+func (s *Schema) String() string {
+	buf := bytes.NewBuffer(nil)
+	s.writeText(buf)
+	return buf.String()
+}
+
+// MarshalText implements encoding.TextMarshaler
+// using the text form of the Schema (see ParseSchema).
+func (s *Schema) MarshalText() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := s.writeText(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+// using the text form of the Schema (see ParseSchema).
+func (s *Schema) UnmarshalText(text []byte) error {
+	ns, err := ParseSchema(string(text))
+	if err != nil {
+		return err
+	}
+	*s = *ns
+	return nil
+}
+
+// writeText writes the text form of the Schema
+func (s *Schema) writeText(buf *bytes.Buffer) error {
+	for i, o := range *s {
+		if i != 0 {
+			buf.WriteByte(' ')
+		}
+		if plainName(o.Name) {
+			buf.WriteString(o.Name)
+		} else {
+			buf.WriteString(strconv.Quote(o.Name))
+		}
+		buf.WriteByte(':')
+		switch o.T {
+		case Array:
+			buf.WriteString("[]")
+			buf.WriteString(o.Elem.String())
+		case Map:
+			buf.WriteString("map[string]")
+			buf.WriteString(o.Elem.String())
+		case Struct:
+			buf.WriteByte('{')
+			err := o.sub().writeText(buf)
+			if err != nil {
+				return err
+			}
+			buf.WriteByte('}')
+		default:
+			buf.WriteString(o.T.String())
+		}
+		if o.Optional {
+			buf.WriteByte('?')
+		}
+		if o.Since > 0 {
+			buf.WriteByte('@')
+			buf.WriteString(strconv.Itoa(o.Since))
+			if o.Default != nil {
+				d, err := json.Marshal(o.Default)
+				if err != nil {
+					return err
+				}
+				buf.WriteByte('=')
+				buf.Write(d)
+			}
+		}
+	}
+	return nil
+}
+
+// plainName returns whether or not a
+// name can be written without quotes
+func plainName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !nameByte(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func nameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.'
+}
+
+// position returns the line and column of src[off]
+func position(src []byte, off int) (line int, col int) {
+	if off > len(src) {
+		off = len(src)
+	}
+	line, col = 1, 1
+	for _, c := range src[:off] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return
+}
+
+// schemaParser is a recursive-descent
+// parser for the text form of a Schema
+type schemaParser struct {
+	src string
+	pos int
+}
+
+func (p *schemaParser) errorf(pos int, format string, args ...interface{}) error {
+	line, col := position([]byte(p.src[:pos]), pos)
+	return &SyntaxError{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// skip skips whitespace and comments
+func (p *schemaParser) skip() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// consume consumes 'tok' if it is next in the input
+func (p *schemaParser) consume(tok string) bool {
+	if len(p.src)-p.pos >= len(tok) && p.src[p.pos:p.pos+len(tok)] == tok {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+// schema parses a list of objects, up to the end
+// of the input or (if 'nested' is true) a closing '}'
+func (p *schemaParser) schema(nested bool) (*Schema, error) {
+	s := Schema{}
+	for {
+		p.skip()
+		if p.pos == len(p.src) {
+			if nested {
+				return nil, p.errorf(p.pos, "expected '}'")
+			}
+			return &s, nil
+		}
+		if nested && p.consume("}") {
+			return &s, nil
+		}
+		o, err := p.object()
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, o)
+	}
+}
+
+// object parses name:type[?][@since[=default]]
+func (p *schemaParser) object() (o Object, err error) {
+	o.Name, err = p.name()
+	if err != nil {
+		return
+	}
+	if !p.consume(":") {
+		err = p.errorf(p.pos, "expected ':' after %q", o.Name)
+		return
+	}
+
+	switch {
+	case p.consume("{"):
+		o.T = Struct
+		o.Schema, err = p.schema(true)
+		if err != nil {
+			return
+		}
+	case p.consume("[]"):
+		o.T = Array
+		o.Elem, err = p.typ()
+	case p.consume("map["):
+		if !p.consume("string]") {
+			err = p.errorf(p.pos, "map keys must be strings")
+			return
+		}
+		o.T = Map
+		o.Elem, err = p.typ()
+	default:
+		o.T, err = p.typ()
+	}
+	if err != nil {
+		return
+	}
+
+	o.Optional = p.consume("?")
+	if !p.consume("@") {
+		return
+	}
+	o.Since, err = p.since()
+	if err != nil {
+		return
+	}
+	if !p.consume("=") {
+		return
+	}
+	dpos := p.pos
+	raw := p.value()
+	o.Default, err = jsonValue(json.RawMessage(raw), &o)
+	if raw == "" {
+		err = p.errorf(dpos, "expected a default for %q", o.Name)
+	} else if err != nil {
+		err = p.errorf(dpos, "bad default for %q (%s): %s", o.Name, o.T, err)
+	}
+	return
+}
+
+// name parses a plain or quoted name
+func (p *schemaParser) name() (string, error) {
+	start := p.pos
+	if p.pos < len(p.src) && p.src[p.pos] == '"' {
+		// find the closing quote
+		i := p.pos + 1
+		for i < len(p.src) && p.src[i] != '"' {
+			if p.src[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(p.src) {
+			return "", p.errorf(start, "unterminated name")
+		}
+		name, err := strconv.Unquote(p.src[start : i+1])
+		if err != nil {
+			return "", p.errorf(start, "bad name: %s", err)
+		}
+		p.pos = i + 1
+		return name, nil
+	}
+	for p.pos < len(p.src) && nameByte(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf(start, "expected a name")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// typ parses a type name
+func (p *schemaParser) typ() (Type, error) {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] >= 'a' && p.src[p.pos] <= 'z' {
+		p.pos++
+	}
+	t, ok := parseType(p.src[start:p.pos])
+	if !ok {
+		return 0, p.errorf(start, "unknown type %q", p.src[start:p.pos])
+	}
+	return t, nil
+}
+
+// since parses a version number
+func (p *schemaParser) since() (int, error) {
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		p.pos++
+	}
+	v, err := strconv.Atoi(p.src[start:p.pos])
+	if err != nil || v == 0 {
+		return 0, p.errorf(start, "expected a version number greater than zero")
+	}
+	return v, nil
+}
+
+// value returns the JSON value at p.pos, which ends
+// at whitespace or a '}' outside of a string or brackets
+func (p *schemaParser) value() string {
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			for p.pos < len(p.src) && p.src[p.pos] != '"' {
+				if p.src[p.pos] == '\\' {
+					p.pos++
+				}
+				p.pos++
+			}
+		case '[', '{':
+			depth++
+		case ']':
+			depth--
+		case '}':
+			if depth == 0 {
+				return p.src[start:p.pos]
+			}
+			depth--
+		case ' ', '\t', '\r', '\n':
+			if depth == 0 {
+				return p.src[start:p.pos]
+			}
+		}
+		p.pos++
+	}
+	if p.pos > len(p.src) {
+		p.pos = len(p.src)
+	}
+	return p.src[start:p.pos]
+}
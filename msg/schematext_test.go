@@ -0,0 +1,100 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseSchema(t *testing.T) {
+	s, err := ParseSchema("name:string dir:string val:float")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Schema{{Name: "name", T: String}, {Name: "dir", T: String}, {Name: "val", T: Float}}
+	if !reflect.DeepEqual(*s, expect) {
+		t.Errorf("Expected schema %v; got %v", expect, *s)
+	}
+
+	text := `# a bike
+name:string "bike id":uint tags:[]string?
+location:{lat:float lon:float} labels:map[string]int seen:time?
+charge:int@1=-1 note:string@1="a } b" readings:[]float@2=[0.5, 1]
+origin:{id:int}@2=[-3] counts:map[string]uint@2={"a":4} extra:bin?@3`
+	s, err = ParseSchema(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*s) != 12 || (*s)[1].Name != "bike id" || (*s)[6].Default != int64(-1) || (*s)[7].Default != "a } b" {
+		t.Errorf("Bad schema %v", *s)
+	}
+
+	// text -> Schema -> Encode -> Decode -> text
+	buf := bytes.NewBuffer(nil)
+	s.Encode(buf)
+	snew := new(Schema)
+	err = snew.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*snew, *s) {
+		t.Errorf("Expected schema %v; got %v", *s, *snew)
+	}
+	again, err := ParseSchema(snew.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*again, *s) {
+		t.Errorf("Text round-trip: expected %v; got %v", *s, *again)
+	}
+
+	// the JSON form is equivalent
+	bts, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := ParseSchemaJSON(bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*js, *s) {
+		t.Errorf("JSON round-trip: expected %v; got %v", *s, *js)
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	tests := []struct {
+		text      string
+		line, col int
+	}{
+		{"name:string val", 1, 16},
+		{"name:string\nval:complex", 2, 5},
+		{"name:string\n  loc:{lat:float", 2, 17},
+		{"m:map[int]float", 1, 7},
+		{"x:int@0", 1, 7},
+		{"x:int@1=\"one\"", 1, 9},
+		{"x:int@1=", 1, 9},
+		{":int", 1, 1},
+	}
+	for i, tt := range tests {
+		_, err := ParseSchema(tt.text)
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Test case %d: expected *SyntaxError; got %v", i, err)
+			continue
+		}
+		if serr.Line != tt.line || serr.Col != tt.col {
+			t.Errorf("Test case %d: expected %d:%d; got %s", i, tt.line, tt.col, serr)
+		}
+	}
+
+	_, err := ParseSchemaJSON([]byte("[\n{\"name\":\"x\",\n\"type\":int}]"))
+	serr, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("Expected *SyntaxError; got %v", err)
+	}
+	if serr.Line != 3 {
+		t.Errorf("Expected an error on line 3; got %s", serr)
+	}
+}
@@ -0,0 +1,162 @@
+package msg
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrTrailingBytes is returned by Validate when
+// a message has bytes after its last object.
+var ErrTrailingBytes = errors.New("Message has trailing bytes.")
+
+// ValidationError is returned by Validate, and
+// describes where and why a message is malformed.
+type ValidationError struct {
+	// Index is the index of the Object in the Schema,
+	// or the length of the Schema for trailing bytes
+	Index int
+	// Name is the name of the value, including the names of
+	// enclosing Structs and indexes into Arrays (e.g. "loc.lat" or "tags[2]")
+	Name string
+	// Offset is the offset of the value in the message
+	Offset int
+	// Expected is the expected Type of the value
+	Expected Type
+	// Tag is the MessagePack tag at Offset, or
+	// 0 if the message ends before Offset
+	Tag byte
+	// Err is the underlying error (e.g. ErrBadTag,
+	// ErrShortBytes, or ErrTrailingBytes)
+	Err error
+}
+
+func (v *ValidationError) Error() string {
+	if v.Err == ErrTrailingBytes {
+		return fmt.Sprintf("msg: trailing bytes at offset %d (tag 0x%02x)", v.Offset, v.Tag)
+	}
+	found := "end of message"
+	if v.Err != ErrShortBytes || v.Tag != 0 {
+		found = fmt.Sprintf("tag 0x%02x (%s)", v.Tag, tagName(v.Tag))
+	}
+	return fmt.Sprintf("msg: object %d (%q) at offset %d: expected %s; found %s: %s",
+		v.Index, v.Name, v.Offset, v.Expected, found, v.Err)
+}
+
+// Unwrap returns v.Err, so that errors.Is
+// matches a *ValidationError to its cause.
+func (v *ValidationError) Unwrap() error { return v.Err }
+
+// Validate checks that 'p' is a complete message that matches the
+// Schema, without decoding it. It returns a *ValidationError describing
+// the first value that does not match, or the first byte after the
+// last object. Messages written with a newer version of the Schema
+// have trailing bytes (see Object.Since), so they should be validated
+// with the newest version.
+func (s *Schema) Validate(p []byte) error {
+	n, err := s.validate(p, 0, -1, "")
+	if err != nil {
+		return err
+	}
+	if n < len(p) {
+		return &ValidationError{
+			Index:  len(*s),
+			Offset: n,
+			Tag:    p[n],
+			Err:    ErrTrailingBytes,
+		}
+	}
+	return nil
+}
+
+// validate validates the objects starting at p[off], and returns
+// the offset after the last one. 'index' is the index of the
+// enclosing Struct, or -1, and 'prefix' is prepended to names.
+func (s *Schema) validate(p []byte, off int, index int, prefix string) (int, error) {
+	var err error
+	for i, o := range *s {
+		// older messages end before newer objects
+		if o.Since > 0 && off >= len(p) {
+			return off, nil
+		}
+		idx := index
+		if idx < 0 {
+			idx = i
+		}
+		off, err = validateValue(p, off, o, idx, prefix+o.Name)
+		if err != nil {
+			return off, err
+		}
+	}
+	return off, nil
+}
+
+// validateValue validates the value of 'o' at p[off]
+// and returns the offset after it
+func validateValue(p []byte, off int, o Object, index int, name string) (int, error) {
+	if o.Optional && IsNil(p[off:]) {
+		return off + 1, nil
+	}
+	var sz uint32
+	var n int
+	var err error
+	if err = o.checkElem(); err != nil {
+		return off, invalid(p, off, o.T, index, name, err)
+	}
+	switch o.T {
+	case Array:
+		sz, n, err = readArrayHeaderBytes(p[off:])
+		if err != nil {
+			return off, invalid(p, off, o.T, index, name, err)
+		}
+		off += n
+		eo := Object{T: o.Elem}
+		for i := uint32(0); i < sz; i++ {
+			off, err = validateValue(p, off, eo, index, name+"["+strconv.Itoa(int(i))+"]")
+			if err != nil {
+				return off, err
+			}
+		}
+		return off, nil
+
+	case Map:
+		sz, n, err = readMapHeaderBytes(p[off:])
+		if err != nil {
+			return off, invalid(p, off, o.T, index, name, err)
+		}
+		off += n
+		for i := uint32(0); i < sz; i++ {
+			var key string
+			key, n, err = readStringZeroCopy(p[off:])
+			if err != nil {
+				return off, invalid(p, off, String, index, name+"[key]", err)
+			}
+			off += n
+			n, err = skipScalar(p[off:], o.Elem)
+			if err != nil {
+				return off, invalid(p, off, o.Elem, index, name+"["+strconv.Quote(key)+"]", err)
+			}
+			off += n
+		}
+		return off, nil
+
+	case Struct:
+		return o.sub().validate(p, off, index, name+".")
+
+	default:
+		n, err = skipScalar(p[off:], o.T)
+		if err != nil {
+			return off, invalid(p, off, o.T, index, name, err)
+		}
+		return off + n, nil
+	}
+}
+
+// invalid returns a *ValidationError for the value at p[off]
+func invalid(p []byte, off int, t Type, index int, name string, err error) error {
+	v := &ValidationError{Index: index, Name: name, Offset: off, Expected: t, Err: err}
+	if off < len(p) {
+		v.Tag = p[off]
+	}
+	return v
+}
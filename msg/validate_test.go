@@ -0,0 +1,78 @@
+package msg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := iterSchema.EncodeSlice(iterValues, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := buf.Bytes()
+	err = iterSchema.Validate(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// older messages are valid
+	buf.Reset()
+	schemaV0.EncodeSlice([]interface{}{"bike", 3.5}, buf)
+	err = schemaV2.Validate(buf.Bytes())
+	if err != nil {
+		t.Errorf("Unexpected error for an old message: %s", err)
+	}
+
+	s := Schema{
+		{Name: "name", T: String},
+		{Name: "val", T: Float},
+		{Name: "tags", T: Array, Elem: String},
+		{Name: "loc", T: Struct, Schema: &Schema{{Name: "lat", T: Float}}},
+	}
+	valid := func() []byte {
+		buf := bytes.NewBuffer(nil)
+		s.EncodeSlice([]interface{}{"bike", 3.5, []string{"a", "b"}, []interface{}{1.0}}, buf)
+		return buf.Bytes()
+	}
+	corrupt := func(i int, c byte) []byte {
+		p := valid()
+		p[i] = c
+		return p
+	}
+
+	tests := []struct {
+		msg      []byte
+		index    int
+		name     string
+		offset   int
+		expected Type
+		tag      byte
+		err      error
+	}{
+		// "bike", then a string instead of a float
+		{append([]byte{0xa4, 'b', 'i', 'k', 'e'}, 0xa1, 'x'), 1, "val", 5, Float, 0xa1, ErrBadTag},
+		// truncated
+		{valid()[:7], 1, "val", 5, Float, 0xca, ErrShortBytes},
+		// missing the last object
+		{valid()[:15], 3, "loc.lat", 15, Float, 0, ErrShortBytes},
+		// an array element of the wrong type
+		{corrupt(13, 0x01), 2, "tags[1]", 13, String, 0x01, ErrBadTag},
+		// trailing bytes
+		{append(valid(), 0x01), 4, "", 20, 0, 0x01, ErrTrailingBytes},
+	}
+
+	for i, tt := range tests {
+		err := s.Validate(tt.msg)
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("Test case %d: expected *ValidationError; got %v", i, err)
+			continue
+		}
+		if verr.Index != tt.index || verr.Name != tt.name || verr.Offset != tt.offset ||
+			verr.Expected != tt.expected || verr.Tag != tt.tag || verr.Err != tt.err {
+			t.Errorf("Test case %d: got %#v", i, verr)
+		}
+	}
+}
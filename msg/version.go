@@ -0,0 +1,194 @@
+package msg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// CompatError is returned by (*Schema).Compatible when
+// a Schema cannot safely replace an older version.
+type CompatError struct {
+	// Index is the position of the offending object
+	Index int
+	// Name is the name of the offending object
+	Name string
+	// Reason describes the problem
+	Reason string
+}
+
+func (c *CompatError) Error() string {
+	return fmt.Sprintf("msg: Schema object %d (%q) is not compatible: %s", c.Index, c.Name, c.Reason)
+}
+
+// Version returns the version of the Schema, which
+// is the greatest Since of any of its objects.
+func (s *Schema) Version() int {
+	var v int
+	for _, o := range *s {
+		if o.Since > v {
+			v = o.Since
+		}
+	}
+	return v
+}
+
+// Compatible returns nil if *s is a safe evolution of 'old'; that is,
+// if messages written with 'old' can be decoded with *s (appended objects
+// take their Default values) and messages written with *s can be decoded
+// with 'old' (appended objects are ignored). Otherwise, it returns
+// a *CompatError describing the first problem.
+//
+// A compatible Schema has all of the objects in 'old', unchanged (with
+// the same Defaults) and in the same order, followed by zero or more
+// objects with a Since greater than old.Version() and a valid Default
+// (or Optional with no Default). Objects in nested Structs may not have
+// a Since (see Object.Since). The Fingerprints of a compatible Schema
+// include old.Fingerprint(), so (*Schema).Unwrap accepts old messages.
+func (s *Schema) Compatible(old *Schema) error {
+	for i := range *s {
+		if name := nestedVersion(&(*s)[i]); name != "" {
+			return &CompatError{Index: i, Name: (*s)[i].Name, Reason: fmt.Sprintf("nested object %q has a version", name)}
+		}
+	}
+	if len(*s) < len(*old) {
+		o := (*old)[len(*s)]
+		return &CompatError{Index: len(*s), Name: o.Name, Reason: "object was removed"}
+	}
+	for i, o := range *old {
+		if !sameObject(&o, &(*s)[i]) {
+			return &CompatError{Index: i, Name: o.Name, Reason: "object was changed"}
+		}
+	}
+	since := old.Version() + 1
+	for i := len(*old); i < len(*s); i++ {
+		o := &(*s)[i]
+		if o.Since < since {
+			return &CompatError{Index: i, Name: o.Name, Reason: fmt.Sprintf("appended object has version %d; expected %d or greater", o.Since, since)}
+		}
+		since = o.Since
+		if o.Default == nil {
+			if !o.Optional {
+				return &CompatError{Index: i, Name: o.Name, Reason: "appended object has no Default and is not Optional"}
+			}
+			continue
+		}
+		if encode(o.Default, *o, bytes.NewBuffer(nil)) != nil {
+			return &CompatError{Index: i, Name: o.Name, Reason: fmt.Sprintf("Default (%T) does not match Type %s", o.Default, o.T)}
+		}
+	}
+	return nil
+}
+
+// nestedVersion returns the name of the first object
+// with a Since in the Schema of a Struct 'o', or ""
+func nestedVersion(o *Object) string {
+	if o.T != Struct {
+		return ""
+	}
+	for i := range *o.sub() {
+		so := &(*o.sub())[i]
+		if so.Since > 0 {
+			return so.Name
+		}
+		if name := nestedVersion(so); name != "" {
+			return so.Name + "." + name
+		}
+	}
+	return ""
+}
+
+// sameObject returns whether or not two objects
+// have the same encoding and the same Default
+func sameObject(a *Object, b *Object) bool {
+	if a.Name != b.Name || a.T != b.T || a.Optional != b.Optional || a.Since != b.Since {
+		return false
+	}
+	if a.Since > 0 {
+		abuf, bbuf := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+		a.encodeDefault(abuf)
+		b.encodeDefault(bbuf)
+		if !bytes.Equal(abuf.Bytes(), bbuf.Bytes()) {
+			return false
+		}
+	}
+	switch a.T {
+	case Array, Map:
+		return a.Elem == b.Elem
+	case Struct:
+		as, bs := a.sub(), b.sub()
+		if len(*as) != len(*bs) {
+			return false
+		}
+		for i := range *as {
+			if !sameObject(&(*as)[i], &(*bs)[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// defaultObject returns an Object that can
+// encode and decode the Default of 'o'
+func (o *Object) defaultObject() Object {
+	return Object{T: o.T, Elem: o.Elem, Schema: o.Schema, Optional: true}
+}
+
+// encodeDefault writes o.Default, or nil if there is no
+// Default or it does not match o.T (see Compatible)
+func (o *Object) encodeDefault(w Writer) {
+	buf := bytes.NewBuffer(nil)
+	if encode(o.Default, o.defaultObject(), buf) != nil {
+		writeNil(w)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// decodeDefault reads a value written by encodeDefault
+func (o *Object) decodeDefault(r Reader) (interface{}, error) {
+	var v [1]interface{}
+	ds := Schema{o.defaultObject()}
+	err := ds.DecodeToSlice(r, v[:])
+	return v[0], err
+}
+
+// writeJSONDefault writes o.Default as JSON
+func (o *Object) writeJSONDefault(w Writer, empty []byte, opt *JSONOptions) error {
+	buf := bytes.NewBuffer(nil)
+	do := o.defaultObject()
+	err := encode(o.Default, do, buf)
+	if err != nil {
+		return err
+	}
+	_, err = writeJSONValue(buf.Bytes(), &do, w, empty, opt)
+	return err
+}
+
+// defaultsToSlice fills v[i:] with the Defaults of (*s)[i:]
+func (s *Schema) defaultsToSlice(i int, v []interface{}) {
+	for ; i < len(*s); i++ {
+		v[i] = (*s)[i].Default
+	}
+}
+
+// defaultsToMap fills 'm' with the Defaults of (*s)[i:]
+func (s *Schema) defaultsToMap(i int, m map[string]interface{}) {
+	for _, o := range (*s)[i:] {
+		m[o.Name] = o.Default
+	}
+}
+
+// atEOF returns whether or not 'r' has been
+// exhausted, without consuming anything if it hasn't
+func atEOF(r Reader) (bool, error) {
+	_, err := r.ReadByte()
+	if err == io.EOF {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, r.UnreadByte()
+}
@@ -0,0 +1,218 @@
+package msg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	schemaV0 = Schema{
+		{Name: "name", T: String},
+		{Name: "val", T: Float},
+	}
+	schemaV2 = Schema{
+		{Name: "name", T: String},
+		{Name: "val", T: Float},
+		{Name: "charge", T: Int, Since: 1, Default: int64(-1)},
+		{Name: "ok", T: Bool, Since: 1, Default: true},
+		{Name: "tags", T: Array, Elem: String, Since: 2, Optional: true},
+	}
+)
+
+func TestSchemaVersion(t *testing.T) {
+	if v := schemaV0.Version(); v != 0 {
+		t.Errorf("Expected version 0; got %d", v)
+	}
+	if v := schemaV2.Version(); v != 2 {
+		t.Errorf("Expected version 2; got %d", v)
+	}
+
+	// Since and Default survive Encode/Decode
+	buf := bytes.NewBuffer(nil)
+	schemaV2.Encode(buf)
+	snew := new(Schema)
+	err := snew.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*snew, schemaV2) {
+		t.Errorf("Expected schema %v; got %v", schemaV2, *snew)
+	}
+}
+
+func TestDecodeOldWithNew(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := schemaV0.EncodeSlice([]interface{}{"bike", 3.5}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+	expect := []interface{}{"bike", 3.5, int64(-1), true, nil}
+
+	out := make([]interface{}, len(schemaV2))
+	err = schemaV2.DecodeToSlice(bytes.NewReader(bts), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, expect) {
+		t.Errorf("DecodeToSlice: expected %v; got %v", expect, out)
+	}
+
+	out = make([]interface{}, len(schemaV2))
+	err = schemaV2.DecodeToSliceZeroCopy(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, expect) {
+		t.Errorf("DecodeToSliceZeroCopy: expected %v; got %v", expect, out)
+	}
+
+	m := make(map[string]interface{})
+	err = schemaV2.DecodeToMap(bytes.NewReader(bts), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["charge"] != int64(-1) || m["ok"] != true || m["tags"] != nil {
+		t.Errorf("DecodeToMap: bad defaults in %v", m)
+	}
+
+	jbuf := bytes.NewBuffer(nil)
+	err = schemaV2.WriteJSON(bts, jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jexpect := `{"name":"bike","val":3.5,"charge":-1,"ok":true,"tags":null}`
+	if jbuf.String() != jexpect {
+		t.Errorf("WriteJSON: expected %s; got %s", jexpect, jbuf.String())
+	}
+
+	// a version 1 message only needs the version 2 default
+	buf.Reset()
+	schemaV1 := schemaV2[:4]
+	err = schemaV1.EncodeSlice([]interface{}{"bike", 3.5, int64(4), false}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jbuf.Reset()
+	err = schemaV2.WriteJSON(buf.Bytes(), jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jexpect = `{"name":"bike","val":3.5,"charge":4,"ok":false,"tags":null}`
+	if jbuf.String() != jexpect {
+		t.Errorf("WriteJSON: expected %s; got %s", jexpect, jbuf.String())
+	}
+
+	// objects without a version are still required
+	if schemaV2.DecodeToMap(bytes.NewReader(bts[:5]), m) == nil {
+		t.Error("Expected an error decoding a truncated message")
+	}
+}
+
+func TestDecodeNewWithOld(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	err := schemaV2.EncodeSlice([]interface{}{"bike", 3.5, int64(4), false, []string{"a"}}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts := buf.Bytes()
+
+	out := make([]interface{}, len(schemaV0))
+	err = schemaV0.DecodeToSliceZeroCopy(bts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, []interface{}{"bike", 3.5}) {
+		t.Errorf("DecodeToSliceZeroCopy: got %v", out)
+	}
+
+	jbuf := bytes.NewBuffer(nil)
+	err = schemaV0.WriteJSON(bts, jbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jexpect := `{"name":"bike","val":3.5}`
+	if jbuf.String() != jexpect {
+		t.Errorf("WriteJSON: expected %s; got %s", jexpect, jbuf.String())
+	}
+}
+
+func TestSchemaCompatible(t *testing.T) {
+	if err := schemaV2.Compatible(&schemaV0); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := schemaV2.Compatible(&schemaV2); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+
+	schemaV1 := schemaV2[:4]
+	tests := []struct {
+		s     Schema
+		old   Schema
+		index int
+	}{
+		// removed an object
+		{schemaV0[:1], schemaV0, 1},
+		// changed a type
+		{Schema{{Name: "name", T: String}, {Name: "val", T: Int}}, schemaV0, 1},
+		// appended without a version
+		{append(schemaV0[:2:2], Object{Name: "x", T: Int, Default: int64(0)}), schemaV0, 2},
+		// appended without a default
+		{append(schemaV0[:2:2], Object{Name: "x", T: Int, Since: 1}), schemaV0, 2},
+		// mistyped default
+		{append(schemaV0[:2:2], Object{Name: "x", T: Int, Since: 1, Default: "zero"}), schemaV0, 2},
+		// appended with an old version
+		{append(schemaV1[:4:4], Object{Name: "x", T: Int, Since: 1, Default: int64(0)}), schemaV1, 4},
+		// versioned inside a Struct, even at the end
+		{append(schemaV0[:2:2], Object{Name: "s", T: Struct, Since: 1, Optional: true, Schema: &Schema{
+			{Name: "a", T: Int},
+			{Name: "b", T: Int, Since: 1, Default: int64(0)},
+		}}), schemaV0, 2},
+		{Schema{{Name: "s", T: Struct, Schema: &Schema{
+			{Name: "t", T: Struct, Schema: &Schema{{Name: "b", T: Int, Since: 1, Default: int64(0)}}},
+		}}}, Schema{}, 0},
+	}
+	for i, tt := range tests {
+		err := tt.s.Compatible(&tt.old)
+		cerr, ok := err.(*CompatError)
+		if !ok {
+			t.Errorf("Test case %d: expected *CompatError; got %v", i, err)
+			continue
+		}
+		if cerr.Index != tt.index {
+			t.Errorf("Test case %d: expected index %d; got %s", i, tt.index, cerr)
+		}
+	}
+
+	s := append(schemaV0[:2:2], Object{Name: "x", T: Int, Since: 1, Default: "zero"})
+	if err := s.Compatible(&schemaV0); err == nil || !strings.Contains(err.Error(), "Type int") {
+		t.Errorf("Expected the type name in %v", err)
+	}
+}
+
+func TestNestedVersion(t *testing.T) {
+	for i, s := range []Schema{
+		{{Name: "s", T: Struct, Schema: &Schema{
+			{Name: "a", T: Int},
+			{Name: "b", T: Int, Since: 1, Default: int64(0)},
+		}}},
+		{{Name: "s", T: Struct, Schema: &Schema{
+			{Name: "t", T: Struct, Schema: &Schema{{Name: "b", T: Int, Since: 1, Default: int64(0)}}},
+		}}},
+	} {
+		buf := bytes.NewBuffer(nil)
+		s.Encode(buf)
+		if err := new(Schema).Decode(buf); err != ErrNestedVersion {
+			t.Errorf("case %d: Decode: expected ErrNestedVersion; got %v", i, err)
+		}
+		if _, err := s.Compile(); err == nil || !strings.Contains(err.Error(), "has a version") {
+			t.Errorf("case %d: Compile: expected a nested version error; got %v", i, err)
+		}
+		err := s.Validate(AppendInt(AppendInt(nil, 1), 0))
+		if verr, ok := err.(*ValidationError); !ok || verr.Err != ErrNestedVersion {
+			t.Errorf("case %d: Validate: expected ErrNestedVersion; got %v", i, err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"io"
 	"math"
+	"time"
 	"unsafe"
 )
 
@@ -42,6 +43,26 @@ func writeMapHeader(w Writer, n uint32) {
 	}
 }
 
+func writeArrayHeader(w Writer, n uint32) {
+	switch {
+	case n < 16:
+		//fixarray is 1001XXXX
+		w.WriteByte(mfixarray | byte(n))
+
+	case n < 1<<16-1:
+		w.WriteByte(marray16)
+		w.WriteByte(byte(n >> 8))
+		w.WriteByte(byte(n))
+
+	default:
+		w.WriteByte(marray32)
+		w.WriteByte(byte(n >> 24))
+		w.WriteByte(byte(n >> 16))
+		w.WriteByte(byte(n >> 8))
+		w.WriteByte(byte(n))
+	}
+}
+
 func writeNil(w Writer) { w.WriteByte(mnil) }
 
 func writeFloat(w Writer, f float64) {
@@ -307,3 +328,32 @@ func writeUint(w Writer, v uint64) {
 		w.WriteByte(byte(v))
 	}
 }
+
+// writeTime writes a timestamp extension in the
+// smallest of the 32-, 64-, or 96-bit forms
+func writeTime(w Writer, t time.Time) {
+	var bs [12]byte
+	secs := t.Unix()
+	nsec := uint32(t.Nanosecond())
+	if uint64(secs)>>34 == 0 {
+		d := uint64(nsec)<<34 | uint64(secs)
+		if d>>32 == 0 {
+			bigend.PutUint32(bs[:4], uint32(d))
+			w.WriteByte(mfixext4)
+			w.WriteByte(mtimeExt)
+			w.Write(bs[:4])
+			return
+		}
+		bigend.PutUint64(bs[:8], d)
+		w.WriteByte(mfixext8)
+		w.WriteByte(mtimeExt)
+		w.Write(bs[:8])
+		return
+	}
+	bigend.PutUint32(bs[:4], nsec)
+	bigend.PutUint64(bs[4:], uint64(secs))
+	w.WriteByte(mext8)
+	w.WriteByte(12)
+	w.WriteByte(mtimeExt)
+	w.Write(bs[:])
+}